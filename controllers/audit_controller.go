@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/middlewares"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Build the audit entry of a write made by the authenticated user. A nil
+// before is a creation.
+func auditEntry(r *http.Request, action string, before *models.Book, after *models.Book) models.AuditEntry {
+	entry := models.AuditEntry{
+		ID:        primitive.NewObjectID(),
+		Actor:     middlewares.Username(r.Context()),
+		Role:      middlewares.Role(r.Context()),
+		Action:    action,
+		Changes:   models.DiffBooks(before, after),
+		RequestID: middlewares.RequestID(r.Context()),
+		Timestamp: time.Now().UTC(),
+	}
+
+	if after != nil {
+		entry.BookID = after.ID
+	} else if before != nil {
+		entry.BookID = before.ID
+	}
+
+	return entry
+}
+
+// Append to the audit log. The write itself already happened, so a failure
+// is logged rather than returned to the client.
+func recordAudit(r *http.Request, entries ...models.AuditEntry) {
+	if err := repository.Audit.Append(entries, r.Context()); err != nil {
+		logger.Log.WithError(err).WithField("request_id", middlewares.RequestID(r.Context())).Error("Audit log write failed!! 👎")
+	}
+}
+
+// The state a book is left in by a soft delete
+func trashedBook(book models.Book, deletedBy string, now time.Time) models.Book {
+	book.DeletedAt = &now
+	book.DeletedBy = deletedBy
+	book.UpdatedAt = now
+	book.Version++
+
+	return book
+}
+
+func timeParam(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		return time.Time{}, errors.New(name + " must be an RFC 3339 timestamp")
+	}
+
+	return parsed, nil
+}
+
+func parseAuditQuery(r *http.Request) (models.AuditQuery, error) {
+	values := r.URL.Query()
+	query := models.AuditQuery{Actor: values.Get("actor"), Limit: models.DefaultAuditPageSize}
+	var err error
+
+	if bookId := values.Get("book_id"); bookId != "" {
+		if query.BookID, err = primitive.ObjectIDFromHex(bookId); err != nil {
+			return query, errors.New("book_id must be a book id")
+		}
+	}
+
+	if query.From, err = timeParam(r, "from"); err != nil {
+		return query, err
+	}
+
+	if query.To, err = timeParam(r, "to"); err != nil {
+		return query, err
+	}
+
+	if values.Has("limit") {
+		query.Limit, err = strconv.Atoi(values.Get("limit"))
+
+		if err != nil || query.Limit < 1 || query.Limit > models.MaxAuditPageSize {
+			return query, errors.New("limit must be between 1 and " + strconv.Itoa(models.MaxAuditPageSize))
+		}
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		if query.After, err = primitive.ObjectIDFromHex(cursor); err != nil {
+			return query, errors.New("invalid cursor")
+		}
+	}
+
+	return query, nil
+}
+
+// GetAuditLog godoc
+// @Summary Query the audit log
+// @Description Retrieve the audit trail of catalog writes, newest first (Admin only). Follow next_cursor to get older entries.
+// @Tags audit
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param actor query string false "Username that made the change"
+// @Param book_id query string false "Book ID"
+// @Param from query string false "Earliest timestamp (RFC 3339)"
+// @Param to query string false "Latest timestamp (RFC 3339)"
+// @Param limit query int false "Page size (1-500, default 50)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Success 200 {object} models.AuditPage
+// @Failure 400 {object} problem.Details "Bad request - invalid query parameter"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /audit [get]
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query, err := parseAuditQuery(r)
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, err := repository.Audit.List(query, r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	page := models.AuditPage{Data: entries}
+
+	if len(entries) == query.Limit {
+		page.NextCursor = entries[len(entries)-1].ID.Hex()
+	}
+
+	json.NewEncoder(w).Encode(page)
+}
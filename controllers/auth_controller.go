@@ -1,53 +1,60 @@
 package controllers
 
 import (
-	"crypto/rsa"
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/BULLKNIGHT/bookstore/keys"
 	"github.com/BULLKNIGHT/bookstore/logger"
 	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/BULLKNIGHT/bookstore/validation"
 	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// Load and decode private key
-func loadPrivateKey() *rsa.PrivateKey {
-	privateKeyB64 := os.Getenv("JWT_PRIVATE_KEY_B64")
+var errInvalidCredentials = errors.New("invalid name or password")
 
-	privateKeyPEM, err := base64.StdEncoding.DecodeString(privateKeyB64)
-	if err != nil {
-		logger.Log.WithError(err).Fatal("Failed to decode private key")
-	}
+// Hash compared against when the user does not exist, so that unknown names
+// take as long to reject as wrong passwords.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("bookstore-dummy-password"), bcrypt.DefaultCost)
+
+func generateJWT(username string, role string) (string, error) {
+	tokenID, err := randomToken(16)
 
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyPEM)
 	if err != nil {
-		logger.Log.WithError(err).Fatal("Failed to parse private key")
+		return "", err
 	}
 
-	return privateKey
-}
-
-func generateJWT(username string, role string) (string, error) {
 	// Create token claims
 	claims := jwt.MapClaims{
 		"username": username,
 		"role":     role,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(), // 24-hour expiry
+		"jti":      tokenID,
+		"exp":      time.Now().Add(accessTokenTTL).Unix(), // short-lived, renewed with the refresh token
 		"iat":      time.Now().Unix(),
 	}
 
 	// Create token
 	token := jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
 
-	// Load private key
-	privateKey := loadPrivateKey()
+	// Current signing key, its kid tells verifiers which public key to use
+	key, err := keys.Default.Current()
+
+	if err != nil {
+		return "", err
+	}
+
+	token.Header["kid"] = key.ID
 
 	// Sign token with your secret
-	signedToken, err := token.SignedString(privateKey)
+	signedToken, err := token.SignedString(key.Private)
 
 	if err != nil {
 		return "", err
@@ -56,57 +63,202 @@ func generateJWT(username string, role string) (string, error) {
 	return signedToken, nil
 }
 
-func validateUser(r *http.Request) (models.User, error) {
-	// no json data send
-	if r.Body == nil {
-		return models.User{}, errors.New("no data found")
+func findUser(name string, ctx context.Context) (models.User, error) {
+	return repository.Users.FindByName(name, ctx)
+}
+
+func insertUser(user models.User, ctx context.Context) error {
+	if err := repository.Users.Insert(user, ctx); err != nil {
+		return err
 	}
 
-	var user models.User
-	err := json.NewDecoder(r.Body).Decode(&user)
+	logger.Log.WithField("id", user.ID).Info("User registered successfully!! 👌")
+	return nil
+}
+
+func newUser(credentials models.Credentials, role string) (models.User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(credentials.Password), bcrypt.DefaultCost)
 
-	// error during parsing json data
 	if err != nil {
-		return models.User{}, errors.New("invalid data")
+		return models.User{}, err
 	}
 
-	// validate required field
-	if !user.IsValid() {
-		return models.User{}, errors.New("all fields (name and role) are required")
+	return models.User{
+		ID:           primitive.NewObjectID(),
+		Name:         credentials.Name,
+		Role:         role,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now().UTC(),
+	}, nil
+}
+
+// authenticate verifies the credentials and returns the stored user
+func authenticate(credentials models.Credentials, ctx context.Context) (models.User, error) {
+	user, err := findUser(credentials.Name, ctx)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(credentials.Password))
+		return models.User{}, errInvalidCredentials
+	}
+
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(credentials.Password)); err != nil {
+		return models.User{}, errInvalidCredentials
 	}
 
 	return user, nil
 }
 
+// Decode and check the credentials of a login, or of a new account
+func validateUser(r *http.Request, newAccount bool) (models.Credentials, error) {
+	var credentials models.Credentials
+
+	if err := validation.DecodeJSON(r.Body, &credentials); err != nil {
+		return models.Credentials{}, err
+	}
+
+	if err := credentials.Validate(newAccount).Err(); err != nil {
+		return models.Credentials{}, err
+	}
+
+	return credentials, nil
+}
+
+// SeedAdmin creates the admin account from ADMIN_NAME / ADMIN_PASSWORD if it
+// does not exist yet. Admins cannot be created through the public API.
+func SeedAdmin(ctx context.Context) error {
+	credentials := models.Credentials{
+		Name:     os.Getenv("ADMIN_NAME"),
+		Password: os.Getenv("ADMIN_PASSWORD"),
+	}
+
+	if !credentials.IsValid() {
+		logger.Log.Warn("ADMIN_NAME or ADMIN_PASSWORD not set, skipping admin seed")
+		return nil
+	}
+
+	_, err := findUser(credentials.Name, ctx)
+
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+
+	admin, err := newUser(credentials, models.RoleAdmin)
+
+	if err != nil {
+		return err
+	}
+
+	return insertUser(admin, ctx)
+}
+
+// Register godoc
+// @Summary Register a user
+// @Description Create a new user account with the "user" role
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param credentials body models.Credentials true "User name and password"
+// @Success 201 {object} models.User
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 409 {object} problem.Details "User name already taken"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /register [post]
+func Register(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	credentials, err := validateUser(r, true)
+
+	if err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	user, err := newUser(credentials, models.RoleUser)
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = insertUser(user, r.Context())
+
+	if errors.Is(err, repository.ErrDuplicate) {
+		problem.New(problem.TypeDuplicate, http.StatusConflict, "user name already taken").Write(w, r)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
 // GenerateToken godoc
 // @Summary Generate JWT token
-// @Description Generate a JWT token for user authentication
+// @Description Log in with name and password and receive an access token carrying the stored role plus a refresh token
 // @Tags authentication
 // @Accept json
 // @Produce json
-// @Param user body models.User true "User credentials (name and role)"
-// @Success 200 {object} string "JWT token"
-// @Failure 400 {object} string "Bad request - invalid user data"
-// @Failure 500 {object} string "Internal server error - token generation failed"
+// @Param credentials body models.Credentials true "User name and password"
+// @Success 200 {object} models.TokenPair
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Invalid name or password"
+// @Failure 500 {object} problem.Details "Internal server error - token generation failed"
 // @Router /token [post]
+// @Router /login [post]
 func GenerateToken(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	user, err := validateUser(r)
+	credentials, err := validateUser(r, false)
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(err.Error())
+		problem.Invalid(w, r, err)
 		return
 	}
 
-	token, err := generateJWT(user.Name, user.Role)
+	user, err := authenticate(credentials, r.Context())
+
+	if errors.Is(err, errInvalidCredentials) {
+		problem.Error(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(err.Error())
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	json.NewEncoder(w).Encode(token)
+	tokens, err := issueTokens(user, "", r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// JWKS godoc
+// @Summary JSON Web Key Set
+// @Description Public keys that verify bookstore tokens, selected by the kid token header
+// @Tags authentication
+// @Produce json
+// @Success 200 {object} keys.JWKSet
+// @Router /.well-known/jwks.json [get]
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	json.NewEncoder(w).Encode(keys.Default.JWKS())
 }
@@ -1,155 +1,368 @@
 package controllers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/BULLKNIGHT/bookstore/db"
+	"github.com/BULLKNIGHT/bookstore/isbn"
 	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/middlewares"
 	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/patch"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/BULLKNIGHT/bookstore/validation"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func getAllBooks(ctx context.Context) ([]models.Book, error) {
-	filter := bson.M{}
-	cursor, err := db.Collection.Find(ctx, filter)
+// ISBNs stay taken while their book is in the trash
+var errDuplicateIsbn = errors.New("a book with this ISBN already exists, it may be in the trash")
 
-	var books []models.Book
+// Check the ISBN of a book, if it has one, and store it in canonical form
+func normalizeIsbn(book *models.Book) error {
+	if book.Isbn == "" {
+		return nil
+	}
+
+	normalized, err := isbn.Normalize(book.Isbn)
 
 	if err != nil {
-		return books, err
+		return err
 	}
 
-	for cursor.Next(ctx) {
-		var book models.Book
+	book.Isbn = normalized
+	return nil
+}
 
-		if err = cursor.Decode(&book); err != nil {
-			return books, err
-		}
+func getAllBooks(query models.BookQuery, ctx context.Context) ([]models.Book, int64, error) {
+	books, total, err := repository.Books.List(query, ctx)
 
-		books = append(books, book)
+	if err != nil {
+		return books, 0, err
 	}
 
-	defer cursor.Close(ctx)
+	logger.Log.WithField("count", len(books)).Info("Books fetched successfully!! ✅")
 
-	logger.Log.Info("All books fetched successfully!! ✅")
-
-	return books, nil
+	return books, total, nil
 }
 
-func insertBook(book models.Book, ctx context.Context) (*mongo.InsertOneResult, error) {
-	result, err := db.Collection.InsertOne(ctx, book)
+func getBook(bookId primitive.ObjectID, ctx context.Context) (models.Book, error) {
+	return repository.Books.Get(bookId, ctx)
+}
 
-	if err != nil {
-		return result, err
+func insertBook(book models.Book, ctx context.Context) error {
+	if err := repository.Books.Insert(book, ctx); err != nil {
+		return err
 	}
 
-	logger.Log.WithField("id", result.InsertedID).Info("Book inserted successfully!! 👌")
-	return result, nil
+	logger.Log.WithField("id", book.ID).Info("Book inserted successfully!! 👌")
+	return nil
 }
 
-func updateBook(book models.Book, ctx context.Context) (*mongo.UpdateResult, error) {
-	filter := bson.M{"_id": book.ID}
-	update := bson.M{"$set": book}
-
-	result, err := db.Collection.UpdateOne(ctx, filter, update)
+func updateBook(book models.Book, expectedVersion int, ctx context.Context) (models.Book, error) {
+	updated, err := repository.Books.Update(book, expectedVersion, ctx)
 
 	if err != nil {
-		return result, err
+		return updated, err
 	}
 
-	logger.Log.WithField("modified_count", result.ModifiedCount).Info("Book updated successfully!! 👌")
-	return result, nil
+	logger.Log.WithField("id", book.ID).WithField("version", updated.Version).Info("Book updated successfully!! 👌")
+	return updated, nil
 }
-func deleteBook(bookId primitive.ObjectID, ctx context.Context) (*mongo.DeleteResult, error) {
-	filter := bson.M{"_id": bookId}
-	result, err := db.Collection.DeleteOne(ctx, filter)
 
-	if err != nil {
-		return result, err
+func deleteBook(bookId primitive.ObjectID, expectedVersion int, deletedBy string, now time.Time, ctx context.Context) error {
+	if err := repository.Books.Delete(bookId, expectedVersion, deletedBy, now, ctx); err != nil {
+		return err
 	}
 
-	logger.Log.WithField("delete_count", result.DeletedCount).Info("Book deleted successfully!! ✅")
-	return result, nil
+	logger.Log.WithField("id", bookId).WithField("deleted_by", deletedBy).Info("Book moved to trash successfully!! 🗑️")
+	return nil
 }
 
-func deleteAllBooks(ctx context.Context) (*mongo.DeleteResult, error) {
-	filter := bson.M{}
-	result, err := db.Collection.DeleteMany(ctx, filter)
+func intParam(values url.Values, name string) (int, error) {
+	value := values.Get(name)
 
-	if err != nil {
-		return result, err
+	if value == "" {
+		return 0, nil
 	}
 
-	logger.Log.WithField("delete_count", result.DeletedCount).Info("All books deleted successfully!! ✅")
-	return result, nil
+	number, err := strconv.Atoi(value)
+
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+
+	return number, nil
 }
 
-func validateBook(r *http.Request) (models.Book, error) {
-	// no json data send
-	if r.Body == nil {
-		return models.Book{}, errors.New("no data found")
+func parseBookQuery(r *http.Request) (models.BookQuery, error) {
+	values := r.URL.Query()
+	query := models.BookQuery{
+		Author:   values.Get("author"),
+		Category: values.Get("category"),
+		Limit:    models.DefaultPageSize,
+	}
+
+	var err error
+
+	for name, target := range map[string]*int{
+		"min_year":  &query.MinYear,
+		"max_year":  &query.MaxYear,
+		"min_price": &query.MinPrice,
+		"max_price": &query.MaxPrice,
+	} {
+		if *target, err = intParam(values, name); err != nil {
+			return query, err
+		}
 	}
 
+	if values.Has("limit") {
+		query.Limit, err = strconv.Atoi(values.Get("limit"))
+
+		if err != nil || query.Limit < 1 || query.Limit > models.MaxPageSize {
+			return query, fmt.Errorf("limit must be between 1 and %d", models.MaxPageSize)
+		}
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		query.Descending = strings.HasPrefix(sort, "-")
+		field, ok := models.BookSortFields[strings.TrimPrefix(sort, "-")]
+
+		if !ok {
+			return query, errors.New("sort must be one of title, price, year (prefix with - for descending)")
+		}
+
+		query.SortField = field
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		if query.After, err = models.DecodeBookCursor(cursor, query.SortField); err != nil {
+			return query, err
+		}
+	}
+
+	return query, nil
+}
+
+// Link to the page after the given last book, keeping every other parameter
+func nextPageLink(r *http.Request, cursor string) string {
+	next := *r.URL
+	values := next.Query()
+	values.Set("cursor", cursor)
+	next.RawQuery = values.Encode()
+
+	return next.RequestURI()
+}
+
+func validateBook(r *http.Request) (models.Book, error) {
 	var book models.Book
-	err := json.NewDecoder(r.Body).Decode(&book)
 
-	// error during parsing json data
-	if err != nil {
-		return models.Book{}, errors.New("invalid data")
+	if err := validation.DecodeJSON(r.Body, &book); err != nil {
+		return models.Book{}, err
 	}
 
-	// validate required field
-	if !book.IsValid() {
-		return models.Book{}, errors.New("all fields (title, author, price) are required")
+	if err := book.Validate().Err(); err != nil {
+		return models.Book{}, err
 	}
 
+	if err := normalizeIsbn(&book); err != nil {
+		return models.Book{}, err
+	}
+
+	// only DELETE and restore move books in and out of the trash
+	book.DeletedAt = nil
+	book.DeletedBy = ""
+
 	return book, nil
 }
 
 // GetAllBooks godoc
 // @Summary Get all books
-// @Description Retrieve all books from the database
+// @Description Retrieve a page of books, optionally filtered and sorted. Follow "next" to get the following page.
 // @Tags books
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Success 200 {array} models.Book
-// @Failure 401 {object} string "Unauthorized"
-// @Failure 500 {object} string "Internal server error"
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Param author query string false "Author (exact, case-insensitive)"
+// @Param category query string false "Category (exact, case-insensitive)"
+// @Param min_year query int false "Minimum published year"
+// @Param max_year query int false "Maximum published year"
+// @Param min_price query int false "Minimum price"
+// @Param max_price query int false "Maximum price"
+// @Param sort query string false "Sort by title, price or year; prefix with - for descending"
+// @Success 200 {object} models.BookPage
+// @Failure 400 {object} problem.Details "Bad request - invalid query parameter"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 500 {object} problem.Details "Internal server error"
 // @Router /books [get]
 func GetAllBooks(w http.ResponseWriter, r *http.Request) {
+	listBooks(w, r, false)
+}
+
+// Write a page of the live catalog or of the trash
+func listBooks(w http.ResponseWriter, r *http.Request, trash bool) {
 	w.Header().Set("Content-Type", "application/json")
 
-	books, err := getAllBooks(r.Context())
+	query, err := parseBookQuery(r)
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query.Trash = trash
+
+	// one extra book tells whether another page exists
+	pageSize := query.Limit
+	query.Limit++
+	books, total, err := getAllBooks(query, r.Context())
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(err.Error())
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	json.NewEncoder(w).Encode(books)
+	page := models.BookPage{Data: books, Total: total}
+
+	if len(books) > pageSize {
+		page.Data = books[:pageSize]
+		last := page.Data[pageSize-1]
+		cursor := models.BookCursor{Value: last.SortValue(query.SortField), ID: last.ID}
+		page.NextCursor = cursor.Encode()
+		page.Next = nextPageLink(r, page.NextCursor)
+		w.Header().Set("Link", "<"+page.Next+`>; rel="next"`)
+	}
+
+	json.NewEncoder(w).Encode(page)
+}
+
+// GetBook godoc
+// @Summary Get a book
+// @Description Retrieve a single book by ID. Supports conditional requests with If-None-Match and If-Modified-Since.
+// @Tags books
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Book ID"
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Param If-Modified-Since header string false "Last-Modified from a previous response"
+// @Success 200 {object} models.Book
+// @Success 304 "Not modified"
+// @Failure 400 {object} problem.Details "Bad request"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /book/{id} [get]
+func GetBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	params := mux.Vars(r)
+	bookId, err := primitive.ObjectIDFromHex(params["id"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	book, err := getBook(bookId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	etag := bookETag(book)
+	setCacheHeaders(w, etag, book.LastModified())
+
+	if notModified(r, etag, book.LastModified()) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	json.NewEncoder(w).Encode(book)
+}
+
+// GetBookByIsbn godoc
+// @Summary Get a book by ISBN
+// @Description Retrieve the book with the given ISBN-10 or ISBN-13, with or without hyphens. Supports conditional requests like GET /book/{id}.
+// @Tags books
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param isbn path string true "ISBN-10 or ISBN-13"
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Param If-Modified-Since header string false "Last-Modified from a previous response"
+// @Success 200 {object} models.Book
+// @Success 304 "Not modified"
+// @Failure 400 {object} problem.Details "Invalid ISBN"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /books/isbn/{isbn} [get]
+func GetBookByIsbn(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	params := mux.Vars(r)
+	digits, err := isbn.To13(params["isbn"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	book, err := repository.Books.GetByIsbn(digits, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given isbn")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	etag := bookETag(book)
+	setCacheHeaders(w, etag, book.LastModified())
+
+	if notModified(r, etag, book.LastModified()) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	json.NewEncoder(w).Encode(book)
 }
 
 // CreateBook godoc
 // @Summary Create a new book
-// @Description Add a new book to the database (Admin only)
+// @Description Add a new book to the database (Admin only). The ISBN, if given, must be a valid ISBN-10 or ISBN-13 and is stored as a hyphenated ISBN-13.
 // @Tags books
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param book body models.Book true "Book object"
 // @Success 200 {object} models.Book
-// @Failure 400 {object} string "Bad request"
-// @Failure 401 {object} string "Unauthorized"
-// @Failure 403 {object} string "Forbidden - Admin role required"
-// @Failure 500 {object} string "Internal server error"
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 409 {object} problem.Details "ISBN already used by another book"
+// @Failure 500 {object} problem.Details "Internal server error"
 // @Router /book [post]
 func CreateBook(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -157,37 +370,51 @@ func CreateBook(w http.ResponseWriter, r *http.Request) {
 	book, err := validateBook(r)
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(err.Error())
+		problem.Invalid(w, r, err)
 		return
 	}
 	book.ID = primitive.NewObjectID()
-	_, err = insertBook(book, r.Context())
+	book.Version = 1
+	book.UpdatedAt = time.Now().UTC()
+	book.Reserved = 0
+	book.StockByLocation = nil
+	err = insertBook(book, r.Context())
+
+	if errors.Is(err, repository.ErrDuplicate) {
+		problem.New(problem.TypeDuplicate, http.StatusConflict, errDuplicateIsbn.Error()).Write(w, r)
+		return
+	}
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(err.Error())
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	recordWrite(r, models.AuditCreate, nil, book)
+	indexBook(book)
+	setCacheHeaders(w, bookETag(book), book.LastModified())
 	json.NewEncoder(w).Encode(book)
 }
 
 // UpdateBook godoc
 // @Summary Update a book
-// @Description Update an existing book by ID (Admin only)
+// @Description Update an existing book by ID (Admin only). Send the ETag of the book in If-Match, required, to make sure nobody changed it in the meantime; * overwrites any version.
 // @Tags books
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param id path string true "Book ID"
+// @Param If-Match header string true "ETag of the version being replaced"
 // @Param book body models.Book true "Book object"
 // @Success 200 {object} models.Book
-// @Failure 400 {object} string "Bad request"
-// @Failure 401 {object} string "Unauthorized"
-// @Failure 403 {object} string "Forbidden - Admin role required"
-// @Failure 404 {object} string "Book not found"
-// @Failure 500 {object} string "Internal server error"
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 409 {object} problem.Details "Concurrent modification or ISBN already used by another book"
+// @Failure 412 {object} problem.Details "Book was modified (If-Match mismatch)"
+// @Failure 428 {object} problem.Details "If-Match missing"
+// @Failure 500 {object} problem.Details "Internal server error"
 // @Router /book/{id} [put]
 func UpdateBook(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -195,52 +422,261 @@ func UpdateBook(w http.ResponseWriter, r *http.Request) {
 	bookId, err := primitive.ObjectIDFromHex(params["id"])
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode("Invalid object id")
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	match, ok := expectedVersion(r)
+
+	if !ok {
+		problem.New(problem.TypePreconditionRequired, http.StatusPreconditionRequired, errPreconditionRequired).Write(w, r)
 		return
 	}
 
 	book, err := validateBook(r)
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(err.Error())
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	before, err := getBook(bookId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	if !match.allows(before.Version) {
+		problem.New(problem.TypePreconditionFailed, http.StatusPreconditionFailed, errPreconditionFailed).Write(w, r)
+		return
+	}
+
+	// write against the version that was read so the audit diff is exact
 	book.ID = bookId
-	result, err := updateBook(book, r.Context())
+	book.UpdatedAt = time.Now().UTC()
+	book, err = updateBook(book, before.Version, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if errors.Is(err, repository.ErrVersionConflict) && !match.any {
+		problem.New(problem.TypePreconditionFailed, http.StatusPreconditionFailed, errPreconditionFailed).Write(w, r)
+		return
+	}
+
+	if errors.Is(err, repository.ErrVersionConflict) {
+		problem.New(problem.TypeConcurrentWrite, http.StatusConflict, errConcurrentWrite).Write(w, r)
+		return
+	}
+
+	if errors.Is(err, repository.ErrDuplicate) {
+		problem.New(problem.TypeDuplicate, http.StatusConflict, errDuplicateIsbn.Error()).Write(w, r)
+		return
+	}
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		logger.Log.WithError(err).Error(err.Error())
-		json.NewEncoder(w).Encode(err)
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode("no data found by given id")
+	recordWrite(r, models.AuditUpdate, &before, book)
+	indexBook(book)
+	setCacheHeaders(w, bookETag(book), book.LastModified())
+	json.NewEncoder(w).Encode(book)
+}
+
+// Apply a merge patch or JSON patch, chosen by Content-Type, to the book
+func applyBookPatch(book models.Book, r *http.Request) (models.Book, error) {
+	if r.Body == nil {
+		return models.Book{}, errors.New("no data found")
+	}
+
+	changes, err := io.ReadAll(r.Body)
+
+	if err != nil {
+		return models.Book{}, errors.New("invalid data")
+	}
+
+	document, err := json.Marshal(book)
+
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var patched []byte
+
+	switch mediaType {
+	case patch.JSONPatchType:
+		patched, err = patch.JSONPatch(document, changes)
+	default:
+		patched, err = patch.MergePatch(document, changes)
+	}
+
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	var result models.Book
+
+	if err := validation.DecodeJSON(bytes.NewReader(patched), &result); err != nil {
+		return models.Book{}, err
+	}
+
+	return result, nil
+}
+
+// PatchBook godoc
+// @Summary Partially update a book
+// @Description Change only the supplied fields of a book (Admin only). Send an RFC 7396 merge patch (application/merge-patch+json, the default) or an RFC 6902 JSON Patch (application/json-patch+json). The merged book must still be valid. Send the ETag of the book in If-Match, required, to make sure nobody changed it in the meantime; * overwrites any version.
+// @Tags books
+// @Accept json
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Book ID"
+// @Param If-Match header string true "ETag of the version being patched"
+// @Param patch body object true "Merge patch object or JSON Patch operation array"
+// @Success 200 {object} models.Book
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 409 {object} problem.Details "JSON Patch test operation failed, concurrent modification or ISBN already used by another book"
+// @Failure 412 {object} problem.Details "Book was modified (If-Match mismatch)"
+// @Failure 428 {object} problem.Details "If-Match missing"
+// @Failure 415 {object} problem.Details "Unsupported patch format"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /book/{id} [patch]
+func PatchBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	params := mux.Vars(r)
+	bookId, err := primitive.ObjectIDFromHex(params["id"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != "" && mediaType != "application/json" && mediaType != patch.MergePatchType && mediaType != patch.JSONPatchType {
+		problem.Error(w, r, http.StatusUnsupportedMediaType, "Content-Type must be "+patch.MergePatchType+" or "+patch.JSONPatchType)
+		return
+	}
+
+	match, ok := expectedVersion(r)
+
+	if !ok {
+		problem.New(problem.TypePreconditionRequired, http.StatusPreconditionRequired, errPreconditionRequired).Write(w, r)
 		return
 	}
 
+	book, err := getBook(bookId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !match.allows(book.Version) {
+		problem.New(problem.TypePreconditionFailed, http.StatusPreconditionFailed, errPreconditionFailed).Write(w, r)
+		return
+	}
+
+	// the patch is computed on this version, so the write must find it
+	before := book
+	book, err = applyBookPatch(book, r)
+
+	if errors.Is(err, patch.ErrTestFailed) {
+		problem.Error(w, r, http.StatusConflict, err.Error())
+		return
+	}
+
+	// validate the merged result, not the patch
+	if err == nil {
+		err = book.Validate().Err()
+	}
+
+	if err == nil {
+		err = normalizeIsbn(&book)
+	}
+
+	if err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	// identity and timestamps are server controlled
+	book.ID = bookId
+	book.UpdatedAt = time.Now().UTC()
+	book, err = updateBook(book, before.Version, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if errors.Is(err, repository.ErrVersionConflict) && !match.any {
+		problem.New(problem.TypePreconditionFailed, http.StatusPreconditionFailed, errPreconditionFailed).Write(w, r)
+		return
+	}
+
+	if errors.Is(err, repository.ErrVersionConflict) {
+		problem.New(problem.TypeConcurrentWrite, http.StatusConflict, errConcurrentWrite).Write(w, r)
+		return
+	}
+
+	if errors.Is(err, repository.ErrDuplicate) {
+		problem.New(problem.TypeDuplicate, http.StatusConflict, errDuplicateIsbn.Error()).Write(w, r)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	recordWrite(r, models.AuditPatch, &before, book)
+	indexBook(book)
+	setCacheHeaders(w, bookETag(book), book.LastModified())
 	json.NewEncoder(w).Encode(book)
 }
 
 // DeleteBook godoc
 // @Summary Delete a book
-// @Description Delete a book by ID (Admin only)
+// @Description Move a book to the trash (Admin only). It can be restored until the retention period ends. Send the ETag of the book in If-Match, required, to make sure nobody changed it in the meantime; * deletes any version.
 // @Tags books
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param id path string true "Book ID"
-// @Success 200 {object} string "Data deleted successfully"
-// @Failure 400 {object} string "Bad request"
-// @Failure 401 {object} string "Unauthorized"
-// @Failure 403 {object} string "Forbidden - Admin role required"
-// @Failure 404 {object} string "Book not found"
-// @Failure 500 {object} string "Internal server error"
+// @Param If-Match header string true "ETag of the version being deleted"
+// @Success 200 {object} string "Book moved to trash"
+// @Failure 400 {object} problem.Details "Bad request"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 409 {object} problem.Details "Concurrent modification"
+// @Failure 412 {object} problem.Details "Book was modified (If-Match mismatch)"
+// @Failure 428 {object} problem.Details "If-Match missing"
+// @Failure 500 {object} problem.Details "Internal server error"
 // @Router /book/{id} [delete]
 func DeleteBook(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -249,52 +685,63 @@ func DeleteBook(w http.ResponseWriter, r *http.Request) {
 	bookId, err := primitive.ObjectIDFromHex(params["id"])
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode("Invalid object id")
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	match, ok := expectedVersion(r)
+
+	if !ok {
+		problem.New(problem.TypePreconditionRequired, http.StatusPreconditionRequired, errPreconditionRequired).Write(w, r)
 		return
 	}
 
-	result, err := deleteBook(bookId, r.Context())
+	before, err := getBook(bookId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(err.Error())
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode("no data found by given id")
+	if !match.allows(before.Version) {
+		problem.New(problem.TypePreconditionFailed, http.StatusPreconditionFailed, errPreconditionFailed).Write(w, r)
 		return
 	}
 
-	json.NewEncoder(w).Encode("Data deleted successfully")
-}
+	actor := middlewares.Username(r.Context())
+	now := time.Now().UTC()
+	err = deleteBook(bookId, before.Version, actor, now, r.Context())
 
-// DeleteAllBooks godoc
-// @Summary Delete all books
-// @Description Delete all books from the database (Admin only)
-// @Tags books
-// @Accept json
-// @Produce json
-// @Security BearerAuth
-// @Success 200 {object} string "All books deleted successfully"
-// @Failure 401 {object} string "Unauthorized"
-// @Failure 403 {object} string "Forbidden - Admin role required"
-// @Failure 500 {object} string "Internal server error"
-// @Router /books [delete]
-func DeleteAllBooks(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if errors.Is(err, repository.ErrVersionConflict) && !match.any {
+		problem.New(problem.TypePreconditionFailed, http.StatusPreconditionFailed, errPreconditionFailed).Write(w, r)
+		return
+	}
 
-	_, err := deleteAllBooks(r.Context())
+	if errors.Is(err, repository.ErrVersionConflict) {
+		problem.New(problem.TypeConcurrentWrite, http.StatusConflict, errConcurrentWrite).Write(w, r)
+		return
+	}
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(err.Error())
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	json.NewEncoder(w).Encode("all books deleted successfully")
+	after := trashedBook(before, actor, now)
+	recordWrite(r, models.AuditDelete, &before, after)
+	unindexBook(bookId)
+
+	json.NewEncoder(w).Encode("Book moved to trash")
 }
 
 // ServeHome godoc
@@ -307,3 +754,13 @@ func DeleteAllBooks(w http.ResponseWriter, r *http.Request) {
 func ServeHome(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Welcome to bookstore API"))
 }
+
+// Answer requests no route matches
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusNotFound, "no resource at "+r.URL.Path)
+}
+
+// Answer requests whose route does not support the method
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusMethodNotAllowed, r.Method+" is not supported on "+r.URL.Path)
+}
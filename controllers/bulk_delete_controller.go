@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/middlewares"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How long a dry run confirmation token can be echoed back
+const bulkDeleteConfirmWindow = 5 * time.Minute
+
+var errInvalidConfirmation = errors.New("confirmation token is invalid, expired or was issued for another filter, run the dry run again")
+var errCatalogChanged = errors.New("the matching books changed since the dry run, run it again")
+
+// Canonical form of the filters of a bulk delete, so the confirmed request
+// can be compared with its dry run
+func bulkDeleteFilter(query models.BookQuery) string {
+	values := url.Values{}
+
+	for name, value := range map[string]string{
+		"author":   strings.ToLower(query.Author),
+		"category": strings.ToLower(query.Category),
+	} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+
+	for name, value := range map[string]int{
+		"min_year":  query.MinYear,
+		"max_year":  query.MaxYear,
+		"min_price": query.MinPrice,
+		"max_price": query.MaxPrice,
+	} {
+		if value > 0 {
+			values.Set(name, strconv.Itoa(value))
+		}
+	}
+
+	return values.Encode()
+}
+
+// Fingerprint of a set of books, whatever order they come in
+func bulkDeleteBooks(books []primitive.ObjectID) string {
+	ids := make([]string, len(books))
+
+	for i, bookId := range books {
+		ids[i] = bookId.Hex()
+	}
+
+	slices.Sort(ids)
+	sum := sha256.Sum256([]byte(strings.Join(ids, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Find the matching books and store a confirmation for exactly them
+func previewBulkDelete(query models.BookQuery, actor string, ctx context.Context) (models.BulkDeletePreview, error) {
+	query.Limit = 0
+	bookIds := []primitive.ObjectID{}
+
+	err := repository.Books.Stream(query, func(book models.Book) error {
+		bookIds = append(bookIds, book.ID)
+		return nil
+	}, ctx)
+
+	if err != nil {
+		return models.BulkDeletePreview{}, err
+	}
+
+	count := int64(len(bookIds))
+
+	token, err := randomToken(32)
+
+	if err != nil {
+		return models.BulkDeletePreview{}, err
+	}
+
+	confirmation := models.BulkDeleteConfirmation{
+		Hash:      hashToken(token),
+		Actor:     actor,
+		Filter:    bulkDeleteFilter(query),
+		Count:     count,
+		Books:     bulkDeleteBooks(bookIds),
+		ExpiresAt: time.Now().UTC().Add(bulkDeleteConfirmWindow),
+	}
+
+	if err := repository.BulkDeletes.InsertConfirmation(confirmation, ctx); err != nil {
+		return models.BulkDeletePreview{}, err
+	}
+
+	return models.BulkDeletePreview{Count: count, ConfirmationToken: token, ExpiresAt: confirmation.ExpiresAt}, nil
+}
+
+// Check the token against the dry run, snapshot the matching books and move
+// them to the trash
+func executeBulkDelete(r *http.Request, query models.BookQuery, actor string, token string, ctx context.Context) (models.BulkDeleteResult, error) {
+	confirmation, err := repository.BulkDeletes.UseConfirmation(hashToken(token), ctx)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.BulkDeleteResult{}, errInvalidConfirmation
+	}
+
+	if err != nil {
+		return models.BulkDeleteResult{}, err
+	}
+
+	filter := bulkDeleteFilter(query)
+	now := time.Now().UTC()
+
+	if now.After(confirmation.ExpiresAt) || confirmation.Actor != actor || confirmation.Filter != filter {
+		return models.BulkDeleteResult{}, errInvalidConfirmation
+	}
+
+	query.Limit = 0
+	books, _, err := getAllBooks(query, ctx)
+
+	if err != nil {
+		return models.BulkDeleteResult{}, err
+	}
+
+	bookIds := make([]primitive.ObjectID, len(books))
+
+	for i, book := range books {
+		bookIds[i] = book.ID
+	}
+
+	if bulkDeleteBooks(bookIds) != confirmation.Books {
+		return models.BulkDeleteResult{}, errCatalogChanged
+	}
+
+	snapshot := models.BookSnapshot{
+		ID:      primitive.NewObjectID().Hex(),
+		TakenAt: now,
+		TakenBy: actor,
+		Filter:  filter,
+		Books:   books,
+	}
+
+	if err := repository.BulkDeletes.InsertSnapshot(snapshot, ctx); err != nil {
+		return models.BulkDeleteResult{}, err
+	}
+
+	count, err := repository.Books.DeleteMany(bookIds, actor, now, ctx)
+
+	if err != nil {
+		return models.BulkDeleteResult{}, err
+	}
+
+	entries := make([]models.AuditEntry, len(books))
+	revisions := make([]models.BookRevision, len(books))
+
+	for i, book := range books {
+		after := trashedBook(book, actor, now)
+		entries[i] = auditEntry(r, models.AuditBulkDelete, &book, &after)
+		revisions[i] = newRevision(r, models.AuditBulkDelete, after)
+		unindexBook(book.ID)
+	}
+
+	recordAudit(r, entries...)
+	recordRevisions(r, revisions...)
+
+	logger.Log.WithField("delete_count", count).WithField("snapshot_id", snapshot.ID).WithField("deleted_by", actor).Info("Books moved to trash successfully!! 🗑️")
+
+	return models.BulkDeleteResult{Deleted: count, SnapshotID: snapshot.ID}, nil
+}
+
+// BulkDeleteBooks godoc
+// @Summary Delete books matching a filter
+// @Description Two step bulk delete (Admin only). Without confirm it is a dry run: nothing is deleted and the response holds the number of matching books and a confirmation token valid for 5 minutes. Repeat the request with the same filters and confirm=<token> to snapshot the matching books and move them to the trash. No filter matches every book.
+// @Tags books
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param author query string false "Author (exact, case-insensitive)"
+// @Param category query string false "Category (exact, case-insensitive)"
+// @Param min_year query int false "Minimum published year"
+// @Param max_year query int false "Maximum published year"
+// @Param min_price query int false "Minimum price"
+// @Param max_price query int false "Maximum price"
+// @Param confirm query string false "Confirmation token from the dry run"
+// @Success 200 {object} models.BulkDeletePreview "Dry run"
+// @Success 202 {object} models.BulkDeleteResult "Books moved to trash"
+// @Failure 400 {object} problem.Details "Invalid filter or confirmation token"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 409 {object} problem.Details "Matching books changed since the dry run"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /books [delete]
+func BulkDeleteBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query, err := parseBookQuery(r)
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// only the filters apply, never a page of them
+	query.SortField = ""
+	query.After = nil
+	actor := middlewares.Username(r.Context())
+	token := r.URL.Query().Get("confirm")
+
+	if token == "" {
+		preview, err := previewBulkDelete(query, actor, r.Context())
+
+		if err != nil {
+			problem.Error(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		json.NewEncoder(w).Encode(preview)
+		return
+	}
+
+	result, err := executeBulkDelete(r, query, actor, token, r.Context())
+
+	if errors.Is(err, errInvalidConfirmation) {
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if errors.Is(err, errCatalogChanged) {
+		problem.Error(w, r, http.StatusConflict, err.Error())
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetSnapshot godoc
+// @Summary Get a bulk delete snapshot
+// @Description Retrieve the books as they were right before a bulk delete (Admin only)
+// @Tags books
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Snapshot ID"
+// @Success 200 {object} models.BookSnapshot
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Snapshot not found"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /books/snapshots/{id} [get]
+func GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := mux.Vars(r)
+	snapshot, err := repository.BulkDeletes.GetSnapshot(params["id"], r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no snapshot found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(snapshot)
+}
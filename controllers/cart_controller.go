@@ -0,0 +1,359 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/middlewares"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/BULLKNIGHT/bookstore/validation"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultCartTTL is how long an untouched cart is kept
+const DefaultCartTTL = 7 * 24 * time.Hour
+
+// CartTTL is how long a cart lives after its last change
+var CartTTL = DefaultCartTTL
+
+// cartAttempts is how many times a cart change is applied when other
+// requests keep saving the cart in between
+const cartAttempts = 3
+
+var errNotInCart = errors.New("book is not in the cart")
+
+// The cart of the user, empty when there is none
+func loadCart(owner string, ctx context.Context) (models.Cart, error) {
+	cart, err := repository.Carts.Get(owner, time.Now().UTC(), ctx)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.Cart{Owner: owner, Items: []models.CartItem{}}, nil
+	}
+
+	return cart, err
+}
+
+// Store the cart if nobody saved it since it was loaded, and push its
+// expiry back. An emptied cart is removed.
+func saveCart(cart models.Cart, ctx context.Context) (models.Cart, error) {
+	if len(cart.Items) == 0 {
+		if cart.Version == 0 {
+			return cart, nil
+		}
+
+		if err := repository.Carts.Delete(cart.Owner, cart.Version, ctx); err != nil {
+			return cart, err
+		}
+
+		return models.Cart{Owner: cart.Owner, Items: []models.CartItem{}}, nil
+	}
+
+	now := time.Now().UTC()
+	cart.UpdatedAt = now
+	cart.ExpiresAt = now.Add(CartTTL)
+	saved, err := repository.Carts.Save(cart, cart.Version, ctx)
+
+	if err != nil {
+		return cart, err
+	}
+
+	logger.Log.WithField("owner", saved.Owner).WithField("items", len(saved.Items)).Info("Cart saved successfully!! 🛒")
+	return saved, nil
+}
+
+// Apply the change to the cart of the owner and store it. When another
+// request saved the cart in between, the change is applied again to the
+// cart it left, so neither change is lost.
+func changeCart(owner string, change func(cart *models.Cart) error, ctx context.Context) (models.Cart, error) {
+	for attempt := 0; attempt < cartAttempts; attempt++ {
+		cart, err := loadCart(owner, ctx)
+
+		if err != nil {
+			return cart, err
+		}
+
+		if err := change(&cart); err != nil {
+			return cart, err
+		}
+
+		cart, err = saveCart(cart, ctx)
+
+		if !errors.Is(err, repository.ErrVersionConflict) {
+			return cart, err
+		}
+	}
+
+	return models.Cart{}, repository.ErrVersionConflict
+}
+
+// Answer a cart change that failed
+func cartChangeError(w http.ResponseWriter, r *http.Request, err error) {
+	var errs validation.Errors
+
+	if errors.As(err, &errs) {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	if errors.Is(err, errNotInCart) {
+		problem.Error(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if errors.Is(err, repository.ErrVersionConflict) {
+		problem.New(problem.TypeConcurrentWrite, http.StatusConflict, "cart was modified concurrently, please retry").Write(w, r)
+		return
+	}
+
+	problem.Error(w, r, http.StatusInternalServerError, err.Error())
+}
+
+// Price the cart at the current book prices. Prices sent by clients are
+// never trusted, so the total is always computed here.
+func priceCart(cart models.Cart, ctx context.Context) (models.PricedCart, error) {
+	priced := models.PricedCart{Owner: cart.Owner, Lines: []models.CartLine{}}
+
+	if !cart.ExpiresAt.IsZero() {
+		priced.ExpiresAt = &cart.ExpiresAt
+	}
+
+	for _, item := range cart.Items {
+		line := models.CartLine{BookID: item.BookID.Hex(), Quantity: item.Quantity}
+		book, err := getBook(item.BookID, ctx)
+
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return priced, err
+		}
+
+		// books deleted since stay listed so the user sees what went away
+		if err == nil {
+			line.Title = book.Title
+			line.Author = book.Author
+			line.Isbn = book.Isbn
+			line.UnitPrice = book.Price
+			line.LineTotal = book.Price * item.Quantity
+			line.Available = true
+			priced.ItemCount += item.Quantity
+			priced.Subtotal += line.LineTotal
+		}
+
+		priced.Lines = append(priced.Lines, line)
+	}
+
+	return priced, nil
+}
+
+func writeCart(w http.ResponseWriter, r *http.Request, cart models.Cart) {
+	priced, err := priceCart(cart, r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(priced)
+}
+
+// Change the line of the book in the path
+func changeCartLine(w http.ResponseWriter, r *http.Request, change func(cart *models.Cart, index int)) {
+	bookId, err := primitive.ObjectIDFromHex(mux.Vars(r)["bookId"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	cart, err := changeCart(middlewares.Username(r.Context()), func(cart *models.Cart) error {
+		index := cart.Find(bookId)
+
+		if index < 0 {
+			return errNotInCart
+		}
+
+		change(cart, index)
+		return nil
+	}, r.Context())
+
+	if err != nil {
+		cartChangeError(w, r, err)
+		return
+	}
+
+	writeCart(w, r, cart)
+}
+
+// GetCart godoc
+// @Summary Get the cart
+// @Description Retrieve the cart of the authenticated user, priced at the current book prices. A user without a cart gets an empty one.
+// @Tags cart
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.PricedCart
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /cart [get]
+func GetCart(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cart, err := loadCart(middlewares.Username(r.Context()), r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeCart(w, r, cart)
+}
+
+// AddCartItem godoc
+// @Summary Add a book to the cart
+// @Description Put copies of a book in the cart of the authenticated user, on top of those already there
+// @Tags cart
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param item body models.CartAddition true "Book and quantity"
+// @Success 200 {object} models.PricedCart
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 409 {object} problem.Details "Cart modified concurrently"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /cart/items [post]
+func AddCartItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var addition models.CartAddition
+
+	if err := validation.DecodeJSON(r.Body, &addition); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	if err := addition.Validate().Err(); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	bookId, _ := primitive.ObjectIDFromHex(addition.BookID)
+	_, err := getBook(bookId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		errs := validation.Errors{}
+		errs.Add("book_id", validation.RuleExists, "book_id does not name a book")
+		problem.Invalid(w, r, errs)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cart, err := changeCart(middlewares.Username(r.Context()), func(cart *models.Cart) error {
+		errs := validation.Errors{}
+
+		if index := cart.Find(bookId); index >= 0 {
+			cart.Items[index].Quantity += addition.Quantity
+			errs.Range("quantity", cart.Items[index].Quantity, 1, models.MaxCartQuantity)
+		} else if len(cart.Items) == models.MaxCartItems {
+			errs.Add("", validation.RuleMax, fmt.Sprintf("a cart holds at most %d different books", models.MaxCartItems))
+		} else {
+			cart.Items = append(cart.Items, models.CartItem{BookID: bookId, Quantity: addition.Quantity})
+		}
+
+		return errs.Err()
+	}, r.Context())
+
+	if err != nil {
+		cartChangeError(w, r, err)
+		return
+	}
+
+	writeCart(w, r, cart)
+}
+
+// UpdateCartItem godoc
+// @Summary Change the quantity of a cart line
+// @Description Set how many copies of a book the cart of the authenticated user holds
+// @Tags cart
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param bookId path string true "Book ID"
+// @Param quantity body models.CartQuantity true "New quantity"
+// @Success 200 {object} models.PricedCart
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 404 {object} problem.Details "Book not in the cart"
+// @Failure 409 {object} problem.Details "Cart modified concurrently"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /cart/items/{bookId} [put]
+func UpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var quantity models.CartQuantity
+
+	if err := validation.DecodeJSON(r.Body, &quantity); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	if err := quantity.Validate().Err(); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	changeCartLine(w, r, func(cart *models.Cart, index int) {
+		cart.Items[index].Quantity = quantity.Quantity
+	})
+}
+
+// RemoveCartItem godoc
+// @Summary Remove a book from the cart
+// @Description Take a book out of the cart of the authenticated user
+// @Tags cart
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param bookId path string true "Book ID"
+// @Success 200 {object} models.PricedCart
+// @Failure 400 {object} problem.Details "Bad request"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 404 {object} problem.Details "Book not in the cart"
+// @Failure 409 {object} problem.Details "Cart modified concurrently"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /cart/items/{bookId} [delete]
+func RemoveCartItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	changeCartLine(w, r, func(cart *models.Cart, index int) {
+		cart.Items = append(cart.Items[:index], cart.Items[index+1:]...)
+	})
+}
+
+// ClearCart godoc
+// @Summary Empty the cart
+// @Description Remove every line of the cart of the authenticated user
+// @Tags cart
+// @Security BearerAuth
+// @Success 204
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /cart [delete]
+func ClearCart(w http.ResponseWriter, r *http.Request) {
+	if err := repository.Carts.Delete(middlewares.Username(r.Context()), repository.AnyVersion, r.Context()); err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
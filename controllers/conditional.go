@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+)
+
+const errPreconditionFailed = "book was modified, If-Match does not match the current version"
+const errPreconditionRequired = "If-Match with the ETag of the book is required, or * to overwrite any version"
+const errConcurrentWrite = "book was modified concurrently, please retry"
+
+// Strong ETag derived from the catalog version of the book and, once its
+// stock changed, the stock version ("v3.7"), so it changes on every write
+// while If-Match only looks at the catalog part
+func bookETag(book models.Book) string {
+	if book.StockVersion == 0 {
+		return `"v` + strconv.Itoa(book.Version) + `"`
+	}
+
+	return `"v` + strconv.Itoa(book.Version) + "." + strconv.Itoa(book.StockVersion) + `"`
+}
+
+// versionMatch is the If-Match precondition of a write: the versions it may
+// replace, or any of them for "*"
+type versionMatch struct {
+	any      bool
+	versions []int
+}
+
+// allows tells whether the write may replace the version
+func (match versionMatch) allows(version int) bool {
+	return match.any || slices.Contains(match.versions, version)
+}
+
+// expectedVersion reads the If-Match header of a write, false when it is
+// missing so that clients cannot overwrite a book without saying which
+// version they saw. Only the catalog version of each ETag counts, stock
+// writes since do not make it stale. Weak or unknown ETags in the list never
+// match, as If-Match uses strong comparison.
+func expectedVersion(r *http.Request) (versionMatch, bool) {
+	var match versionMatch
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+
+	if header == "" {
+		return match, false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			match.any = true
+			continue
+		}
+
+		value, ok := strings.CutPrefix(candidate, `"v`)
+		value, found := strings.CutSuffix(value, `"`)
+
+		if !ok || !found {
+			continue
+		}
+
+		value, _, _ = strings.Cut(value, ".")
+
+		if version, err := strconv.Atoi(value); err == nil && version >= 0 {
+			match.versions = append(match.versions, version)
+		}
+	}
+
+	return match, true
+}
+
+// Set validators so clients can revalidate cached representations
+func setCacheHeaders(w http.ResponseWriter, etag string, lastModified time.Time) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// Check whether an ETag list header (If-None-Match / If-Match) names the etag.
+// Weak comparison is used, as required for If-None-Match.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified evaluates If-None-Match and, when absent, If-Modified-Since
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return etagMatches(header, etag)
+	}
+
+	header := r.Header.Get("If-Modified-Since")
+
+	if header == "" || lastModified.IsZero() {
+		return false
+	}
+
+	since, err := http.ParseTime(header)
+
+	if err != nil {
+		return false
+	}
+
+	// HTTP dates have second precision
+	return !lastModified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+)
+
+// Rows written between two flushes to the client
+const exportFlushEvery = 500
+
+const (
+	exportCSV    = "csv"
+	exportNDJSON = "ndjson"
+	exportExcel  = "excel"
+)
+
+var exportColumns = map[string]func(models.Book) any{
+	"id":             func(book models.Book) any { return book.ID.Hex() },
+	"title":          func(book models.Book) any { return book.Title },
+	"author":         func(book models.Book) any { return book.Author },
+	"isbn":           func(book models.Book) any { return book.Isbn },
+	"published_year": func(book models.Book) any { return book.PublishedYear },
+	"price":          func(book models.Book) any { return book.Price },
+	"category":       func(book models.Book) any { return book.Category },
+	"stock":          func(book models.Book) any { return book.Stock },
+	"reserved":       func(book models.Book) any { return book.Reserved },
+	"version":        func(book models.Book) any { return book.Version },
+	"updated_at":     func(book models.Book) any { return book.LastModified().UTC().Format(time.RFC3339) },
+}
+
+// Same columns as the import so an export can be loaded back as is
+var defaultExportColumns = models.BookCSVColumns
+
+func parseExportColumns(r *http.Request) ([]string, error) {
+	value := r.URL.Query().Get("columns")
+
+	if value == "" {
+		return defaultExportColumns, nil
+	}
+
+	columns := []string{}
+
+	for _, column := range strings.Split(value, ",") {
+		column = strings.TrimSpace(column)
+
+		if _, ok := exportColumns[column]; !ok {
+			return nil, fmt.Errorf("unknown column %q, choose from id, title, author, isbn, published_year, price, category, stock, reserved, version, updated_at", column)
+		}
+
+		columns = append(columns, column)
+	}
+
+	return columns, nil
+}
+
+// Spreadsheets run cells starting with these as formulas
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
+
+// Writes one book at a time in the chosen format
+type bookWriter interface {
+	Write(book models.Book) error
+	Flush() error
+}
+
+type csvBookWriter struct {
+	writer  *csv.Writer
+	columns []string
+	excel   bool
+}
+
+func newCSVBookWriter(w io.Writer, columns []string, excel bool) (*csvBookWriter, error) {
+	writer := csv.NewWriter(w)
+
+	if excel {
+		// a BOM and CRLF make Excel read the file as UTF-8 CSV
+		if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+			return nil, err
+		}
+
+		writer.UseCRLF = true
+	}
+
+	return &csvBookWriter{writer: writer, columns: columns, excel: excel}, writer.Write(columns)
+}
+
+func (bw *csvBookWriter) Write(book models.Book) error {
+	record := make([]string, len(bw.columns))
+
+	for i, column := range bw.columns {
+		record[i] = fmt.Sprint(exportColumns[column](book))
+
+		if bw.excel {
+			record[i] = escapeFormula(record[i])
+		}
+	}
+
+	return bw.writer.Write(record)
+}
+
+func (bw *csvBookWriter) Flush() error {
+	bw.writer.Flush()
+	return bw.writer.Error()
+}
+
+type ndjsonBookWriter struct {
+	encoder *json.Encoder
+	columns []string
+}
+
+func (bw *ndjsonBookWriter) Write(book models.Book) error {
+	object := make(map[string]any, len(bw.columns))
+
+	for _, column := range bw.columns {
+		object[column] = exportColumns[column](book)
+	}
+
+	return bw.encoder.Encode(object)
+}
+
+func (bw *ndjsonBookWriter) Flush() error {
+	return nil
+}
+
+// ExportBooks godoc
+// @Summary Export the catalog
+// @Description Stream every book matching the listing filters as CSV, JSON Lines or Excel-compatible CSV (UTF-8 BOM, CRLF, cells that look like formulas are escaped). The rows are written as they are read from the database.
+// @Tags books
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Security BearerAuth
+// @Param format query string false "csv (default), ndjson or excel"
+// @Param columns query string false "Comma separated columns: id, title, author, isbn, published_year, price, category, stock, reserved, version, updated_at (default title,author,isbn,published_year,price,category)"
+// @Param author query string false "Author (exact, case-insensitive)"
+// @Param category query string false "Category (exact, case-insensitive)"
+// @Param min_year query int false "Minimum published year"
+// @Param max_year query int false "Maximum published year"
+// @Param min_price query int false "Minimum price"
+// @Param max_price query int false "Maximum price"
+// @Param sort query string false "Sort by title, price or year; prefix with - for descending"
+// @Success 200 {string} string "Exported books"
+// @Failure 400 {object} problem.Details "Bad request - invalid query parameter"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Router /books/export [get]
+func ExportBooks(w http.ResponseWriter, r *http.Request) {
+	query, err := parseBookQuery(r)
+
+	if err == nil && (r.URL.Query().Has("cursor") || r.URL.Query().Has("limit")) {
+		err = fmt.Errorf("export always covers every matching book, cursor and limit are not supported")
+	}
+
+	columns, columnsErr := parseExportColumns(r)
+	format := r.URL.Query().Get("format")
+
+	if format == "" {
+		format = exportCSV
+	}
+
+	if err == nil {
+		err = columnsErr
+	}
+
+	if err == nil && format != exportCSV && format != exportNDJSON && format != exportExcel {
+		err = fmt.Errorf("format must be one of csv, ndjson, excel")
+	}
+
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query.Limit = 0
+	filename := "books.csv"
+	contentType := "text/csv; charset=utf-8"
+
+	if format == exportNDJSON {
+		filename = "books.ndjson"
+		contentType = "application/x-ndjson"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	var writer bookWriter
+
+	if format == exportNDJSON {
+		writer = &ndjsonBookWriter{encoder: json.NewEncoder(w), columns: columns}
+	} else if writer, err = newCSVBookWriter(w, columns, format == exportExcel); err != nil {
+		logger.Log.WithError(err).Error("Catalog export failed!! 👎")
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	count := 0
+
+	err = repository.Books.Stream(query, func(book models.Book) error {
+		if err := writer.Write(book); err != nil {
+			return err
+		}
+
+		if count++; count%exportFlushEvery == 0 && flusher != nil {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+
+			flusher.Flush()
+		}
+
+		return nil
+	}, r.Context())
+
+	if err == nil {
+		err = writer.Flush()
+	}
+
+	// the status is already sent, all that is left is to stop and log
+	if err != nil {
+		logger.Log.WithError(err).WithField("count", count).Error("Catalog export failed!! 👎")
+		return
+	}
+
+	logger.Log.WithField("count", count).WithField("format", format).Info("Catalog exported successfully!! 📤")
+}
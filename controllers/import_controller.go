@@ -0,0 +1,452 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/middlewares"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/BULLKNIGHT/bookstore/validation"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const importBatchSize = 500
+const maxImportSize = 32 << 20
+const maxNDJSONLine = 1 << 20
+
+const (
+	importCSV    = "csv"
+	importNDJSON = "ndjson"
+)
+
+var errUnsupportedImport = errors.New("upload text/csv or application/x-ndjson, or set format=csv|ndjson")
+
+// A parsed line of an import file, with the reason it cannot be imported
+type importRecord struct {
+	line int
+	book models.Book
+	err  error
+}
+
+func importFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+		switch mediaType {
+		case "text/csv":
+			format = importCSV
+		case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+			format = importNDJSON
+		}
+	}
+
+	if format != importCSV && format != importNDJSON {
+		return "", errUnsupportedImport
+	}
+
+	return format, nil
+}
+
+// Map each CSV column to its position, rejecting unknown or repeated columns
+func csvColumns(header []string) (map[string]int, error) {
+	columns := map[string]int{}
+
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF")))
+
+		if !slices.Contains(models.BookCSVColumns, name) {
+			return nil, fmt.Errorf("unknown column %q, expected %s", name, strings.Join(models.BookCSVColumns, ", "))
+		}
+
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("column %q appears twice", name)
+		}
+
+		columns[name] = i
+	}
+
+	return columns, nil
+}
+
+func csvBook(record []string, columns map[string]int) (models.Book, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+
+		return ""
+	}
+
+	book := models.Book{
+		Title:    field("title"),
+		Author:   field("author"),
+		Isbn:     field("isbn"),
+		Category: field("category"),
+	}
+
+	for name, target := range map[string]*int{"published_year": &book.PublishedYear, "price": &book.Price} {
+		if value := field(name); value != "" {
+			number, err := strconv.Atoi(value)
+
+			if err != nil {
+				return book, fmt.Errorf("%s must be an integer", name)
+			}
+
+			*target = number
+		}
+	}
+
+	return book, nil
+}
+
+// Read a CSV upload with a header row naming the columns. Malformed rows are
+// returned as rejected records; only a bad header or a read failure aborts.
+func parseCSVBooks(body io.Reader) ([]importRecord, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("empty upload, a header row is required")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := csvColumns(header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	records := []importRecord{}
+
+	for {
+		row, err := reader.Read()
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var parseErr *csv.ParseError
+
+		if errors.As(err, &parseErr) {
+			records = append(records, importRecord{line: parseErr.StartLine, err: parseErr.Err})
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		record := importRecord{line: line}
+
+		if len(row) != len(header) {
+			record.err = fmt.Errorf("expected %d fields, got %d", len(header), len(row))
+		} else {
+			record.book, record.err = csvBook(row, columns)
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// Read an upload with one JSON book per line, blank lines are skipped
+func parseNDJSONBooks(body io.Reader) ([]importRecord, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxNDJSONLine)
+	records := []importRecord{}
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" {
+			continue
+		}
+
+		record := importRecord{line: line}
+
+		record.err = validation.DecodeJSON(strings.NewReader(text), &record.book)
+
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// Validate the records and insert the valid ones in batches. Each inserted
+// book is indexed and recorded like a single create.
+func importBooks(r *http.Request, records []importRecord) (models.ImportReport, error) {
+	report := models.ImportReport{Total: len(records), Rows: make([]models.ImportRow, len(records))}
+	batch := []int{}
+
+	reject := func(i int, err error) {
+		report.Rows[i] = models.ImportRow{Line: records[i].line, Status: models.ImportRejected, Error: err.Error()}
+		report.Rejected++
+	}
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		books := make([]models.Book, len(batch))
+
+		for j, i := range batch {
+			books[j] = records[i].book
+		}
+
+		errs, err := repository.Books.InsertMany(books, r.Context())
+
+		if err != nil {
+			return err
+		}
+
+		entries := []models.AuditEntry{}
+		revisions := []models.BookRevision{}
+
+		for j, i := range batch {
+			if errors.Is(errs[j], repository.ErrDuplicate) {
+				errs[j] = errDuplicateIsbn
+			}
+
+			if errs[j] != nil {
+				reject(i, errs[j])
+				continue
+			}
+
+			book := books[j]
+			report.Rows[i] = models.ImportRow{Line: records[i].line, Status: models.ImportAccepted, ID: book.ID.Hex()}
+			report.Accepted++
+			entries = append(entries, auditEntry(r, models.AuditCreate, nil, &book))
+			revisions = append(revisions, newRevision(r, models.AuditCreate, book))
+			indexBook(book)
+		}
+
+		recordAudit(r, entries...)
+		recordRevisions(r, revisions...)
+		batch = batch[:0]
+
+		return nil
+	}
+
+	// rows not reached when a batch fails are reported as not imported
+	abort := func(err error) (models.ImportReport, error) {
+		for i := range report.Rows {
+			if report.Rows[i].Status == "" {
+				reject(i, fmt.Errorf("not imported: %w", err))
+			}
+		}
+
+		return report, err
+	}
+
+	for i := range records {
+		record := &records[i]
+
+		if record.err == nil {
+			record.err = record.book.Validate().Err()
+		}
+
+		if record.err == nil {
+			record.err = normalizeIsbn(&record.book)
+		}
+
+		if record.err != nil {
+			reject(i, record.err)
+			continue
+		}
+
+		record.book.ID = primitive.NewObjectID()
+		record.book.Version = 1
+		record.book.UpdatedAt = time.Now().UTC()
+		record.book.DeletedAt = nil
+		record.book.DeletedBy = ""
+		record.book.Reserved = 0
+		record.book.StockByLocation = nil
+		batch = append(batch, i)
+
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return abort(err)
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return abort(err)
+	}
+
+	logger.Log.WithField("accepted", report.Accepted).WithField("rejected", report.Rejected).Info("Books imported successfully!! 📥")
+	return report, nil
+}
+
+// Run an import in the background and keep its job up to date
+func runImportJob(r *http.Request, job models.ImportJob, records []importRecord) {
+	ctx := r.Context()
+	job.Status = models.JobRunning
+
+	if err := repository.ImportJobs.Update(job, ctx); err != nil {
+		logger.Log.WithError(err).WithField("job_id", job.ID).Error("Import job update failed!! 👎")
+	}
+
+	report, err := importBooks(r, records)
+	finishedAt := time.Now().UTC()
+	job.FinishedAt = &finishedAt
+	job.Report = &report
+	job.Status = models.JobDone
+
+	if err != nil {
+		job.Status = models.JobFailed
+		job.Error = err.Error()
+		logger.Log.WithError(err).WithField("job_id", job.ID).Error("Import job failed!! 👎")
+	}
+
+	if err := repository.ImportJobs.Update(job, ctx); err != nil {
+		logger.Log.WithError(err).WithField("job_id", job.ID).Error("Import job update failed!! 👎")
+	}
+}
+
+// ImportBooks godoc
+// @Summary Bulk import books
+// @Description Import books from a CSV file (header row naming the columns title, author, isbn, published_year, price, category) or from JSON Lines (one book object per line) sent as the request body (Admin only). Each row is validated like POST /book and valid rows are inserted in batches. The report lists every row as accepted or rejected with the reason. With async=true the import runs in the background and the response points to the job.
+// @Tags import
+// @Accept plain
+// @Produce json
+// @Security BearerAuth
+// @Param format query string false "csv or ndjson, defaults to the Content-Type (text/csv, application/x-ndjson)"
+// @Param async query bool false "Run as a background job"
+// @Param file body string true "CSV or JSON Lines content"
+// @Success 200 {object} models.ImportReport
+// @Success 202 {object} models.ImportJob
+// @Failure 400 {object} problem.Details "Unreadable upload"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 413 {object} problem.Details "Upload too large"
+// @Failure 415 {object} problem.Details "Unsupported format"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /books/import [post]
+func ImportBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	format, err := importFormat(r)
+
+	if err != nil {
+		problem.Error(w, r, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+
+	if r.Body == nil {
+		problem.Error(w, r, http.StatusBadRequest, "no data found")
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	var records []importRecord
+
+	if format == importCSV {
+		records, err = parseCSVBooks(body)
+	} else {
+		records, err = parseNDJSONBooks(body)
+	}
+
+	var tooLarge *http.MaxBytesError
+
+	if errors.As(err, &tooLarge) {
+		problem.Error(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload is larger than %d bytes", maxImportSize))
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		job := models.ImportJob{
+			ID:        primitive.NewObjectID().Hex(),
+			Status:    models.JobQueued,
+			Format:    format,
+			Actor:     middlewares.Username(r.Context()),
+			CreatedAt: time.Now().UTC(),
+		}
+
+		if err := repository.ImportJobs.Insert(job, r.Context()); err != nil {
+			problem.Error(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// keep the actor and request id but outlive the request
+		go runImportJob(r.WithContext(context.WithoutCancel(r.Context())), job, records)
+
+		w.Header().Set("Location", "/books/import/jobs/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+		return
+	}
+
+	report, err := importBooks(r, records)
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetImportJob godoc
+// @Summary Get a background import
+// @Description Retrieve the state of a background import and its report once done (Admin only)
+// @Tags import
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Job ID"
+// @Success 200 {object} models.ImportJob
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Job not found"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /books/import/jobs/{id} [get]
+func GetImportJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := mux.Vars(r)
+	job, err := repository.ImportJobs.Get(params["id"], r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no import job found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/BULLKNIGHT/bookstore/validation"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func adjustStock(bookId primitive.ObjectID, locationId string, delta int, ctx context.Context) (models.Book, error) {
+	book, err := repository.Books.AdjustStock(bookId, locationId, delta, time.Now().UTC(), ctx)
+
+	if err != nil {
+		return book, err
+	}
+
+	logger.Log.WithField("id", bookId).WithField("location", locationId).WithField("delta", delta).WithField("stock", book.Stock).Info("Stock adjusted successfully!! 📦")
+	return book, nil
+}
+
+// Apply the quantity of the request to the stock of the book, negated for
+// sales, and answer the updated book
+func changeStock(w http.ResponseWriter, r *http.Request, action string, sign int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := mux.Vars(r)
+	bookId, err := primitive.ObjectIDFromHex(params["id"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	var change models.StockChange
+
+	if err := validation.DecodeJSON(r.Body, &change); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	if err := change.Validate().Err(); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	if change.LocationID != "" {
+		location, err := getLocation(change.LocationID, r.Context())
+
+		if errors.Is(err, repository.ErrNotFound) {
+			problem.Invalid(w, r, unknownLocation("location_id"))
+			return
+		}
+
+		if err != nil {
+			problem.Error(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		change.LocationID = location.ID.Hex()
+	}
+
+	before, err := getBook(bookId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	book, err := adjustStock(bookId, change.LocationID, sign*change.Quantity, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if errors.Is(err, repository.ErrInsufficientStock) {
+		problem.New(problem.TypeInsufficientStock, http.StatusConflict, "not enough copies available to sell").Write(w, r)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	movement := newMovement(r, models.MovementReceive, bookId, change.Quantity)
+	movement.To = change.LocationID
+
+	if sign < 0 {
+		movement.Kind = models.MovementSell
+		movement.From, movement.To = change.LocationID, ""
+	}
+
+	recordStockWrite(r, action, before, book)
+	recordMovement(r, movement)
+	setCacheHeaders(w, bookETag(book), book.LastModified())
+	json.NewEncoder(w).Encode(book)
+}
+
+// ReceiveStock godoc
+// @Summary Receive stock
+// @Description Add copies of a book to its stock, at a location or unassigned (Admin only). The increment is atomic, so concurrent deliveries all count.
+// @Tags inventory
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Book ID"
+// @Param change body models.StockChange true "Copies received"
+// @Success 200 {object} models.Book
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /book/{id}/stock/receive [post]
+func ReceiveStock(w http.ResponseWriter, r *http.Request) {
+	changeStock(w, r, models.AuditReceive, 1)
+}
+
+// SellStock godoc
+// @Summary Sell stock
+// @Description Take sold copies of a book out of its stock, at a location or from the unassigned stock (Admin only). Only stock not reserved by orders can be sold, and the stock never goes negative, even under concurrent sales.
+// @Tags inventory
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Book ID"
+// @Param change body models.StockChange true "Copies sold"
+// @Success 200 {object} models.Book
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 409 {object} problem.Details "Not enough copies available"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /book/{id}/stock/sell [post]
+func SellStock(w http.ResponseWriter, r *http.Request) {
+	changeStock(w, r, models.AuditSell, -1)
+}
+
+// SetReorderThreshold godoc
+// @Summary Set the reorder threshold
+// @Description Change the available stock at or below which the book shows up in the low-stock report (Admin only). Catalog updates keep the stored threshold, so this is the only way to change it after creation.
+// @Tags inventory
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Book ID"
+// @Param threshold body models.ThresholdChange true "New threshold"
+// @Success 200 {object} models.Book
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /book/{id}/stock/reorder-threshold [put]
+func SetReorderThreshold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := mux.Vars(r)
+	bookId, err := primitive.ObjectIDFromHex(params["id"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	var change models.ThresholdChange
+
+	if err := validation.DecodeJSON(r.Body, &change); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	if err := change.Validate().Err(); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	before, err := getBook(bookId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	book, err := repository.Books.SetReorderThreshold(bookId, change.ReorderThreshold, time.Now().UTC(), r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.Log.WithField("id", bookId).WithField("threshold", book.ReorderThreshold).Info("Reorder threshold set successfully!! 📦")
+	recordStockWrite(r, models.AuditReorder, before, book)
+	setCacheHeaders(w, bookETag(book), book.LastModified())
+	json.NewEncoder(w).Encode(book)
+}
+
+// GetLowStock godoc
+// @Summary Low-stock report
+// @Description List the books whose available stock (stock minus reserved) is at or below their reorder threshold, lowest first (Admin only)
+// @Tags inventory
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.StockLevel
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /books/low-stock [get]
+func GetLowStock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	books, err := repository.Books.LowStock(r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	levels := make([]models.StockLevel, len(books))
+
+	for i, book := range books {
+		levels[i] = models.NewStockLevel(book)
+	}
+
+	json.NewEncoder(w).Encode(levels)
+}
@@ -0,0 +1,361 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/middlewares"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/BULLKNIGHT/bookstore/validation"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Look a location up by its hex id, ErrNotFound for ids that are not one
+func getLocation(locationId string, ctx context.Context) (models.Location, error) {
+	id, err := primitive.ObjectIDFromHex(locationId)
+
+	if err != nil {
+		return models.Location{}, repository.ErrNotFound
+	}
+
+	return repository.Locations.Get(id, ctx)
+}
+
+func unknownLocation(field string) validation.Errors {
+	errs := validation.Errors{}
+	errs.Add(field, validation.RuleExists, field+" does not name a location")
+
+	return errs
+}
+
+// Start a movement made by the authenticated user
+func newMovement(r *http.Request, kind string, bookId primitive.ObjectID, quantity int) models.StockMovement {
+	return models.StockMovement{
+		ID:        primitive.NewObjectID(),
+		BookID:    bookId,
+		Kind:      kind,
+		Quantity:  quantity,
+		Actor:     middlewares.Username(r.Context()),
+		RequestID: middlewares.RequestID(r.Context()),
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// Append to the movement log. The stock already changed, so a failure is
+// logged rather than returned to the client.
+func recordMovement(r *http.Request, movement models.StockMovement) {
+	if err := repository.Movements.Append(movement, r.Context()); err != nil {
+		logger.Log.WithError(err).WithField("request_id", middlewares.RequestID(r.Context())).Error("Stock movement write failed!! 👎")
+	}
+}
+
+// CreateLocation godoc
+// @Summary Create a location
+// @Description Add a warehouse or store that can hold stock (Admin only). Codes are unique.
+// @Tags locations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param location body models.Location true "Location"
+// @Success 201 {object} models.Location
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 409 {object} problem.Details "Location code already used"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /locations [post]
+func CreateLocation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var location models.Location
+
+	if err := validation.DecodeJSON(r.Body, &location); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	location.Code = strings.ToUpper(strings.TrimSpace(location.Code))
+	location.Name = strings.TrimSpace(location.Name)
+
+	if err := location.Validate().Err(); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	location.ID = primitive.NewObjectID()
+	location.CreatedAt = time.Now().UTC()
+	err := repository.Locations.Insert(location, r.Context())
+
+	if errors.Is(err, repository.ErrDuplicate) {
+		problem.New(problem.TypeDuplicate, http.StatusConflict, "location code already used").Write(w, r)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.Log.WithField("id", location.ID).WithField("code", location.Code).Info("Location created successfully!! 🏬")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(location)
+}
+
+// GetLocations godoc
+// @Summary List locations
+// @Description Retrieve every warehouse and store, ordered by code
+// @Tags locations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.Location
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /locations [get]
+func GetLocations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	locations, err := repository.Locations.List(r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(locations)
+}
+
+// GetLocation godoc
+// @Summary Get a location
+// @Description Retrieve a warehouse or store by ID
+// @Tags locations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Location ID"
+// @Success 200 {object} models.Location
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 404 {object} problem.Details "Location not found"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /locations/{id} [get]
+func GetLocation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	location, err := getLocation(mux.Vars(r)["id"], r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no location found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(location)
+}
+
+// TransferStock godoc
+// @Summary Transfer stock between locations
+// @Description Move copies of a book from one location to another (Admin only). Leave from or to empty to take copies from, or return them to, the unassigned stock. The move is atomic and recorded as a movement.
+// @Tags locations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param transfer body models.StockTransfer true "Transfer"
+// @Success 200 {object} models.Book
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 409 {object} problem.Details "Not enough copies at the source"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /stock/transfers [post]
+func TransferStock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var transfer models.StockTransfer
+
+	if err := validation.DecodeJSON(r.Body, &transfer); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	if err := transfer.Validate().Err(); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	bookId, _ := primitive.ObjectIDFromHex(transfer.BookID)
+	fields := []string{"from", "to"}
+
+	// use the canonical ids, they are the keys of the stock map
+	for i, locationId := range []*string{&transfer.From, &transfer.To} {
+		if *locationId == "" {
+			continue
+		}
+
+		location, err := getLocation(*locationId, r.Context())
+
+		if errors.Is(err, repository.ErrNotFound) {
+			problem.Invalid(w, r, unknownLocation(fields[i]))
+			return
+		}
+
+		if err != nil {
+			problem.Error(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		*locationId = location.ID.Hex()
+	}
+
+	before, err := getBook(bookId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	book, err := repository.Books.TransferStock(bookId, transfer.From, transfer.To, transfer.Quantity, time.Now().UTC(), r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if errors.Is(err, repository.ErrInsufficientStock) {
+		problem.New(problem.TypeInsufficientStock, http.StatusConflict, "not enough copies at the source location").Write(w, r)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.Log.WithField("id", bookId).WithField("from", transfer.From).WithField("to", transfer.To).WithField("quantity", transfer.Quantity).Info("Stock transferred successfully!! 🚚")
+
+	movement := newMovement(r, models.MovementTransfer, bookId, transfer.Quantity)
+	movement.From = transfer.From
+	movement.To = transfer.To
+
+	recordStockWrite(r, models.AuditTransfer, before, book)
+	recordMovement(r, movement)
+	setCacheHeaders(w, bookETag(book), book.LastModified())
+	json.NewEncoder(w).Encode(book)
+}
+
+// GetAvailability godoc
+// @Summary Get the availability of a book
+// @Description Report the stock of a book at every location, plus the stock not assigned to any
+// @Tags locations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Book ID"
+// @Success 200 {object} models.Availability
+// @Failure 400 {object} problem.Details "Bad request"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /book/{id}/availability [get]
+func GetAvailability(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	bookId, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	book, err := getBook(bookId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	locations, err := repository.Locations.List(r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	availability := models.Availability{
+		BookID:     book.ID.Hex(),
+		Stock:      book.Stock,
+		Reserved:   book.Reserved,
+		Available:  book.Available(),
+		Unassigned: book.Unassigned(),
+		Locations:  make([]models.LocationStock, len(locations)),
+	}
+
+	for i, location := range locations {
+		availability.Locations[i] = models.LocationStock{
+			LocationID: location.ID.Hex(),
+			Code:       location.Code,
+			Name:       location.Name,
+			Kind:       location.Kind,
+			Quantity:   book.StockByLocation[location.ID.Hex()],
+		}
+	}
+
+	setCacheHeaders(w, bookETag(book), book.LastModified())
+	json.NewEncoder(w).Encode(availability)
+}
+
+// GetStockMovements godoc
+// @Summary List the stock movements of a book
+// @Description Retrieve every receipt, sale and transfer of a book, newest first (Admin only)
+// @Tags locations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Book ID"
+// @Success 200 {array} models.StockMovement
+// @Failure 400 {object} problem.Details "Bad request"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /book/{id}/movements [get]
+func GetStockMovements(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	bookId, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	movements, err := repository.Movements.List(bookId, r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(movements)
+}
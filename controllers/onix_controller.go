@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/onix"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Prices are stored without a currency, feeds are read and written in this
+// one unless another is asked for
+const defaultOnixCurrency = "USD"
+
+const onixSender = "Bookstore"
+
+func onixCurrency(r *http.Request) (string, error) {
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+
+	if currency == "" {
+		return defaultOnixCurrency, nil
+	}
+
+	if len(currency) != 3 || strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", errors.New("currency must be an ISO 4217 code such as USD")
+	}
+
+	return currency, nil
+}
+
+// Copy the fields the product carries over the stored book
+func mergeOnixBook(book models.Book, product models.Book) models.Book {
+	if product.Title != "" {
+		book.Title = product.Title
+	}
+
+	if product.Author != "" {
+		book.Author = product.Author
+	}
+
+	if product.Category != "" {
+		book.Category = product.Category
+	}
+
+	if product.PublishedYear > 0 {
+		book.PublishedYear = product.PublishedYear
+	}
+
+	if product.Price > 0 {
+		book.Price = product.Price
+	}
+
+	return book
+}
+
+// Create the book of the product or update the book with the same ISBN. Only
+// a storage failure is returned, a product that cannot be applied is reported
+// in its row.
+func upsertProduct(r *http.Request, product onix.Product, currency string) (models.ImportRow, error) {
+	ctx := r.Context()
+	row := models.ImportRow{Line: product.Line, Status: models.ImportRejected}
+
+	if product.NotificationType == onix.NotificationDelete {
+		row.Error = "delete notifications are not applied, delete the book instead"
+		return row, nil
+	}
+
+	book, err := product.Book(currency)
+
+	if err == nil {
+		err = normalizeIsbn(&book)
+	}
+
+	if err != nil {
+		row.Error = err.Error()
+		return row, nil
+	}
+
+	before, err := repository.Books.GetByIsbn(book.IsbnKey(), ctx)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		if err := book.Validate().Err(); err != nil {
+			row.Error = err.Error()
+			return row, nil
+		}
+
+		book.ID = primitive.NewObjectID()
+		book.Version = 1
+		book.UpdatedAt = time.Now().UTC()
+
+		err := insertBook(book, ctx)
+
+		if errors.Is(err, repository.ErrDuplicate) {
+			row.Error = errDuplicateIsbn.Error()
+			return row, nil
+		}
+
+		if err != nil {
+			return row, err
+		}
+
+		recordWrite(r, models.AuditCreate, nil, book)
+		indexBook(book)
+
+		return models.ImportRow{Line: product.Line, Status: models.ImportAccepted, ID: book.ID.Hex(), Action: models.ImportCreated}, nil
+	}
+
+	if err != nil {
+		return row, err
+	}
+
+	row = models.ImportRow{Line: product.Line, Status: models.ImportAccepted, ID: before.ID.Hex(), Action: models.ImportUnchanged}
+	book = mergeOnixBook(before, book)
+
+	// feeds are resent in full, so only real changes make a new version
+	if len(models.DiffBooks(&before, &book)) == 0 {
+		return row, nil
+	}
+
+	if err := book.Validate().Err(); err != nil {
+		return models.ImportRow{Line: product.Line, Status: models.ImportRejected, ID: before.ID.Hex(), Error: err.Error()}, nil
+	}
+
+	book.UpdatedAt = time.Now().UTC()
+	book, err = updateBook(book, before.Version, ctx)
+
+	if errors.Is(err, repository.ErrVersionConflict) || errors.Is(err, repository.ErrNotFound) {
+		return models.ImportRow{Line: product.Line, Status: models.ImportRejected, ID: before.ID.Hex(), Error: errConcurrentWrite}, nil
+	}
+
+	if err != nil {
+		return row, err
+	}
+
+	recordWrite(r, models.AuditUpdate, &before, book)
+	indexBook(book)
+	row.Action = models.ImportUpdated
+
+	return row, nil
+}
+
+// ImportOnix godoc
+// @Summary Import an ONIX 3.0 feed
+// @Description Read the product records of an ONIX 3.0 message with reference tags (Admin only). Each product is matched by ISBN: unknown ISBNs become new books, known ones are updated with the title, contributors, publication year, price and subject the record carries. Products without an ISBN or missing required fields are rejected. The price in the chosen currency is stored in minor units.
+// @Tags import
+// @Accept xml
+// @Produce json
+// @Security BearerAuth
+// @Param currency query string false "ISO 4217 currency of the price to read (default USD)"
+// @Param feed body string true "ONIX 3.0 message"
+// @Success 200 {object} models.ImportReport
+// @Failure 400 {object} problem.Details "Unreadable ONIX message"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 413 {object} problem.Details "Upload too large"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /books/onix [post]
+func ImportOnix(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	currency, err := onixCurrency(r)
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if r.Body == nil {
+		problem.Error(w, r, http.StatusBadRequest, "no data found")
+		return
+	}
+
+	products, err := onix.Decode(http.MaxBytesReader(w, r.Body, maxImportSize))
+	var tooLarge *http.MaxBytesError
+
+	if errors.As(err, &tooLarge) {
+		problem.Error(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload is larger than %d bytes", maxImportSize))
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report := models.ImportReport{Total: len(products), Rows: make([]models.ImportRow, 0, len(products))}
+
+	for _, product := range products {
+		row, err := upsertProduct(r, product, currency)
+
+		if err != nil {
+			problem.Error(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if row.Status == models.ImportAccepted {
+			report.Accepted++
+		} else {
+			report.Rejected++
+		}
+
+		report.Rows = append(report.Rows, row)
+	}
+
+	logger.Log.WithField("accepted", report.Accepted).WithField("rejected", report.Rejected).Info("ONIX feed imported successfully!! 📚")
+	json.NewEncoder(w).Encode(report)
+}
+
+// ExportOnix godoc
+// @Summary Export books as ONIX 3.0
+// @Description Write an ONIX 3.0 message with reference tags for the books given by id, or else for every book matching the listing filters. Prices are written in the chosen currency from their minor units.
+// @Tags books
+// @Produce xml
+// @Security BearerAuth
+// @Param ids query string false "Comma separated book IDs"
+// @Param currency query string false "ISO 4217 currency of the prices (default USD)"
+// @Param author query string false "Author (exact, case-insensitive)"
+// @Param category query string false "Category (exact, case-insensitive)"
+// @Param min_year query int false "Minimum published year"
+// @Param max_year query int false "Maximum published year"
+// @Param min_price query int false "Minimum price"
+// @Param max_price query int false "Maximum price"
+// @Success 200 {string} string "ONIX message"
+// @Failure 400 {object} problem.Details "Bad request - invalid query parameter"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /books/onix [get]
+func ExportOnix(w http.ResponseWriter, r *http.Request) {
+	currency, err := onixCurrency(r)
+	var query models.BookQuery
+
+	if err == nil {
+		query, err = parseBookQuery(r)
+	}
+
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// selected books are read up front so a wrong id is still a 404
+	var books []models.Book
+
+	if ids := r.URL.Query().Get("ids"); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			bookId, err := primitive.ObjectIDFromHex(strings.TrimSpace(id))
+
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+				return
+			}
+
+			book, err := getBook(bookId, r.Context())
+
+			if errors.Is(err, repository.ErrNotFound) {
+				w.Header().Set("Content-Type", "application/json")
+				problem.Error(w, r, http.StatusNotFound, "no data found by given id "+bookId.Hex())
+				return
+			}
+
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				problem.Error(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			books = append(books, book)
+		}
+	}
+
+	w.Header().Set("Content-Type", onix.MediaType)
+	w.Header().Set("Content-Disposition", `attachment; filename="books.onix.xml"`)
+
+	writer, err := onix.NewWriter(w, onixSender, time.Now())
+	count := 0
+
+	write := func(book models.Book) error {
+		count++
+		return writer.Write(onix.FromBook(book, currency, onixSender))
+	}
+
+	if err == nil && books != nil {
+		for _, book := range books {
+			if err = write(book); err != nil {
+				break
+			}
+		}
+	} else if err == nil {
+		query.Limit = 0
+		query.After = nil
+		err = repository.Books.Stream(query, write, r.Context())
+	}
+
+	if err == nil {
+		err = writer.Close()
+	}
+
+	// the status is already sent, all that is left is to stop and log
+	if err != nil {
+		logger.Log.WithError(err).WithField("count", count).Error("ONIX export failed!! 👎")
+		return
+	}
+
+	logger.Log.WithField("count", count).Info("ONIX feed exported successfully!! 📤")
+}
@@ -0,0 +1,417 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/middlewares"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/payment"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/BULLKNIGHT/bookstore/validation"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The order built from a priced cart, snapshotting titles and prices
+func newOrder(priced models.PricedCart, cart models.Cart, now time.Time) models.Order {
+	order := models.Order{
+		ID:        primitive.NewObjectID(),
+		Owner:     cart.Owner,
+		Status:    models.OrderPending,
+		Lines:     make([]models.OrderLine, len(cart.Items)),
+		ItemCount: priced.ItemCount,
+		Total:     priced.Subtotal,
+		History:   []models.OrderEvent{{Status: models.OrderPending, Actor: cart.Owner, At: now}},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	for i, line := range priced.Lines {
+		order.Lines[i] = models.OrderLine{
+			BookID:    cart.Items[i].BookID,
+			Title:     line.Title,
+			Author:    line.Author,
+			Isbn:      line.Isbn,
+			Quantity:  line.Quantity,
+			UnitPrice: line.UnitPrice,
+			LineTotal: line.LineTotal,
+		}
+	}
+
+	return order
+}
+
+// Whether the user acts on the order as an admin or as its owner, empty
+// when it is neither
+func orderActor(r *http.Request, order models.Order) string {
+	if middlewares.Role(r.Context()) == "admin" {
+		return models.ActorAdmin
+	}
+
+	if order.Owner == middlewares.Username(r.Context()) {
+		return models.ActorOwner
+	}
+
+	return ""
+}
+
+// Load an order the user may see. Orders of other users are reported
+// missing so their ids leak nothing.
+func visibleOrder(w http.ResponseWriter, r *http.Request) (models.Order, bool) {
+	orderId, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return models.Order{}, false
+	}
+
+	order, err := repository.Orders.Get(orderId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && orderActor(r, order) == "") {
+		problem.Error(w, r, http.StatusNotFound, "no order found by given id")
+		return order, false
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return order, false
+	}
+
+	return order, true
+}
+
+// Move the order to the next state, applying the stock effect of the
+// transition and storing the claimed payment. Committed copies leave from
+// the location, the unassigned stock when empty.
+func moveOrder(r *http.Request, order models.Order, transition models.OrderTransition, status string, locationId string, claimed *models.OrderPayment) (models.Order, error) {
+	move := models.OrderMove{
+		From:       order.Status,
+		Event:      models.OrderEvent{Status: status, Actor: middlewares.Username(r.Context()), At: time.Now().UTC()},
+		Stock:      transition.Stock,
+		LocationID: locationId,
+		Payment:    claimed,
+	}
+	moved, err := repository.Orders.Transition(order.ID, move, r.Context())
+
+	if err != nil {
+		return moved, err
+	}
+
+	logger.Log.WithField("id", order.ID).WithField("from", order.Status).WithField("to", status).Info("Order moved successfully!! 📬")
+
+	if transition.Stock == models.StockCommit {
+		for _, line := range moved.Lines {
+			movement := newMovement(r, models.MovementShip, line.BookID, line.Quantity)
+			movement.From = locationId
+			recordMovement(r, movement)
+		}
+	}
+
+	return moved, nil
+}
+
+// Checkout godoc
+// @Summary Check out the cart
+// @Description Turn the cart of the authenticated user into a pending order. The total is authorized on the payment method first; titles and prices are copied into the order, and the copies are reserved in the same transaction so the order holds them until it ships or is cancelled. A payment the provider confirms later leaves the order pending with a pending payment.
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param checkout body models.CheckoutRequest true "Payment method"
+// @Success 201 {object} models.Order
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 402 {object} problem.Details "Payment declined"
+// @Failure 409 {object} problem.Details "Empty cart, book no longer available or not enough stock"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Failure 502 {object} problem.Details "Payment provider failed"
+// @Failure 503 {object} problem.Details "Payments not configured"
+// @Router /checkout [post]
+func Checkout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if paymentsDisabled(w, r) {
+		return
+	}
+
+	var checkout models.CheckoutRequest
+
+	if err := validation.DecodeJSON(r.Body, &checkout); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	if err := checkout.Validate().Err(); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	cart, err := loadCart(middlewares.Username(r.Context()), r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(cart.Items) == 0 {
+		problem.Error(w, r, http.StatusConflict, "the cart is empty")
+		return
+	}
+
+	priced, err := priceCart(cart, r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for _, line := range priced.Lines {
+		if !line.Available {
+			problem.Error(w, r, http.StatusConflict, "book "+line.BookID+" is no longer available, remove it from the cart")
+			return
+		}
+	}
+
+	order := newOrder(priced, cart, time.Now().UTC())
+	request := payment.Request{Reference: order.ID.Hex(), Amount: order.Total, Method: checkout.PaymentMethod}
+	authorized, err := Payments.Authorize(request, r.Context())
+
+	if errors.Is(err, payment.ErrDeclined) {
+		problem.New(problem.TypePaymentDeclined, http.StatusPaymentRequired, "the payment method was declined").Write(w, r)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadGateway, "payment provider failed: "+err.Error())
+		return
+	}
+
+	order.Payment = &models.OrderPayment{ID: authorized.ID, Status: authorized.Status, Amount: order.Total}
+	err = repository.Orders.Place(order, r.Context())
+
+	if err != nil {
+		voidPayment(order, r.Context())
+	}
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusConflict, "a book of the cart is no longer available")
+		return
+	}
+
+	if errors.Is(err, repository.ErrInsufficientStock) {
+		problem.New(problem.TypeInsufficientStock, http.StatusConflict, "not enough copies available for every book of the cart").Write(w, r)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.Log.WithField("id", order.ID).WithField("owner", order.Owner).WithField("total", order.Total).Info("Order placed successfully!! 🧾")
+
+	// the order is placed, a cart left behind only costs the user a click.
+	// A cart changed during checkout holds books the order does not, so it
+	// is kept.
+	err = repository.Carts.Delete(cart.Owner, cart.Version, r.Context())
+
+	if errors.Is(err, repository.ErrVersionConflict) {
+		logger.Log.WithField("owner", cart.Owner).Warn("Cart changed during checkout, keeping it!! ⚠️")
+	} else if err != nil {
+		logger.Log.WithError(err).WithField("owner", cart.Owner).Error("Cart removal failed!! 👎")
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(order)
+}
+
+// GetOrders godoc
+// @Summary List orders
+// @Description Retrieve the order history of the authenticated user, newest first. Admins can pass user to see the history of another user.
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param user query string false "User name (Admin only)"
+// @Success 200 {array} models.Order
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /orders [get]
+func GetOrders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	owner := middlewares.Username(r.Context())
+
+	if user := r.URL.Query().Get("user"); user != "" && user != owner {
+		if middlewares.Role(r.Context()) != "admin" {
+			problem.Error(w, r, http.StatusForbidden, "admin role required to list the orders of another user")
+			return
+		}
+
+		owner = user
+	}
+
+	orders, err := repository.Orders.List(owner, r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(orders)
+}
+
+// GetOrder godoc
+// @Summary Get an order
+// @Description Retrieve an order of the authenticated user; admins can retrieve any order
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Order ID"
+// @Success 200 {object} models.Order
+// @Failure 400 {object} problem.Details "Bad request"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 404 {object} problem.Details "Order not found"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /orders/{id} [get]
+func GetOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if order, ok := visibleOrder(w, r); ok {
+		json.NewEncoder(w).Encode(order)
+	}
+}
+
+// TransitionOrder godoc
+// @Summary Move an order to another state
+// @Description Orders go pending → paid → shipped → delivered, and can be cancelled while pending or refunded once paid. Owners may only cancel their pending orders; every other move needs an admin. Paying captures the authorized payment, so it waits for a delayed payment to be confirmed; cancelling or refunding voids or refunds it. The order moves first and the provider is called after: a declined capture moves the order back to pending, a call that failed otherwise is retried in the background and shows as payment.settling meanwhile. Cancelling or refunding before shipping frees the reserved copies, shipping takes them out of the stock at location_id (the unassigned stock when omitted).
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Order ID"
+// @Param transition body models.OrderTransitionRequest true "Next state"
+// @Success 200 {object} models.Order
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 402 {object} problem.Details "Payment declined at capture"
+// @Failure 403 {object} problem.Details "Transition not allowed for the role"
+// @Failure 404 {object} problem.Details "Order not found"
+// @Failure 409 {object} problem.Details "Transition not allowed from the current state or the payment state, payment change in progress, concurrent modification or not enough stock to ship"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Failure 502 {object} problem.Details "Payment provider failed"
+// @Failure 503 {object} problem.Details "Payments not configured"
+// @Router /orders/{id}/transitions [post]
+func TransitionOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var request models.OrderTransitionRequest
+
+	if err := validation.DecodeJSON(r.Body, &request); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	if err := request.Validate().Err(); err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	order, ok := visibleOrder(w, r)
+
+	if !ok {
+		return
+	}
+
+	transition, ok := models.OrderTransitions[order.Status][request.Status]
+
+	if !ok {
+		problem.New(problem.TypeInvalidTransition, http.StatusConflict, "an order cannot go from "+order.Status+" to "+request.Status).Write(w, r)
+		return
+	}
+
+	if !transition.Allows(orderActor(r, order)) {
+		problem.Error(w, r, http.StatusForbidden, "admin role required to move an order to "+request.Status)
+		return
+	}
+
+	if request.LocationID != "" {
+		location, err := getLocation(request.LocationID, r.Context())
+
+		if errors.Is(err, repository.ErrNotFound) {
+			problem.Invalid(w, r, unknownLocation("location_id"))
+			return
+		}
+
+		if err != nil {
+			problem.Error(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		request.LocationID = location.ID.Hex()
+	}
+
+	if order.PaymentSettling() != "" {
+		problem.New(problem.TypeConcurrentWrite, http.StatusConflict, "a payment change of the order is still in progress, please retry later").Write(w, r)
+		return
+	}
+
+	// the transition is claimed before the provider is called, so money
+	// only moves for an order that did move
+	claimed, err := claimPayment(order, request.Status)
+
+	if errors.Is(err, errPaymentUnconfirmed) {
+		problem.New(problem.TypeInvalidTransition, http.StatusConflict, "the payment of the order is not authorized, it is "+order.Payment.Status).Write(w, r)
+		return
+	}
+
+	// orders paid before payments were turned off wait until they are back
+	if claimed != nil && paymentsDisabled(w, r) {
+		return
+	}
+
+	moved, err := moveOrder(r, order, transition, request.Status, request.LocationID, claimed)
+
+	if errors.Is(err, repository.ErrVersionConflict) {
+		problem.New(problem.TypeConcurrentWrite, http.StatusConflict, "order was modified concurrently, please retry").Write(w, r)
+		return
+	}
+
+	if errors.Is(err, repository.ErrInsufficientStock) {
+		problem.New(problem.TypeInsufficientStock, http.StatusConflict, "not enough copies at the location to ship the order").Write(w, r)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if claimed != nil {
+		moved, err = settlePayment(moved, middlewares.Username(r.Context()), r.Context())
+	}
+
+	if errors.Is(err, payment.ErrDeclined) {
+		problem.New(problem.TypePaymentDeclined, http.StatusPaymentRequired, "the payment was declined, the order is pending again").Write(w, r)
+		return
+	}
+
+	if errors.Is(err, payment.ErrInvalidState) || errors.Is(err, payment.ErrUnknownPayment) {
+		problem.New(problem.TypeInvalidTransition, http.StatusConflict, "the payment provider refused the change, the order is pending again").Write(w, r)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadGateway, "payment provider failed: "+err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(moved)
+}
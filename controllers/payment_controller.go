@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/payment"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxWebhookSize = 64 << 10
+
+// How often claimed payment calls are looked for, and how old a claim must
+// be before it counts as abandoned rather than still running
+const (
+	paymentRetryInterval = time.Minute
+	PaymentClaimTimeout  = time.Minute
+)
+
+// Name of the payment provider in the history of the orders it moved
+const paymentsActor = "payments"
+
+// Payments is the provider charged for orders, chosen by main from the
+// configuration. Without one the catalog still works but nothing can be
+// bought.
+var Payments payment.PaymentProvider
+
+var errPaymentUnconfirmed = errors.New("payment not authorized yet")
+
+// Answer 503 when no payment provider is configured
+func paymentsDisabled(w http.ResponseWriter, r *http.Request) bool {
+	if Payments != nil {
+		return false
+	}
+
+	problem.Error(w, r, http.StatusServiceUnavailable, "payments are not configured on this server")
+	return true
+}
+
+// Release the payment of an order that could not be placed
+func voidPayment(order models.Order, ctx context.Context) {
+	if _, err := Payments.Refund(order.Payment.ID, order.Payment.Amount, ctx); err != nil {
+		logger.Log.WithError(err).WithField("payment", order.Payment.ID).Error("Payment void failed!! 👎")
+	}
+}
+
+// The payment to store with the transition of the order to status, claiming
+// the provider call it needs: paying captures the authorized amount,
+// cancelling or refunding gives back whatever is held or taken. Nil when the
+// transition leaves the payment alone.
+func claimPayment(order models.Order, status string) (*models.OrderPayment, error) {
+	if order.Payment == nil {
+		return nil, nil
+	}
+
+	claimed := *order.Payment
+
+	switch status {
+	case models.OrderPaid:
+		if claimed.Status != payment.StatusAuthorized {
+			return nil, errPaymentUnconfirmed
+		}
+
+		claimed.Settling = models.SettleCapture
+	case models.OrderCancelled, models.OrderRefunded:
+		if !slices.Contains([]string{payment.StatusPending, payment.StatusAuthorized, payment.StatusCaptured}, claimed.Status) {
+			return nil, nil
+		}
+
+		claimed.Settling = models.SettleRefund
+	default:
+		return nil, nil
+	}
+
+	return &claimed, nil
+}
+
+// Make the provider call the order claimed and store its outcome. A capture
+// the provider refused moved no money, so the order goes back to pending
+// and the error is returned. A refund cannot be undone the same way, the
+// order already gave its copies back. Any other failure, like a timeout,
+// may have gone through at the provider, so the call stays claimed for
+// RetryPayments.
+func settlePayment(order models.Order, actor string, ctx context.Context) (models.Order, error) {
+	claimed := *order.Payment
+	settled := claimed
+	settled.Settling = ""
+
+	var result payment.Result
+	var err error
+
+	if claimed.Settling == models.SettleCapture {
+		result, err = Payments.Capture(claimed.ID, claimed.Amount, ctx)
+	} else {
+		result, err = Payments.Refund(claimed.ID, claimed.Amount, ctx)
+	}
+
+	now := time.Now().UTC()
+	log := logger.Log.WithField("id", order.ID).WithField("payment", claimed.ID).WithField("settling", claimed.Settling)
+
+	switch {
+	case err == nil:
+		settled.Status = result.Status
+	case !errors.Is(err, payment.ErrDeclined) && !errors.Is(err, payment.ErrInvalidState) && !errors.Is(err, payment.ErrUnknownPayment):
+		log.WithError(err).Warn("Payment call failed, it will be retried!! ⚠️")
+		return order, nil
+	case claimed.Settling == models.SettleCapture:
+		back := models.OrderMove{
+			From:     order.Status,
+			Event:    models.OrderEvent{Status: models.OrderPending, Actor: actor, At: now},
+			Payment:  &settled,
+			Settling: claimed.Settling,
+		}
+
+		if _, undoErr := repository.Orders.Transition(order.ID, back, ctx); undoErr != nil {
+			log.WithError(undoErr).Error("Order left claimed after a refused capture!! 👎")
+		}
+
+		return order, err
+	default:
+		log.WithError(err).Error("Payment refund refused by the provider, reconcile it by hand!! 👎")
+	}
+
+	stored, err := repository.Orders.SetPayment(order.ID, claimed, settled, now, ctx)
+
+	if err != nil {
+		return order, err
+	}
+
+	log.WithField("status", stored.Payment.Status).Info("Payment settled successfully!! 💳")
+	return stored, nil
+}
+
+// RetryPayments settles the payment calls claimed longer than timeout ago,
+// left behind by failed refunds or by requests that died before the provider
+// answered, and returns how many it settled
+func RetryPayments(timeout time.Duration, ctx context.Context) (int, error) {
+	if Payments == nil {
+		return 0, nil
+	}
+
+	orders, err := repository.Orders.Settling(time.Now().UTC().Add(-timeout), ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, order := range orders {
+		settled, err := settlePayment(order, paymentsActor, ctx)
+
+		if err == nil && settled.PaymentSettling() == "" {
+			count++
+		}
+	}
+
+	if count > 0 {
+		logger.Log.WithField("settled_count", count).Info("Claimed payments settled successfully!! 💳")
+	}
+
+	return count, nil
+}
+
+// StartPaymentRetry retries abandoned payment calls now and then every minute
+func StartPaymentRetry() (stop func()) {
+	ticker := time.NewTicker(paymentRetryInterval)
+	done := make(chan struct{})
+
+	retry := func() {
+		if _, err := RetryPayments(PaymentClaimTimeout, context.Background()); err != nil {
+			logger.Log.WithError(err).Error("Payment retry failed!! 👎")
+		}
+	}
+
+	go func() {
+		retry()
+
+		for {
+			select {
+			case <-ticker.C:
+				retry()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// PaymentWebhook godoc
+// @Summary Payment provider webhook
+// @Description Receive a payment event from the provider, signed in the X-Payment-Signature header. A confirmed authorization lets the order be paid; a declined one cancels the order and frees its copies. Events for payments already settled are acknowledged and ignored, so deliveries can be retried.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param X-Payment-Signature header string true "sha256= followed by the hex HMAC-SHA256 of the body"
+// @Param event body payment.Event true "Payment event"
+// @Success 204
+// @Failure 400 {object} problem.Details "Unreadable event"
+// @Failure 401 {object} problem.Details "Invalid signature"
+// @Failure 404 {object} problem.Details "No order for the payment"
+// @Failure 409 {object} problem.Details "Order modified concurrently, retry"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Failure 503 {object} problem.Details "Payments not configured"
+// @Router /payments/webhook [post]
+func PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if paymentsDisabled(w, r) {
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	event, err := Payments.VerifyWebhook(payload, r.Header.Get(payment.SignatureHeader))
+
+	if errors.Is(err, payment.ErrInvalidSignature) {
+		problem.Error(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "invalid payment event")
+		return
+	}
+
+	orderId, err := primitive.ObjectIDFromHex(event.Reference)
+
+	if err != nil {
+		problem.Error(w, r, http.StatusNotFound, "no order found for the payment")
+		return
+	}
+
+	order, err := repository.Orders.Get(orderId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && (order.Payment == nil || order.Payment.ID != event.PaymentID)) {
+		problem.Error(w, r, http.StatusNotFound, "no order found for the payment")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if order.Payment.Status != payment.StatusPending {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	settled := *order.Payment
+	settled.Status = event.Status
+	now := time.Now().UTC()
+
+	switch {
+	case event.Status == payment.StatusAuthorized:
+		_, err = repository.Orders.SetPayment(order.ID, *order.Payment, settled, now, r.Context())
+	case event.Status == payment.StatusDeclined && order.Status == models.OrderPending:
+		cancelled := models.OrderMove{
+			From:     models.OrderPending,
+			Event:    models.OrderEvent{Status: models.OrderCancelled, Actor: paymentsActor, At: now},
+			Stock:    models.OrderTransitions[models.OrderPending][models.OrderCancelled].Stock,
+			Payment:  &settled,
+			Settling: order.Payment.Settling,
+		}
+		_, err = repository.Orders.Transition(order.ID, cancelled, r.Context())
+	case event.Status == payment.StatusDeclined:
+		_, err = repository.Orders.SetPayment(order.ID, *order.Payment, settled, now, r.Context())
+	default:
+		problem.Error(w, r, http.StatusBadRequest, "unexpected payment status "+event.Status)
+		return
+	}
+
+	if errors.Is(err, repository.ErrVersionConflict) {
+		problem.New(problem.TypeConcurrentWrite, http.StatusConflict, "order was modified concurrently, please retry").Write(w, r)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.Log.WithField("id", order.ID).WithField("payment", settled.ID).WithField("status", settled.Status).Info("Payment confirmed by the provider!! 💳")
+	w.WriteHeader(http.StatusNoContent)
+}
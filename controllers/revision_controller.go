@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/middlewares"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newRevision(r *http.Request, action string, book models.Book) models.BookRevision {
+	return models.BookRevision{
+		ID:        primitive.NewObjectID(),
+		BookID:    book.ID,
+		Version:   book.Version,
+		Action:    action,
+		Actor:     middlewares.Username(r.Context()),
+		Timestamp: time.Now().UTC(),
+		Book:      book,
+	}
+}
+
+// Store revisions. Like the audit log, a failure is logged rather than
+// returned because the write already happened.
+func recordRevisions(r *http.Request, revisions ...models.BookRevision) {
+	if err := repository.Revisions.Append(revisions, r.Context()); err != nil {
+		logger.Log.WithError(err).WithField("request_id", middlewares.RequestID(r.Context())).Error("Revision history write failed!! 👎")
+	}
+}
+
+// Record a write to a single book in the audit log and its history. A nil
+// before is a creation.
+func recordWrite(r *http.Request, action string, before *models.Book, after models.Book) {
+	recordAudit(r, auditEntry(r, action, before, &after))
+	recordRevisions(r, newRevision(r, action, after))
+}
+
+// Record a stock write in the audit log. It leaves the catalog version
+// alone, so it is no revision of the book.
+func recordStockWrite(r *http.Request, action string, before models.Book, after models.Book) {
+	recordAudit(r, auditEntry(r, action, &before, &after))
+}
+
+// GetBookHistory godoc
+// @Summary Get the revision history of a book
+// @Description Retrieve every stored version of a book, oldest first, each with the fields changed since the previous one (Admin only). Deleted books keep their history.
+// @Tags books
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Book ID"
+// @Success 200 {array} models.BookRevision
+// @Failure 400 {object} problem.Details "Bad request"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Book not found"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /book/{id}/history [get]
+func GetBookHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := mux.Vars(r)
+	bookId, err := primitive.ObjectIDFromHex(params["id"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	revisions, err := repository.Revisions.List(bookId, r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// books written before revisions were kept have no history yet
+	if len(revisions) == 0 {
+		if _, err := getBook(bookId, r.Context()); errors.Is(err, repository.ErrNotFound) {
+			problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+			return
+		}
+	}
+
+	for i := range revisions {
+		var previous *models.Book
+
+		if i > 0 {
+			previous = &revisions[i-1].Book
+		}
+
+		revisions[i].Changes = models.DiffBooks(previous, &revisions[i].Book)
+	}
+
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// RollbackBook godoc
+// @Summary Roll a book back to a previous revision
+// @Description Write the content of a stored revision as a new version of the book (Admin only). Send the ETag of the book in If-Match, required, to make sure nobody changed it in the meantime; * overwrites any version.
+// @Tags books
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Book ID"
+// @Param If-Match header string true "ETag of the version being replaced"
+// @Param rollback body models.RollbackRequest true "Revision to restore"
+// @Success 200 {object} models.Book
+// @Failure 400 {object} problem.Details "Bad request"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Book or revision not found"
+// @Failure 409 {object} problem.Details "Concurrent modification or ISBN already used by another book"
+// @Failure 412 {object} problem.Details "Book was modified (If-Match mismatch)"
+// @Failure 428 {object} problem.Details "If-Match missing"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /book/{id}/rollback [post]
+func RollbackBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := mux.Vars(r)
+	bookId, err := primitive.ObjectIDFromHex(params["id"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	match, ok := expectedVersion(r)
+
+	if !ok {
+		problem.New(problem.TypePreconditionRequired, http.StatusPreconditionRequired, errPreconditionRequired).Write(w, r)
+		return
+	}
+
+	var rollback models.RollbackRequest
+
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&rollback) != nil || rollback.Version < 1 {
+		problem.Error(w, r, http.StatusBadRequest, "version of the revision to restore is required")
+		return
+	}
+
+	revision, err := repository.Revisions.Get(bookId, rollback.Version, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no revision found by given version")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	before, err := getBook(bookId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !match.allows(before.Version) {
+		problem.New(problem.TypePreconditionFailed, http.StatusPreconditionFailed, errPreconditionFailed).Write(w, r)
+		return
+	}
+
+	// the content of the revision becomes the next version
+	book := revision.Book
+	book.ID = bookId
+	book.UpdatedAt = time.Now().UTC()
+	book, err = updateBook(book, before.Version, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no data found by given id")
+		return
+	}
+
+	if errors.Is(err, repository.ErrVersionConflict) && !match.any {
+		problem.New(problem.TypePreconditionFailed, http.StatusPreconditionFailed, errPreconditionFailed).Write(w, r)
+		return
+	}
+
+	if errors.Is(err, repository.ErrVersionConflict) {
+		problem.New(problem.TypeConcurrentWrite, http.StatusConflict, errConcurrentWrite).Write(w, r)
+		return
+	}
+
+	if errors.Is(err, repository.ErrDuplicate) {
+		problem.New(problem.TypeDuplicate, http.StatusConflict, errDuplicateIsbn.Error()).Write(w, r)
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.Log.WithField("id", bookId).WithField("from_version", rollback.Version).Info("Book rolled back successfully!! ⏪")
+
+	recordWrite(r, models.AuditRollback, &before, book)
+	indexBook(book)
+	setCacheHeaders(w, bookETag(book), book.LastModified())
+	json.NewEncoder(w).Encode(book)
+}
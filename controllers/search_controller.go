@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/BULLKNIGHT/bookstore/search"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultSearchLimit = 10
+const maxSearchLimit = 50
+
+// Title matches count the most, then author, then category
+var catalogIndex = search.NewIndex(map[string]float64{
+	"title":    3,
+	"author":   2,
+	"category": 1,
+})
+
+func indexBook(book models.Book) {
+	catalogIndex.Add(book.ID.Hex(), map[string]string{
+		"title":    book.Title,
+		"author":   book.Author,
+		"category": book.Category,
+	})
+}
+
+func unindexBook(bookId primitive.ObjectID) {
+	catalogIndex.Remove(bookId.Hex())
+}
+
+// BuildSearchIndex loads the whole catalog into the search index
+func BuildSearchIndex(ctx context.Context) error {
+	books, _, err := getAllBooks(models.BookQuery{}, ctx)
+
+	if err != nil {
+		return err
+	}
+
+	catalogIndex.Reset()
+
+	for _, book := range books {
+		indexBook(book)
+	}
+
+	logger.Log.WithField("count", catalogIndex.Len()).Info("Search index built successfully!! 🔎")
+	return nil
+}
+
+// StartSearchReindex rebuilds the index every interval so that writes made
+// through other instances become searchable too
+func StartSearchReindex(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := BuildSearchIndex(context.Background()); err != nil {
+					logger.Log.WithError(err).Error("Search index rebuild failed!! 👎")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// SearchBooks godoc
+// @Summary Search books
+// @Description Full-text search over title, author and category ranked by relevance. Words match exactly, by prefix (autocomplete) or with small typos.
+// @Tags books
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param q query string true "Search words"
+// @Param limit query int false "Maximum number of results (1-50, default 10)"
+// @Success 200 {array} models.SearchResult
+// @Failure 400 {object} problem.Details "Bad request"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /books/search [get]
+func SearchBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	q := r.URL.Query().Get("q")
+
+	if q == "" {
+		problem.Error(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit := defaultSearchLimit
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			problem.Error(w, r, http.StatusBadRequest, "limit must be between 1 and 50")
+			return
+		}
+	}
+
+	results, err := searchBooks(q, limit, r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(results)
+}
+
+// The best limit live books for the query. Books deleted or purged through
+// another instance are still in the index until its next rebuild, so hits
+// are fetched limit at a time until the page is full, and the stale ones
+// are dropped from the index on the way.
+func searchBooks(q string, limit int, ctx context.Context) ([]models.SearchResult, error) {
+	hits := catalogIndex.Rank(q)
+	results := []models.SearchResult{}
+
+	for start := 0; start < len(hits) && len(results) < limit; start += limit {
+		window := hits[start:min(start+limit, len(hits))]
+		bookIds := make([]primitive.ObjectID, len(window))
+
+		for i, hit := range window {
+			// ids in the index always come from books
+			bookIds[i], _ = primitive.ObjectIDFromHex(hit.ID)
+		}
+
+		books, err := repository.Books.GetMany(bookIds, ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i, hit := range window {
+			book, ok := books[bookIds[i]]
+
+			if !ok {
+				unindexBook(bookIds[i])
+				continue
+			}
+
+			if len(results) < limit {
+				results = append(results, models.SearchResult{Book: book, Score: hit.Score, Highlights: catalogIndex.Highlight(hit)})
+			}
+		}
+	}
+
+	return results, nil
+}
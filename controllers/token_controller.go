@@ -0,0 +1,269 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/middlewares"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/BULLKNIGHT/bookstore/validation"
+)
+
+const accessTokenTTL = 15 * time.Minute
+const refreshTokenTTL = 7 * 24 * time.Hour
+
+// A refresh request only holds a token
+const maxRefreshRequestSize = 1 << 10
+
+var errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// Generate a URL-safe random string from n random bytes
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens signs a new access token and stores a new refresh token. An
+// empty family starts a new refresh token family (i.e. a new login session).
+func issueTokens(user models.User, family string, ctx context.Context) (models.TokenPair, error) {
+	accessToken, err := generateJWT(user.Name, user.Role)
+
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	refreshToken, err := randomToken(32)
+
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	if family == "" {
+		if family, err = randomToken(16); err != nil {
+			return models.TokenPair{}, err
+		}
+	}
+
+	err = repository.Tokens.InsertRefresh(models.RefreshToken{
+		Hash:      hashToken(refreshToken),
+		Username:  user.Name,
+		Family:    family,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}, ctx)
+
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	return models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// useRefreshToken marks the refresh token as used and returns its record. A
+// token presented a second time means it leaked, so its whole family is
+// revoked.
+func useRefreshToken(token string, ctx context.Context) (models.RefreshToken, error) {
+	now := time.Now()
+	stored, err := repository.Tokens.UseRefresh(hashToken(token), now, ctx)
+
+	if errors.Is(err, repository.ErrAlreadyUsed) {
+		logger.Log.WithField("username", stored.Username).Warn("Refresh token reused, revoking session!! 🚨")
+		revokeRefreshFamily(stored.Family, ctx)
+		return models.RefreshToken{}, errInvalidRefreshToken
+	}
+
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.RefreshToken{}, errInvalidRefreshToken
+	}
+
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+
+	if now.After(stored.ExpiresAt) {
+		return models.RefreshToken{}, errInvalidRefreshToken
+	}
+
+	return stored, nil
+}
+
+func revokeRefreshFamily(family string, ctx context.Context) error {
+	count, err := repository.Tokens.RevokeFamily(family, ctx)
+
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to revoke refresh tokens")
+		return err
+	}
+
+	logger.Log.WithField("delete_count", count).Info("Refresh tokens revoked successfully!! ✅")
+	return nil
+}
+
+// revokeAccessToken adds the jti to the revocation list until the token would
+// have expired anyway.
+func revokeAccessToken(tokenID string, expiresAt time.Time, ctx context.Context) error {
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(accessTokenTTL)
+	}
+
+	return repository.Tokens.Revoke(tokenID, expiresAt, ctx)
+}
+
+// Decode and check a refresh request. Without a body it is empty, unless
+// the refresh token is required.
+func validateRefreshRequest(w http.ResponseWriter, r *http.Request, required bool) (models.RefreshRequest, error) {
+	var request models.RefreshRequest
+	var body io.ReadCloser = http.NoBody
+
+	if r.Body != nil {
+		body = r.Body
+	}
+
+	reader := bufio.NewReader(http.MaxBytesReader(w, body, maxRefreshRequestSize))
+
+	if _, err := reader.Peek(1); errors.Is(err, io.EOF) && !required {
+		return request, nil
+	}
+
+	if err := validation.DecodeJSON(reader, &request); err != nil {
+		return models.RefreshRequest{}, err
+	}
+
+	if err := request.Validate(required).Err(); err != nil {
+		return models.RefreshRequest{}, err
+	}
+
+	return request, nil
+}
+
+// RefreshToken godoc
+// @Summary Refresh JWT token
+// @Description Exchange a refresh token for a new access token and a new refresh token. Each refresh token can be used once.
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body models.RefreshRequest true "Refresh token"
+// @Success 200 {object} models.TokenPair
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Invalid or expired refresh token"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /token/refresh [post]
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	request, err := validateRefreshRequest(w, r, true)
+
+	if err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	stored, err := useRefreshToken(request.RefreshToken, r.Context())
+
+	if errors.Is(err, errInvalidRefreshToken) {
+		problem.Error(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Re-read the user so that role changes and deletions take effect
+	user, err := findUser(stored.Username, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		revokeRefreshFamily(stored.Family, r.Context())
+		problem.Error(w, r, http.StatusUnauthorized, errInvalidRefreshToken.Error())
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tokens, err := issueTokens(user, stored.Family, r.Context())
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout godoc
+// @Summary Log out
+// @Description Revoke the access token used for this request and, when given, the refresh token session
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.RefreshRequest false "Refresh token to revoke"
+// @Success 200 {object} string "Logged out successfully"
+// @Failure 400 {object} problem.Details "Invalid payload, with one error per field"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /logout [post]
+func Logout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	request, err := validateRefreshRequest(w, r, false)
+
+	if err != nil {
+		problem.Invalid(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := revokeAccessToken(middlewares.TokenID(ctx), middlewares.TokenExpiry(ctx), ctx); err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if request.RefreshToken != "" {
+		stored, err := repository.Tokens.FindRefresh(hashToken(request.RefreshToken), ctx)
+
+		// only the owner may end a session
+		if err == nil && stored.Username == middlewares.Username(ctx) {
+			err = revokeRefreshFamily(stored.Family, ctx)
+		}
+
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			problem.Error(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	logger.Log.WithField("username", middlewares.Username(ctx)).Info("User logged out successfully!! 👋")
+	json.NewEncoder(w).Encode("logged out successfully")
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultTrashRetention is how long deleted books can be restored
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+const trashPurgeInterval = time.Hour
+
+func restoreBook(bookId primitive.ObjectID, ctx context.Context) (models.Book, models.Book, error) {
+	trashed, book, err := repository.Books.Restore(bookId, time.Now().UTC(), ctx)
+
+	if err != nil {
+		return trashed, book, err
+	}
+
+	logger.Log.WithField("id", bookId).Info("Book restored successfully!! ♻️")
+	return trashed, book, nil
+}
+
+// PurgeTrash permanently removes the books deleted longer than retention ago
+func PurgeTrash(retention time.Duration, ctx context.Context) (int64, error) {
+	count, err := repository.Books.Purge(time.Now().UTC().Add(-retention), ctx)
+
+	if err != nil {
+		return count, err
+	}
+
+	if count > 0 {
+		logger.Log.WithField("purge_count", count).Info("Trash purged successfully!! 🧹")
+	}
+
+	return count, nil
+}
+
+// StartTrashPurge purges the trash now and then every hour
+func StartTrashPurge(retention time.Duration) (stop func()) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	done := make(chan struct{})
+
+	purge := func() {
+		if _, err := PurgeTrash(retention, context.Background()); err != nil {
+			logger.Log.WithError(err).Error("Trash purge failed!! 👎")
+		}
+	}
+
+	go func() {
+		purge()
+
+		for {
+			select {
+			case <-ticker.C:
+				purge()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// GetTrash godoc
+// @Summary List deleted books
+// @Description Retrieve a page of books in the trash (Admin only). Accepts the same filters, sorting and cursor as GET /books.
+// @Tags trash
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Param author query string false "Author (exact, case-insensitive)"
+// @Param category query string false "Category (exact, case-insensitive)"
+// @Param sort query string false "Sort by title, price or year; prefix with - for descending"
+// @Success 200 {object} models.BookPage
+// @Failure 400 {object} problem.Details "Bad request - invalid query parameter"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /books/trash [get]
+func GetTrash(w http.ResponseWriter, r *http.Request) {
+	listBooks(w, r, true)
+}
+
+// RestoreBook godoc
+// @Summary Restore a deleted book
+// @Description Take a book out of the trash and put it back in the catalog (Admin only)
+// @Tags trash
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Book ID"
+// @Success 200 {object} models.Book
+// @Failure 400 {object} problem.Details "Bad request"
+// @Failure 401 {object} problem.Details "Unauthorized"
+// @Failure 403 {object} problem.Details "Forbidden - Admin role required"
+// @Failure 404 {object} problem.Details "Book not in trash"
+// @Failure 500 {object} problem.Details "Internal server error"
+// @Router /book/{id}/restore [post]
+func RestoreBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := mux.Vars(r)
+	bookId, err := primitive.ObjectIDFromHex(params["id"])
+
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "Invalid object id")
+		return
+	}
+
+	trashed, book, err := restoreBook(bookId, r.Context())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		problem.Error(w, r, http.StatusNotFound, "no deleted book found by given id")
+		return
+	}
+
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	recordWrite(r, models.AuditRestore, &trashed, book)
+	indexBook(book)
+	setCacheHeaders(w, bookETag(book), book.LastModified())
+	json.NewEncoder(w).Encode(book)
+}
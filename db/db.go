@@ -2,9 +2,11 @@ package db
 
 import (
 	"context"
+	"errors"
 	"os"
 
 	"github.com/BULLKNIGHT/bookstore/logger"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
@@ -12,17 +14,50 @@ import (
 
 const dbName = "bookstore"
 const collectionName = "books"
+const userCollectionName = "users"
+const refreshTokenCollectionName = "refresh_tokens"
+const revokedTokenCollectionName = "revoked_tokens"
+const bulkDeleteConfirmationCollectionName = "bulk_delete_confirmations"
+const snapshotCollectionName = "book_snapshots"
+const auditCollectionName = "audit_log"
+const revisionCollectionName = "book_revisions"
+const importJobCollectionName = "import_jobs"
+const locationCollectionName = "locations"
+const movementCollectionName = "stock_movements"
+const cartCollectionName = "carts"
+const orderCollectionName = "orders"
+const signingKeyCollectionName = "signing_keys"
 
 var Collection *mongo.Collection
+var UserCollection *mongo.Collection
+var RefreshTokenCollection *mongo.Collection
+var RevokedTokenCollection *mongo.Collection
+var BulkDeleteConfirmationCollection *mongo.Collection
+var SnapshotCollection *mongo.Collection
+var AuditCollection *mongo.Collection
+var RevisionCollection *mongo.Collection
+var ImportJobCollection *mongo.Collection
+var LocationCollection *mongo.Collection
+var MovementCollection *mongo.Collection
+var CartCollection *mongo.Collection
+var OrderCollection *mongo.Collection
+var SigningKeyCollection *mongo.Collection
 var client *mongo.Client
 
+// MongoDB error codes of a missing index and a missing collection
+const (
+	namespaceNotFound = 26
+	indexNotFound     = 27
+)
+
 func Init() (*mongo.Client, error) {
 	dbURL := os.Getenv("MONGO_URL")
 	// client options
 	optionClient := options.Client().ApplyURI(dbURL).SetMonitor(otelmongo.NewMonitor())
 
 	// connect to mongoDB
-	client, err := mongo.Connect(context.Background(), optionClient)
+	var err error
+	client, err = mongo.Connect(context.Background(), optionClient)
 
 	if err != nil {
 		return nil, err
@@ -32,12 +67,174 @@ func Init() (*mongo.Client, error) {
 
 	// collection instance
 	Collection = client.Database(dbName).Collection(collectionName)
+	UserCollection = client.Database(dbName).Collection(userCollectionName)
+	RefreshTokenCollection = client.Database(dbName).Collection(refreshTokenCollectionName)
+	RevokedTokenCollection = client.Database(dbName).Collection(revokedTokenCollectionName)
+	BulkDeleteConfirmationCollection = client.Database(dbName).Collection(bulkDeleteConfirmationCollectionName)
+	SnapshotCollection = client.Database(dbName).Collection(snapshotCollectionName)
+	AuditCollection = client.Database(dbName).Collection(auditCollectionName)
+	RevisionCollection = client.Database(dbName).Collection(revisionCollectionName)
+	ImportJobCollection = client.Database(dbName).Collection(importJobCollectionName)
+	LocationCollection = client.Database(dbName).Collection(locationCollectionName)
+	MovementCollection = client.Database(dbName).Collection(movementCollectionName)
+	CartCollection = client.Database(dbName).Collection(cartCollectionName)
+	OrderCollection = client.Database(dbName).Collection(orderCollectionName)
+	SigningKeyCollection = client.Database(dbName).Collection(signingKeyCollectionName)
 
 	logger.Log.Info("Collection instance is ready!! 👌")
 
+	if err := createIndexes(context.Background()); err != nil {
+		return nil, err
+	}
+
+	migration, err := MigrateIsbns(Collection, context.Background())
+
+	if err != nil {
+		return nil, err
+	}
+
+	// uniqueness moved from the display form of the ISBN to isbn13, but the
+	// old index still guards the books left without a key as duplicates
+	if len(migration.Duplicates) > 0 {
+		logger.Log.WithField("duplicate_count", len(migration.Duplicates)).Warn("Legacy ISBN index kept until the duplicate ISBNs are fixed!! ⚠️")
+	} else if err := dropLegacyIsbnIndex(context.Background()); err != nil {
+		return nil, err
+	}
+
 	return client, nil
 }
 
+func dropLegacyIsbnIndex(ctx context.Context) error {
+	_, err := Collection.Indexes().DropOne(ctx, "isbn_1")
+
+	var commandErr mongo.CommandError
+
+	if err != nil && !(errors.As(err, &commandErr) && (commandErr.Code == indexNotFound || commandErr.Code == namespaceNotFound)) {
+		return err
+	}
+
+	return nil
+}
+
+func createIndexes(ctx context.Context) error {
+	// support the catalog filters and the keyset pagination sort orders
+	_, err := Collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "author", Value: 1}}},
+		{Keys: bson.D{{Key: "category", Value: 1}}},
+		{Keys: bson.D{{Key: "title", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "price", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "published_year", Value: 1}, {Key: "_id", Value: 1}}},
+		// one book per ISBN, books without one are left out
+		{
+			Keys:    bson.D{{Key: "isbn13", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"isbn13": bson.M{"$gt": ""}}),
+		},
+		// trash listing and retention purge
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// user names are the login identifier, so they must be unique
+	_, err = UserCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// let MongoDB drop expired refresh tokens and revocations on its own
+	expiry := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	if _, err := RefreshTokenCollection.Indexes().CreateOne(ctx, expiry); err != nil {
+		return err
+	}
+
+	if _, err := RevokedTokenCollection.Indexes().CreateOne(ctx, expiry); err != nil {
+		return err
+	}
+
+	if _, err := BulkDeleteConfirmationCollection.Indexes().CreateOne(ctx, expiry); err != nil {
+		return err
+	}
+
+	if _, err := CartCollection.Indexes().CreateOne(ctx, expiry); err != nil {
+		return err
+	}
+
+	// snapshots are stored one document per book
+	_, err = SnapshotCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "snapshot_id", Value: 1}},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// audit queries filter by actor, book or time and page newest first
+	_, err = AuditCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "book_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// one revision per book version
+	_, err = RevisionCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "book_id", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// location codes are how staff name locations, so they must be unique
+	_, err = LocationCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// movements are listed per book, newest first
+	_, err = MovementCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "book_id", Value: 1}, {Key: "_id", Value: -1}},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// order history is listed per user, newest first
+	_, err = OrderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "owner", Value: 1}, {Key: "_id", Value: -1}},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// a key is replaced once, however many instances rotate it together
+	_, err = SigningKeyCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "previous", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
 func Disconnect() {
 	if err := client.Disconnect(context.Background()); err != nil {
 		logger.Log.WithError(err).Error("MongoDB failed to disconnect!! 👎")
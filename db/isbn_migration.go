@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BULLKNIGHT/bookstore/isbn"
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IsbnMigration is what MigrateIsbns did
+type IsbnMigration struct {
+	Migrated int
+	// Invalid books have an ISBN that is not a valid ISBN-10 or ISBN-13
+	Invalid []primitive.ObjectID
+	// Duplicates lists, by ISBN-13, the books left without the key because
+	// an older book holds it
+	Duplicates map[string][]primitive.ObjectID
+}
+
+// MigrateIsbns gives the books stored before the isbn13 key their key and
+// the normalized display form of their ISBN. Books with an invalid ISBN, or
+// with one an older book already holds, are left without a key and
+// reported, so the unique index still builds; fix them and they migrate on
+// the next start. Books that have a key are not looked at again.
+func MigrateIsbns(collection *mongo.Collection, ctx context.Context) (IsbnMigration, error) {
+	migration := IsbnMigration{Duplicates: map[string][]primitive.ObjectID{}}
+	filter := bson.M{"isbn": bson.M{"$gt": ""}, "isbn13": bson.M{"$exists": false}}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetProjection(bson.M{"isbn": 1})
+	cursor, err := collection.Find(ctx, filter, findOptions)
+
+	if err != nil {
+		return migration, err
+	}
+
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var book struct {
+			ID   primitive.ObjectID `bson:"_id"`
+			Isbn string             `bson:"isbn"`
+		}
+
+		if err := cursor.Decode(&book); err != nil {
+			return migration, err
+		}
+
+		digits, err := isbn.To13(book.Isbn)
+
+		if err != nil {
+			migration.Invalid = append(migration.Invalid, book.ID)
+			logger.Log.WithField("id", book.ID).WithField("isbn", book.Isbn).Warn("Book ISBN is invalid, fix it to migrate it!! ⚠️")
+			continue
+		}
+
+		var holder struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+
+		err = collection.FindOne(ctx, bson.M{"isbn13": digits}).Decode(&holder)
+
+		if err == nil {
+			migration.Duplicates[digits] = append(migration.Duplicates[digits], book.ID)
+			logger.Log.WithField("id", book.ID).WithField("isbn", book.Isbn).WithField("kept_by", holder.ID).Warn("Book ISBN is a duplicate, fix it to migrate it!! ⚠️")
+			continue
+		}
+
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return migration, err
+		}
+
+		// the ISBN was valid for To13, so it normalizes too
+		display, _ := isbn.Normalize(book.Isbn)
+		update := bson.M{"$set": bson.M{"isbn13": digits, "isbn": display}}
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": book.ID}, update)
+
+		// another instance migrating at the same time gave the key away first
+		if mongo.IsDuplicateKeyError(err) {
+			migration.Duplicates[digits] = append(migration.Duplicates[digits], book.ID)
+			logger.Log.WithField("id", book.ID).WithField("isbn", book.Isbn).Warn("Book ISBN is a duplicate, fix it to migrate it!! ⚠️")
+			continue
+		}
+
+		if err != nil {
+			return migration, err
+		}
+
+		migration.Migrated++
+	}
+
+	if err := cursor.Err(); err != nil {
+		return migration, err
+	}
+
+	logger.Log.WithField("migrated_count", migration.Migrated).WithField("invalid_count", len(migration.Invalid)).WithField("duplicate_count", len(migration.Duplicates)).Info("Book ISBNs migrated successfully!! 📚")
+	return migration, nil
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMigrateIsbns(t *testing.T) {
+	url := os.Getenv("MONGO_TEST_URL")
+
+	if url == "" {
+		t.Skip("MONGO_TEST_URL not set")
+	}
+
+	logger.Init()
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	database := client.Database("bookstore_test_" + primitive.NewObjectID().Hex())
+
+	t.Cleanup(func() {
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+
+	books := database.Collection("books")
+	legacy, duplicate, invalid, none := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+	_, err = books.InsertMany(ctx, []any{
+		bson.M{"_id": legacy, "title": "Go", "isbn": "0134190440"},
+		bson.M{"_id": duplicate, "title": "Go again", "isbn": "978-0-13-419044-0"},
+		bson.M{"_id": invalid, "title": "Typo", "isbn": "978-0-13-419044-1"},
+		bson.M{"_id": none, "title": "Unknown"},
+	})
+
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	for run := range 2 {
+		migration, err := MigrateIsbns(books, ctx)
+
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+
+		if migration.Migrated != 1-run || !slices.Equal(migration.Invalid, []primitive.ObjectID{invalid}) || !slices.Equal(migration.Duplicates["9780134190440"], []primitive.ObjectID{duplicate}) {
+			t.Fatalf("run %d: unexpected migration %+v", run, migration)
+		}
+	}
+
+	var migrated bson.M
+
+	if err := books.FindOne(ctx, bson.M{"_id": legacy}).Decode(&migrated); err != nil || migrated["isbn13"] != "9780134190440" || migrated["isbn"] != "978-0-13-419044-0" {
+		t.Fatalf("expected the legacy book keyed and hyphenated, got %v, %v", migrated, err)
+	}
+}
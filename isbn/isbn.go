@@ -0,0 +1,158 @@
+// Package isbn validates ISBN-10 and ISBN-13 numbers and writes them in a
+// canonical hyphenated ISBN-13 form.
+package isbn
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid ISBN")
+
+// Digits strips the hyphens and spaces of an ISBN and upper-cases an ISBN-10
+// check digit
+func Digits(value string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(value)))
+}
+
+func isDigits(value string) bool {
+	return strings.Trim(value, "0123456789") == ""
+}
+
+func checkDigit13(first12 string) byte {
+	sum := 0
+
+	for i := range 12 {
+		digit := int(first12[i] - '0')
+
+		if i%2 == 1 {
+			digit *= 3
+		}
+
+		sum += digit
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
+
+func checkDigit10(first9 string) byte {
+	sum := 0
+
+	for i := range 9 {
+		sum += int(first9[i]-'0') * (10 - i)
+	}
+
+	check := (11 - sum%11) % 11
+
+	if check == 10 {
+		return 'X'
+	}
+
+	return byte('0' + check)
+}
+
+// To13 checks an ISBN-10 or ISBN-13 and returns its 13 digits
+func To13(value string) (string, error) {
+	digits := Digits(value)
+
+	switch len(digits) {
+	case 10:
+		if !isDigits(digits[:9]) || !(isDigits(digits[9:]) || digits[9] == 'X') {
+			return "", fmt.Errorf("%w: %q has characters other than digits", ErrInvalid, value)
+		}
+
+		if checkDigit10(digits[:9]) != digits[9] {
+			return "", fmt.Errorf("%w: wrong check digit in %q", ErrInvalid, value)
+		}
+
+		first12 := "978" + digits[:9]
+		return first12 + string(checkDigit13(first12)), nil
+	case 13:
+		if !isDigits(digits) {
+			return "", fmt.Errorf("%w: %q has characters other than digits", ErrInvalid, value)
+		}
+
+		if !strings.HasPrefix(digits, "978") && !strings.HasPrefix(digits, "979") {
+			return "", fmt.Errorf("%w: an ISBN-13 starts with 978 or 979", ErrInvalid)
+		}
+
+		if checkDigit13(digits[:12]) != digits[12] {
+			return "", fmt.Errorf("%w: wrong check digit in %q", ErrInvalid, value)
+		}
+
+		return digits, nil
+	}
+
+	return "", fmt.Errorf("%w: %q must have 10 or 13 digits", ErrInvalid, value)
+}
+
+// Normalize checks an ISBN-10 or ISBN-13 and returns it as a hyphenated
+// ISBN-13, the form books are displayed in. Ranges missing from the table
+// keep the hyphenation of the value, or get none when it has none, since
+// any split made up here would be wrong. Books are keyed and looked up by
+// the digits of To13 instead.
+func Normalize(value string) (string, error) {
+	digits, err := To13(value)
+
+	if err != nil {
+		return "", err
+	}
+
+	if hyphenated, ok := hyphenate(digits); ok {
+		return hyphenated, nil
+	}
+
+	if hyphenated, ok := keepHyphenation(value, digits); ok {
+		return hyphenated, nil
+	}
+
+	return digits, nil
+}
+
+// Hyphenate splits valid ISBN-13 digits into prefix, registration group,
+// registrant, publication and check digit. Digits outside the ranges of the
+// table are returned as they are.
+func Hyphenate(digits string) string {
+	if hyphenated, ok := hyphenate(digits); ok {
+		return hyphenated
+	}
+
+	return digits
+}
+
+func hyphenate(digits string) (string, bool) {
+	prefix, rest, check := digits[:3], digits[3:12], digits[12:]
+	groupLength := lengthIn(groupRanges[prefix], rest)
+
+	if groupLength == 0 {
+		return "", false
+	}
+
+	group, rest := rest[:groupLength], rest[groupLength:]
+	registrantLength := lengthIn(registrantRanges[prefix+"-"+group], rest)
+
+	if registrantLength == 0 || registrantLength >= len(rest) {
+		return "", false
+	}
+
+	return strings.Join([]string{prefix, group, rest[:registrantLength], rest[registrantLength:], check}, "-"), true
+}
+
+// The hyphenation of the value as an ISBN-13, when it is split into all of
+// its parts: the five of an ISBN-13, or the four of an ISBN-10 which then
+// gets the 978 prefix and the check digit of the ISBN-13
+func keepHyphenation(value string, digits string) (string, bool) {
+	parts := strings.FieldsFunc(strings.ToUpper(value), func(r rune) bool { return r == '-' || r == ' ' })
+
+	if len(parts) == 4 {
+		parts = append([]string{"978"}, parts...)
+		parts[4] = digits[12:]
+	}
+
+	if len(parts) != 5 || len(parts[0]) != 3 || len(parts[4]) != 1 || strings.Join(parts, "") != digits {
+		return "", false
+	}
+
+	return strings.Join(parts, "-"), true
+}
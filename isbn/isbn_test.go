@@ -0,0 +1,52 @@
+package isbn
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"9780134190440":     "978-0-13-419044-0",
+		"0-13-419044-0":     "978-0-13-419044-0",
+		"978 0 306 40615 7": "978-0-306-40615-7",
+		"0306406152":        "978-0-306-40615-7",
+		"080442957X":        "978-0-8044-2957-3",
+		"9781593275846":     "978-1-59327-584-6",
+		"9783161484100":     "978-3-16-148410-0",
+		"9782070360024":     "978-2-07-036002-4",
+		// ranges missing from the table keep the hyphenation they came with
+		"978-85-359-0277-8": "978-85-359-0277-8",
+		"85-359-0277-5":     "978-85-359-0277-8",
+		"979 0 000 00000 1": "979-0-000-00000-1",
+		// and get none rather than a made up one
+		"9788535902778":   "9788535902778",
+		"9790000000001":   "9790000000001",
+		"978-8535-902778": "9788535902778",
+	}
+
+	for value, want := range cases {
+		got, err := Normalize(value)
+
+		if err != nil || got != want {
+			t.Fatalf("Normalize(%q) = %q, %v, want %q", value, got, err, want)
+		}
+	}
+}
+
+func TestNormalizeRejects(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"978013419044",   // too short
+		"9780134190441",  // wrong check digit
+		"0-13-419044-1",  // wrong ISBN-10 check digit
+		"9770134190440",  // not a book prefix
+		"97801341904X0",  // letters
+		"0X34190440",     // X outside the check digit
+		"97801341904400", // too long
+	} {
+		if got, err := Normalize(value); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("Normalize(%q) = %q, %v, want ErrInvalid", value, got, err)
+		}
+	}
+}
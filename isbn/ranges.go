@@ -0,0 +1,95 @@
+package isbn
+
+// A range of the International ISBN Agency range message: the digits that
+// follow the start, padded to 7, up to max have the given length
+type lengthRange struct {
+	max    string
+	length int
+}
+
+// Length of the digits starting rest, 0 when no range covers them
+func lengthIn(ranges []lengthRange, rest string) int {
+	key := (rest + "0000000")[:7]
+
+	for _, r := range ranges {
+		if key <= r.max {
+			return r.length
+		}
+	}
+
+	return 0
+}
+
+// Registration groups of each prefix
+var groupRanges = map[string][]lengthRange{
+	"978": {
+		{"5999999", 1},
+		{"6499999", 3},
+		{"6599999", 2},
+		{"6999999", 0},
+		{"7999999", 1},
+		{"9499999", 2},
+		{"9899999", 3},
+		{"9989999", 4},
+		{"9999999", 5},
+	},
+	"979": {
+		{"0999999", 0},
+		{"1299999", 2},
+		{"7999999", 0},
+		{"8999999", 1},
+	},
+}
+
+var classicRegistrants = []lengthRange{
+	{"1999999", 2},
+	{"6999999", 3},
+	{"8499999", 4},
+	{"8999999", 5},
+	{"9499999", 6},
+	{"9999999", 7},
+}
+
+// Registrant ranges of the largest registration groups. Books of other
+// groups are still accepted, only hyphenated with fewer parts.
+var registrantRanges = map[string][]lengthRange{
+	// English language
+	"978-0": classicRegistrants,
+	"978-1": {
+		{"0999999", 2},
+		{"3999999", 3},
+		{"5499999", 4},
+		{"8697999", 5},
+		{"9989999", 6},
+		{"9999999", 7},
+	},
+	// French language
+	"978-2": {
+		{"1999999", 2},
+		{"3499999", 3},
+		{"3999999", 5},
+		{"6999999", 3},
+		{"8399999", 4},
+		{"8999999", 5},
+		{"9499999", 6},
+		{"9999999", 7},
+	},
+	// German language
+	"978-3": {
+		{"0299999", 2},
+		{"0339999", 3},
+		{"0369999", 4},
+		{"0399999", 5},
+		{"1999999", 2},
+		{"6999999", 3},
+		{"8499999", 4},
+		{"8999999", 5},
+		{"9499999", 6},
+		{"9539999", 7},
+		{"9699999", 5},
+		{"9849999", 7},
+		{"9999999", 5},
+	},
+	// Japan
+	"978-4": classicRegistrants,
+}
@@ -0,0 +1,645 @@
+package keys
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const rotatedKeyBits = 2048
+const defaultOverlap = time.Hour
+
+// RefreshInterval is how often instances reload the shared keys and prune
+// the expired ones. Keys from the store start signing two refreshes after
+// they are stored, so every instance accepts their tokens by then.
+const RefreshInterval = time.Minute
+const activationDelay = 2 * RefreshInterval
+
+// storeAttempts is how many times UseStore tries to append the configured
+// key when other instances keep storing keys in between
+const storeAttempts = 3
+
+// encryptionKeyBytes is the size of the key-encryption key, AES-256
+const encryptionKeyBytes = 32
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Key is a signing key pair identified by its kid. Verification-only keys
+// have no private part. A signing key signs from ActiveAt until a newer one
+// becomes active.
+type Key struct {
+	ID       string
+	Public   *rsa.PublicKey
+	Private  *rsa.PrivateKey
+	ActiveAt time.Time
+}
+
+// JWK is the JSON Web Key representation of a public RSA key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKSet is published at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Manager holds the current signing key and the retired keys that are still
+// accepted for verification during the overlap window. With a store the
+// signing keys are shared with the other instances and survive restarts.
+type Manager struct {
+	mutex   sync.RWMutex
+	keys    []*Key
+	overlap time.Duration
+	store   repository.SigningKeyRepository
+	delay   time.Duration
+	// sealer encrypts the private keys before they reach the store
+	sealer cipher.AEAD
+}
+
+// Default is the manager used by the token issuer and the auth middleware
+var Default = NewManager(defaultOverlap)
+
+func NewManager(overlap time.Duration) *Manager {
+	return &Manager{overlap: overlap}
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint used as kid
+func Thumbprint(public *rsa.PublicKey) string {
+	// members in lexicographic order, no whitespace
+	canonical, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   encodeExponent(public.E),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+	})
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func encodeExponent(e int) string {
+	return base64.RawURLEncoding.EncodeToString(big.NewInt(int64(e)).Bytes())
+}
+
+// AddSigningKey makes the key the current signing key of this instance and
+// retires the previous one.
+func (m *Manager) AddSigningKey(private *rsa.PrivateKey) *Key {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := &Key{ID: Thumbprint(&private.PublicKey), Public: &private.PublicKey, Private: private, ActiveAt: time.Now()}
+	m.keys = append(m.removeLocked(key.ID), key)
+
+	return key
+}
+
+// AddVerificationKey accepts tokens signed with the key without ever signing
+// with it, e.g. the previous key after a manual rotation.
+func (m *Manager) AddVerificationKey(public *rsa.PublicKey) *Key {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	id := Thumbprint(public)
+
+	for _, key := range m.keys {
+		if key.ID == id {
+			return key
+		}
+	}
+
+	key := &Key{ID: id, Public: public}
+	m.keys = append([]*Key{key}, m.keys...)
+
+	return key
+}
+
+func (m *Manager) removeLocked(id string) []*Key {
+	kept := m.keys[:0]
+
+	for _, key := range m.keys {
+		if key.ID != id {
+			kept = append(kept, key)
+		}
+	}
+
+	return kept
+}
+
+// Signing keys, oldest first, caller holds the lock
+func (m *Manager) signingLocked() []*Key {
+	signing := []*Key{}
+
+	for _, key := range m.keys {
+		if key.Private != nil {
+			signing = append(signing, key)
+		}
+	}
+
+	slices.SortStableFunc(signing, func(a, b *Key) int { return a.ActiveAt.Compare(b.ActiveAt) })
+	return signing
+}
+
+// Current returns the key new tokens are signed with, the last one to
+// become active
+func (m *Manager) Current() (*Key, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	now := time.Now()
+	signing := m.signingLocked()
+
+	for i := len(signing) - 1; i >= 0; i-- {
+		if !signing[i].ActiveAt.After(now) {
+			return signing[i], nil
+		}
+	}
+
+	return nil, errors.New("no signing key configured")
+}
+
+// PublicKey looks up a verification key by kid
+func (m *Manager) PublicKey(id string) (*rsa.PublicKey, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, key := range m.keys {
+		if key.ID == id {
+			return key.Public, nil
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// SetEncryptionKey sets the key-encryption key the private keys are sealed
+// with before they are stored. Every instance sharing a store needs the same.
+func (m *Manager) SetEncryptionKey(key []byte) error {
+	if len(key) != encryptionKeyBytes {
+		return fmt.Errorf("key-encryption key must be %d bytes, got %d", encryptionKeyBytes, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return err
+	}
+
+	sealer, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sealer = sealer
+	return nil
+}
+
+// Encrypt the private key, bound to its kid so a sealed key cannot be
+// moved to another record
+func (m *Manager) seal(id string, private *rsa.PrivateKey) (string, error) {
+	nonce := make([]byte, m.sealer.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := m.sealer.Seal(nonce, nonce, x509.MarshalPKCS1PrivateKey(private), []byte(id))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (m *Manager) open(key models.SigningKey) (*rsa.PrivateKey, error) {
+	sealed, err := base64.StdEncoding.DecodeString(key.SealedKey)
+
+	if err != nil || len(sealed) < m.sealer.NonceSize() {
+		return nil, fmt.Errorf("signing key %s is not sealed", key.ID)
+	}
+
+	size := m.sealer.NonceSize()
+	der, err := m.sealer.Open(nil, sealed[:size], sealed[size:], []byte(key.ID))
+
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s does not open with the key-encryption key: %w", key.ID, err)
+	}
+
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+// UseStore shares the signing keys of the manager through the store. The
+// current key is stored when the store does not know its kid yet, taking
+// over from the newest stored key after the activation delay, so that a
+// new JWT_PRIVATE_KEY_B64 rotates every instance. Keys pruned before are
+// never brought back. Private keys are stored sealed, so the manager needs
+// its key-encryption key first.
+func (m *Manager) UseStore(store repository.SigningKeyRepository, ctx context.Context) error {
+	if m.sealer == nil {
+		return errors.New("no key-encryption key configured for the signing key store")
+	}
+
+	m.mutex.Lock()
+	m.store = store
+	m.delay = activationDelay
+	m.mutex.Unlock()
+
+	current, err := m.Current()
+
+	if err != nil {
+		return err
+	}
+
+	known := func(stored []models.SigningKey) bool {
+		return slices.ContainsFunc(stored, func(key models.SigningKey) bool { return key.ID == current.ID })
+	}
+
+	// another instance storing a key first takes the place after the head,
+	// so the key is stored again after the new head
+	for attempt := 0; attempt < storeAttempts; attempt++ {
+		stored, err := store.List(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		if known(stored) {
+			return m.Sync(ctx)
+		}
+
+		err = m.storeKey(current.Private, stored, ctx)
+
+		if err != nil && !errors.Is(err, repository.ErrDuplicate) {
+			return err
+		}
+	}
+
+	stored, err := store.List(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if !known(stored) {
+		return fmt.Errorf("signing key %s could not be stored, other instances kept storing keys", current.ID)
+	}
+
+	return m.Sync(ctx)
+}
+
+// Store the key as the successor of the newest stored key
+func (m *Manager) storeKey(private *rsa.PrivateKey, stored []models.SigningKey, ctx context.Context) error {
+	id := Thumbprint(&private.PublicKey)
+	sealed, err := m.seal(id, private)
+
+	if err != nil {
+		return err
+	}
+
+	key := models.SigningKey{ID: id, SealedKey: sealed, ActiveAt: time.Now().UTC()}
+
+	// the first key signs at once, later ones once every instance knows them
+	if len(stored) > 0 {
+		key.Previous = stored[len(stored)-1].ID
+		key.ActiveAt = key.ActiveAt.Add(m.delay)
+	}
+
+	return m.store.Insert(key, ctx)
+}
+
+// Sync replaces the signing keys of the manager with those of the store,
+// keeping the verification-only keys. Without a store it does nothing.
+func (m *Manager) Sync(ctx context.Context) error {
+	if m.store == nil {
+		return nil
+	}
+
+	stored, err := m.store.List(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	synced := []*Key{}
+
+	for _, key := range stored {
+		if key.SealedKey == "" {
+			continue
+		}
+
+		private, err := m.open(key)
+
+		if err != nil {
+			return err
+		}
+
+		synced = append(synced, &Key{ID: key.ID, Public: &private.PublicKey, Private: private, ActiveAt: key.ActiveAt})
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	kept := []*Key{}
+
+	for _, key := range m.keys {
+		if key.Private == nil {
+			kept = append(kept, key)
+		}
+	}
+
+	m.keys = append(kept, synced...)
+	return nil
+}
+
+// Rotate generates a fresh signing key. With a store it is shared with the
+// other instances and signs after the activation delay.
+func (m *Manager) Rotate() error {
+	return m.rotate(0)
+}
+
+// Rotate unless the newest signing key became active less than interval
+// ago, or is not active yet. Instances sharing a store decide on the stored
+// keys, and when another one rotated first its key is kept and this one is
+// dropped.
+func (m *Manager) rotate(interval time.Duration) error {
+	due := func(activeAt time.Time) bool {
+		return interval == 0 || time.Since(activeAt) >= interval
+	}
+
+	if m.store == nil {
+		m.mutex.RLock()
+		signing := m.signingLocked()
+		m.mutex.RUnlock()
+
+		if len(signing) > 0 && !due(signing[len(signing)-1].ActiveAt) {
+			return nil
+		}
+
+		private, err := rsa.GenerateKey(rand.Reader, rotatedKeyBits)
+
+		if err != nil {
+			return err
+		}
+
+		key := m.AddSigningKey(private)
+		logger.Log.WithField("kid", key.ID).Info("JWT signing key rotated!! 🔑")
+		return nil
+	}
+
+	ctx := context.Background()
+	stored, err := m.store.List(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if len(stored) > 0 && !due(stored[len(stored)-1].ActiveAt) {
+		return m.Sync(ctx)
+	}
+
+	private, err := rsa.GenerateKey(rand.Reader, rotatedKeyBits)
+
+	if err != nil {
+		return err
+	}
+
+	err = m.storeKey(private, stored, ctx)
+
+	if errors.Is(err, repository.ErrDuplicate) {
+		logger.Log.Info("JWT signing key already rotated by another instance!! 🔑")
+		return m.Sync(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	logger.Log.WithField("kid", Thumbprint(&private.PublicKey)).Info("JWT signing key rotated!! 🔑")
+	return m.Sync(ctx)
+}
+
+// Prune removes rotated-out signing keys older than the overlap window,
+// from the store as well
+func (m *Manager) Prune() error {
+	m.mutex.Lock()
+
+	now := time.Now()
+	cutoff := now.Add(-m.overlap)
+	signing := m.signingLocked()
+	pruned := []string{}
+
+	// a key retires when the next one becomes active
+	for i := 0; i < len(signing)-1; i++ {
+		retiredAt := signing[i+1].ActiveAt
+
+		if !retiredAt.After(now) && retiredAt.Before(cutoff) {
+			pruned = append(pruned, signing[i].ID)
+			m.keys = m.removeLocked(signing[i].ID)
+		}
+	}
+
+	m.mutex.Unlock()
+
+	if m.store == nil {
+		return nil
+	}
+
+	for _, id := range pruned {
+		if err := m.store.Prune(id, now.UTC(), context.Background()); err != nil {
+			return err
+		}
+
+		logger.Log.WithField("kid", id).Info("JWT signing key pruned!! 🔑")
+	}
+
+	return nil
+}
+
+// StartRotation rotates the signing key every interval until stop is
+// called. The age of the newest key decides, so instances sharing a store
+// rotate once per interval between them, whenever they started.
+func (m *Manager) StartRotation(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(min(interval, RefreshInterval))
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.rotate(interval); err != nil {
+					logger.Log.WithError(err).Error("JWT signing key rotation failed!! 👎")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// StartRefresh reloads the shared keys and prunes the expired ones every
+// interval until stop is called
+func (m *Manager) StartRefresh(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.Sync(context.Background()); err != nil {
+					logger.Log.WithError(err).Error("JWT signing keys failed to reload!! 👎")
+				}
+
+				if err := m.Prune(); err != nil {
+					logger.Log.WithError(err).Error("JWT signing key pruning failed!! 👎")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// JWKS returns every key that is still accepted for verification
+func (m *Manager) JWKS() JWKSet {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range m.keys {
+		set.Keys = append(set.Keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS512.Alg(),
+			Kid: key.ID,
+			N:   base64.RawURLEncoding.EncodeToString(key.Public.N.Bytes()),
+			E:   encodeExponent(key.Public.E),
+		})
+	}
+
+	return set
+}
+
+func decodeB64PEM(encoded string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+}
+
+// Init loads the signing key from JWT_PRIVATE_KEY_B64, any extra
+// verification keys from JWT_PUBLIC_KEY_B64 (comma separated) and the
+// key-encryption key of the store from JWT_KEY_ENCRYPTION_KEY_B64 into
+// Default.
+func Init() error {
+	overlap := defaultOverlap
+
+	if value := os.Getenv("JWT_KEY_OVERLAP"); value != "" {
+		parsed, err := time.ParseDuration(value)
+
+		if err != nil {
+			return err
+		}
+
+		overlap = parsed
+	}
+
+	Default = NewManager(overlap)
+
+	if os.Getenv("JWT_KEY_ENCRYPTION_KEY_B64") == "" {
+		return errors.New("JWT_KEY_ENCRYPTION_KEY_B64 is required")
+	}
+
+	encryptionKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(os.Getenv("JWT_KEY_ENCRYPTION_KEY_B64")))
+
+	if err != nil {
+		return err
+	}
+
+	if err := Default.SetEncryptionKey(encryptionKey); err != nil {
+		return err
+	}
+
+	for _, encoded := range strings.Split(os.Getenv("JWT_PUBLIC_KEY_B64"), ",") {
+		if strings.TrimSpace(encoded) == "" {
+			continue
+		}
+
+		publicKeyPEM, err := decodeB64PEM(encoded)
+
+		if err != nil {
+			return err
+		}
+
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKeyPEM)
+
+		if err != nil {
+			return err
+		}
+
+		Default.AddVerificationKey(publicKey)
+	}
+
+	privateKeyPEM, err := decodeB64PEM(os.Getenv("JWT_PRIVATE_KEY_B64"))
+
+	if err != nil {
+		return err
+	}
+
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyPEM)
+
+	if err != nil {
+		return err
+	}
+
+	key := Default.AddSigningKey(privateKey)
+
+	logger.Log.WithField("kid", key.ID).Info("JWT signing key loaded!! 🔑")
+	return nil
+}
+
+// RotationInterval reads JWT_KEY_ROTATION_INTERVAL; zero disables rotation
+func RotationInterval() (time.Duration, error) {
+	value := os.Getenv("JWT_KEY_ROTATION_INTERVAL")
+
+	if value == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(value)
+}
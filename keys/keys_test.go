@@ -0,0 +1,225 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/sirupsen/logrus"
+)
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	return private
+}
+
+var encryptionKey = bytes.Repeat([]byte{7}, encryptionKeyBytes)
+
+// An instance started with the configured key on the shared store
+func startInstance(t *testing.T, store repository.SigningKeyRepository, configured *rsa.PrivateKey, overlap time.Duration) *Manager {
+	t.Helper()
+	manager := NewManager(overlap)
+	manager.AddSigningKey(configured)
+
+	if err := manager.SetEncryptionKey(encryptionKey); err != nil {
+		t.Fatalf("set encryption key: %v", err)
+	}
+
+	if err := manager.UseStore(store, context.Background()); err != nil {
+		t.Fatalf("use store: %v", err)
+	}
+
+	return manager
+}
+
+func currentID(t *testing.T, manager *Manager) string {
+	t.Helper()
+	key, err := manager.Current()
+
+	if err != nil {
+		t.Fatalf("current: %v", err)
+	}
+
+	return key.ID
+}
+
+func TestSharedKeys(t *testing.T) {
+	logger.Init()
+	logger.Log.SetLevel(logrus.FatalLevel)
+
+	ctx := context.Background()
+	store := repository.NewMemorySigningKeyRepository()
+	configured := newKey(t)
+	first := startInstance(t, store, configured, time.Hour)
+	second := startInstance(t, store, configured, time.Hour)
+	seed := Thumbprint(&configured.PublicKey)
+
+	if currentID(t, first) != seed || currentID(t, second) != seed {
+		t.Fatal("instances started with the same key do not sign with it")
+	}
+
+	// a rotated key is published at once but only signs after the delay
+	if err := first.Rotate(); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	rotated := first.JWKS().Keys[1].Kid
+
+	if currentID(t, first) != seed || len(first.JWKS().Keys) != 2 {
+		t.Fatalf("expected the rotated key pending, got %+v", first.JWKS())
+	}
+
+	if _, err := second.PublicKey(rotated); !errors.Is(err, ErrUnknownKey) {
+		t.Fatal("the other instance knew the key before syncing")
+	}
+
+	if err := second.Sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	if _, err := second.PublicKey(rotated); err != nil {
+		t.Fatalf("the other instance does not accept the rotated key: %v", err)
+	}
+
+	// a rotation due on both instances happens once
+	if err := second.rotate(time.Hour); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	if stored, _ := store.List(ctx); len(stored) != 2 {
+		t.Fatalf("expected one rotation, got %d keys", len(stored))
+	}
+
+	// once active, a restart signs with the rotated key, not the configured one
+	first.delay = 0
+	second.delay = 0
+
+	if err := first.Rotate(); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	latest := currentID(t, first)
+	restarted := startInstance(t, store, configured, 0)
+
+	if currentID(t, restarted) != latest {
+		t.Fatalf("the restarted instance signs with %s instead of %s", currentID(t, restarted), latest)
+	}
+
+	for _, kid := range []string{seed, rotated} {
+		if _, err := restarted.PublicKey(kid); err != nil {
+			t.Fatalf("the restarted instance rejects %s: %v", kid, err)
+		}
+	}
+
+	// retired keys are pruned from the store, and never come back
+	if err := restarted.Prune(); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+
+	again := startInstance(t, store, configured, time.Hour)
+
+	if _, err := again.PublicKey(seed); !errors.Is(err, ErrUnknownKey) || currentID(t, again) != latest {
+		t.Fatalf("the pruned key came back: %v", err)
+	}
+
+	if err := second.Sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	if _, err := second.PublicKey(seed); !errors.Is(err, ErrUnknownKey) {
+		t.Fatal("the other instance still accepts the pruned key")
+	}
+}
+
+func TestSealedKeys(t *testing.T) {
+	logger.Init()
+	logger.Log.SetLevel(logrus.FatalLevel)
+
+	ctx := context.Background()
+	store := repository.NewMemorySigningKeyRepository()
+	configured := newKey(t)
+	startInstance(t, store, configured, time.Hour)
+
+	stored, _ := store.List(ctx)
+
+	if len(stored) != 1 || stored[0].SealedKey == "" {
+		t.Fatalf("expected the configured key stored, got %+v", stored)
+	}
+
+	if strings.Contains(stored[0].SealedKey, "PRIVATE KEY") || strings.Contains(stored[0].SealedKey, base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(configured))[:64]) {
+		t.Fatal("the private key is stored in the clear")
+	}
+
+	// an instance without the key-encryption key cannot use the store
+	if err := NewManager(time.Hour).UseStore(store, ctx); err == nil {
+		t.Fatal("expected the store refused without a key-encryption key")
+	}
+
+	// nor one with another key-encryption key
+	other := NewManager(time.Hour)
+	other.AddSigningKey(configured)
+	other.SetEncryptionKey(bytes.Repeat([]byte{8}, encryptionKeyBytes))
+
+	if err := other.UseStore(store, ctx); err == nil {
+		t.Fatal("expected the sealed key not to open with another key-encryption key")
+	}
+
+	if err := other.SetEncryptionKey([]byte("short")); err == nil {
+		t.Fatal("expected a short key-encryption key refused")
+	}
+}
+
+// A store where another instance rotates right before the next insert
+type racingStore struct {
+	repository.SigningKeyRepository
+	race func()
+}
+
+func (store *racingStore) Insert(key models.SigningKey, ctx context.Context) error {
+	if race := store.race; race != nil {
+		store.race = nil
+		race()
+	}
+
+	return store.SigningKeyRepository.Insert(key, ctx)
+}
+
+func TestDeployKeyAfterRace(t *testing.T) {
+	logger.Init()
+	logger.Log.SetLevel(logrus.FatalLevel)
+
+	ctx := context.Background()
+	shared := repository.NewMemorySigningKeyRepository()
+	first := startInstance(t, shared, newKey(t), time.Hour)
+	store := &racingStore{SigningKeyRepository: shared}
+
+	store.race = func() {
+		if err := first.Rotate(); err != nil {
+			t.Fatalf("rotate: %v", err)
+		}
+	}
+
+	deployed := newKey(t)
+	startInstance(t, store, deployed, time.Hour)
+	stored, _ := shared.List(ctx)
+
+	if len(stored) != 3 || stored[2].ID != Thumbprint(&deployed.PublicKey) || stored[2].Previous != stored[1].ID {
+		t.Fatalf("expected the deployed key stored after the rotated one, got %+v", stored)
+	}
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/BULLKNIGHT/bookstore/controllers"
 	"github.com/BULLKNIGHT/bookstore/db"
 	_ "github.com/BULLKNIGHT/bookstore/docs"
+	"github.com/BULLKNIGHT/bookstore/keys"
 	"github.com/BULLKNIGHT/bookstore/logger"
 	"github.com/BULLKNIGHT/bookstore/middlewares"
 	"github.com/BULLKNIGHT/bookstore/otel"
+	"github.com/BULLKNIGHT/bookstore/payment"
+	"github.com/BULLKNIGHT/bookstore/repository"
 	"github.com/BULLKNIGHT/bookstore/routes"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -54,19 +60,103 @@ func main() {
 	// Initialize logger
 	logger.Init()
 
-	// Initialize DB
-	if _, err := db.Init(); err != nil {
+	// Load JWT signing keys
+	if err := keys.Init(); err != nil {
+		logger.Log.WithError(err).Error("JWT signing keys failed to load!! 👎")
+		return
+	}
+
+	// Initialize storage, STORE=memory runs without MongoDB for development
+	if os.Getenv("STORE") == "memory" {
+		repository.UseMemory()
+		logger.Log.Warn("Using in-memory store, data is lost on restart!! ⚠️")
+	} else if _, err := db.Init(); err != nil {
 		logger.Log.WithError(err).Error("MongoDB connection failed!! 👎")
 		return
 	} else {
 		defer func() {
 			db.Disconnect()
 		}()
+
+		repository.UseMongo()
+	}
+
+	// Share the signing keys with the other instances through the store,
+	// so tokens verify everywhere and survive restarts
+	if err := keys.Default.UseStore(repository.SigningKeys, context.Background()); err != nil {
+		logger.Log.WithError(err).Error("JWT signing keys failed to load!! 👎")
+		return
+	}
+
+	stopRefresh := keys.Default.StartRefresh(keys.RefreshInterval)
+	defer stopRefresh()
+
+	// Rotate signing keys on schedule if configured
+	if interval, err := keys.RotationInterval(); err != nil {
+		logger.Log.WithError(err).Error("Invalid JWT_KEY_ROTATION_INTERVAL")
+		return
+	} else if interval > 0 {
+		stop := keys.Default.StartRotation(interval)
+		defer stop()
+	}
+
+	// Create the admin account if configured
+	if err := controllers.SeedAdmin(context.Background()); err != nil {
+		logger.Log.WithError(err).Error("Admin account seeding failed!! 👎")
+		return
+	}
+
+	// Load the catalog into the search index
+	if err := controllers.BuildSearchIndex(context.Background()); err != nil {
+		logger.Log.WithError(err).Error("Search index build failed!! 👎")
+		return
+	}
+
+	// Periodically rebuild it if several instances share the database
+	if value := os.Getenv("SEARCH_REINDEX_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+
+		if err != nil || interval <= 0 {
+			logger.Log.WithError(err).Error("Invalid SEARCH_REINDEX_INTERVAL")
+			return
+		}
+
+		stop := controllers.StartSearchReindex(interval)
+		defer stop()
+	}
+
+	// Permanently remove books that stayed in the trash past the retention
+	retention := controllers.DefaultTrashRetention
+
+	if value := os.Getenv("BOOK_TRASH_RETENTION"); value != "" {
+		var err error
+		retention, err = time.ParseDuration(value)
+
+		if err != nil || retention <= 0 {
+			logger.Log.WithError(err).Error("Invalid BOOK_TRASH_RETENTION")
+			return
+		}
+	}
+
+	stopPurge := controllers.StartTrashPurge(retention)
+	defer stopPurge()
+
+	// Forget carts left untouched for longer than their TTL
+	if value := os.Getenv("CART_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+
+		if err != nil || ttl <= 0 {
+			logger.Log.WithError(err).Error("Invalid CART_TTL")
+			return
+		}
+
+		controllers.CartTTL = ttl
 	}
 
 	r := mux.NewRouter()
 
 	r.Use(middlewares.RecoverMiddleware)
+	r.Use(middlewares.RequestIDMiddleware)
 	r.Use(middlewares.RateLimiterMiddleware)
 	r.Use(otelmux.Middleware("bookstore-api"))
 	r.Use(middlewares.LoggerMiddleware)
@@ -79,6 +169,51 @@ func main() {
 		logger.Log.Info("Using default port 4000")
 	}
 
+	// Take orders only with a payment provider, and never with webhooks
+	// anyone could sign
+	switch provider := os.Getenv("PAYMENT_PROVIDER"); provider {
+	case "":
+		logger.Log.Warn("No PAYMENT_PROVIDER, checkout is disabled!! ⚠️")
+	case payment.ProviderFake:
+		secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+
+		if secret == "" {
+			logger.Log.Error("PAYMENT_WEBHOOK_SECRET is required!! 👎")
+			return
+		}
+
+		// the in-process fake approves the fake payment methods, and confirms
+		// delayed payments by calling the webhook back like a real provider
+		gateway := payment.NewFakeGateway(secret)
+		gateway.WebhookURL = os.Getenv("PAYMENT_WEBHOOK_URL")
+
+		if gateway.WebhookURL == "" {
+			logger.Log.Warn("No PAYMENT_WEBHOOK_URL, delayed fake payments stay pending!! ⚠️")
+		}
+
+		if value := os.Getenv("PAYMENT_CONFIRM_DELAY"); value != "" {
+			delay, err := time.ParseDuration(value)
+
+			if err != nil || delay < 0 {
+				logger.Log.WithError(err).Error("Invalid PAYMENT_CONFIRM_DELAY")
+				return
+			}
+
+			gateway.Delay = delay
+		}
+
+		controllers.Payments = gateway
+		logger.Log.Warn("Using the fake payment gateway, orders are never charged!! ⚠️")
+
+		// Settle the refunds that failed and the provider calls of requests
+		// that died halfway
+		stopRetry := controllers.StartPaymentRetry()
+		defer stopRetry()
+	default:
+		logger.Log.WithField("provider", provider).Error("Unsupported PAYMENT_PROVIDER!! 👎")
+		return
+	}
+
 	err := http.ListenAndServe(":"+port, r)
 	if err != nil {
 		logger.Log.WithError(err).Error("Server failed to start")
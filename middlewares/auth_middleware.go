@@ -2,49 +2,34 @@ package middlewares
 
 import (
 	"context"
-	"crypto/rsa"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"net/http"
-	"os"
 	"strings"
 
+	"github.com/BULLKNIGHT/bookstore/keys"
 	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func loadPublicKey() *rsa.PublicKey {
-	encodedKey := os.Getenv("JWT_PUBLIC_KEY_B64")
-
-	// Decode the token from base64
-	publicKeyPEM, err := base64.StdEncoding.DecodeString(encodedKey)
-
-	if err != nil {
-		logger.Log.WithError(err).Fatal("Failed to decode jwt public key 🔑!!")
-	}
-
-	// Parse the token
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKeyPEM)
-
-	if err != nil {
-		logger.Log.WithError(err).Fatal("Failed to parse jwt public key 🔑!!")
-	}
-
-	return publicKey
-}
-
 // Validate token using custom logic
 func validateTokenMethod(token *jwt.Token) (any, error) {
 	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 		return nil, errors.New("invalid token signing method")
 	}
 
-	publicKey := loadPublicKey()
-	return publicKey, nil
+	// Pick the verification key named by the kid header
+	kid, _ := token.Header["kid"].(string)
+
+	if kid == "" {
+		return nil, errors.New("token has no key id")
+	}
+
+	return keys.Default.PublicKey(kid)
 }
 
-func validateToken(tokenString string) (jwt.MapClaims, error) {
+func validateToken(tokenString string, ctx context.Context) (jwt.MapClaims, error) {
 	// Parse token using public key
 	token, err := jwt.Parse(tokenString, validateTokenMethod)
 
@@ -53,11 +38,30 @@ func validateToken(tokenString string) (jwt.MapClaims, error) {
 	}
 
 	// Extract claims from valid token
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		return claims, nil
+	claims, ok := token.Claims.(jwt.MapClaims)
+
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+
+	// Tokens without an id cannot be revoked, so they are not accepted
+	tokenID, _ := claims["jti"].(string)
+
+	if tokenID == "" {
+		return nil, errors.New("token has no id")
 	}
 
-	return nil, errors.New("invalid token")
+	revoked, err := repository.Tokens.IsRevoked(tokenID, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, errors.New("token has been revoked")
+	}
+
+	return claims, nil
 }
 
 func AuthMiddleware(next http.Handler) http.Handler {
@@ -66,23 +70,26 @@ func AuthMiddleware(next http.Handler) http.Handler {
 
 		// Verify if token provided with correct prefix
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode("missing or invalid Authorization header")
+			problem.Error(w, r, http.StatusUnauthorized, "missing or invalid Authorization header")
 			return
 		}
 
 		token := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := validateToken(token)
+		claims, err := validateToken(token, r.Context())
 
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
 			logger.Log.WithError(err).Error(err.Error())
-			json.NewEncoder(w).Encode(err)
+			problem.Error(w, r, http.StatusUnauthorized, err.Error())
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), usernameKey, claims["username"])
 		ctx = context.WithValue(ctx, roleKey, claims["role"])
+		ctx = context.WithValue(ctx, tokenIDKey, claims["jti"])
+
+		if expiry, err := claims.GetExpirationTime(); err == nil && expiry != nil {
+			ctx = context.WithValue(ctx, tokenExpiryKey, expiry.Time)
+		}
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -1,9 +1,47 @@
 package middlewares
 
+import (
+	"context"
+	"time"
+)
+
 type contextKey string
 
 const (
-	usernameKey contextKey = "username"
-	roleKey     contextKey = "role"
-	loggerKey   contextKey = "logger"
+	usernameKey    contextKey = "username"
+	roleKey        contextKey = "role"
+	loggerKey      contextKey = "logger"
+	tokenIDKey     contextKey = "token_id"
+	tokenExpiryKey contextKey = "token_expiry"
+	requestIDKey   contextKey = "request_id"
 )
+
+// Username returns the authenticated user name set by AuthMiddleware
+func Username(ctx context.Context) string {
+	username, _ := ctx.Value(usernameKey).(string)
+	return username
+}
+
+// Role returns the authenticated user role set by AuthMiddleware
+func Role(ctx context.Context) string {
+	role, _ := ctx.Value(roleKey).(string)
+	return role
+}
+
+// TokenID returns the jti of the access token used for the request
+func TokenID(ctx context.Context) string {
+	tokenID, _ := ctx.Value(tokenIDKey).(string)
+	return tokenID
+}
+
+// TokenExpiry returns the expiry of the access token used for the request
+func TokenExpiry(ctx context.Context) time.Time {
+	expiry, _ := ctx.Value(tokenExpiryKey).(time.Time)
+	return expiry
+}
+
+// RequestID returns the id given to the request by RequestIDMiddleware
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
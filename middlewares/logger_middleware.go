@@ -20,8 +20,9 @@ func LoggerMiddleware(next http.Handler) http.Handler {
 		start := time.Now()
 
 		log := logger.Log.WithFields(map[string]any{
-			"method": r.Method,
-			"path":   r.URL.Path,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"request_id": RequestID(r.Context()),
 		})
 
 		ctx := context.WithValue(r.Context(), loggerKey, log)
@@ -1,11 +1,11 @@
 package middlewares
 
 import (
-	"encoding/json"
 	"net/http"
 	"sync"
 
 	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/problem"
 	"golang.org/x/time/rate"
 )
 
@@ -27,10 +27,8 @@ func RateLimiterMiddleware(next http.Handler) http.Handler {
 		mutex.Unlock()
 
 		if !limiter.Allow() {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusTooManyRequests)
 			logger.Log.Error("Too many requests")
-			json.NewEncoder(w).Encode(map[string]string{"error": "Too many requests"})
+			problem.Error(w, r, http.StatusTooManyRequests, "too many requests, slow down")
 			return
 		}
 
@@ -1,25 +1,16 @@
 package middlewares
 
 import (
-	"encoding/json"
 	"net/http"
-)
 
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message"`
-}
+	"github.com/BULLKNIGHT/bookstore/problem"
+)
 
 func RecoverMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(ErrorResponse{
-					Error:   "internal server error",
-					Message: "something went wrong. Please try again later.",
-				})
+				problem.Error(w, r, http.StatusInternalServerError, "something went wrong, please try again later")
 			}
 		}()
 
@@ -1,10 +1,10 @@
 package middlewares
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/problem"
 )
 
 func RoleMiddleware(requiredRole string) func(http.Handler) http.Handler {
@@ -15,8 +15,7 @@ func RoleMiddleware(requiredRole string) func(http.Handler) http.Handler {
 			logger.Log.WithField("role", role).Info()
 
 			if !ok || role != requiredRole {
-				w.WriteHeader(http.StatusForbidden)
-				json.NewEncoder(w).Encode("forbidden")
+				problem.Error(w, r, http.StatusForbidden, requiredRole+" role required")
 				return
 			}
 
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditCreate     = "create"
+	AuditUpdate     = "update"
+	AuditPatch      = "patch"
+	AuditDelete     = "delete"
+	AuditBulkDelete = "bulk_delete"
+	AuditRestore    = "restore"
+	AuditRollback   = "rollback"
+	AuditReceive    = "receive"
+	AuditSell       = "sell"
+	AuditTransfer   = "transfer"
+	AuditReorder    = "reorder"
+)
+
+const DefaultAuditPageSize = 50
+const MaxAuditPageSize = 500
+
+// AuditEntry records a single write to a book
+// @Description Who changed which book, how, and when
+type AuditEntry struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id" swaggertype:"string" example:"6790f0c2a1b2c3d4e5f60718"`
+	Actor     string             `json:"actor" bson:"actor" example:"admin"`
+	Role      string             `json:"role" bson:"role" example:"admin"`
+	Action    string             `json:"action" bson:"action" example:"update"`
+	BookID    primitive.ObjectID `json:"book_id" bson:"book_id" swaggertype:"string" example:"6790f0c2a1b2c3d4e5f60719"`
+	Changes   []FieldChange      `json:"changes" bson:"changes"`
+	RequestID string             `json:"request_id" bson:"request_id" example:"4f9c2d7e8a1b3c5d6e7f8a9b0c1d2e3f"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp" example:"2025-01-01T12:00:00Z"`
+}
+
+// FieldChange is one field of a book before and after a write. A missing
+// side is null.
+// @Description Value of a field before and after the write
+type FieldChange struct {
+	Field  string `json:"field" bson:"field" example:"price"`
+	Before any    `json:"before" bson:"before" swaggertype:"object"`
+	After  any    `json:"after" bson:"after" swaggertype:"object"`
+}
+
+// AuditQuery filters the audit log, newest first. Zero values mean "no
+// constraint"; After is the id of the last entry of the previous page.
+type AuditQuery struct {
+	Actor  string
+	BookID primitive.ObjectID
+	From   time.Time
+	To     time.Time
+	Limit  int
+	After  primitive.ObjectID
+}
+
+// AuditPage is a page of the audit log
+// @Description Audit entries, newest first, with the cursor of the next page
+type AuditPage struct {
+	Data       []AuditEntry `json:"data"`
+	NextCursor string       `json:"next_cursor,omitempty" example:"6790f0c2a1b2c3d4e5f60718"`
+}
+
+// Bookkeeping fields that change on every write
+var ignoredDiffFields = []string{"_id", "version", "updated_at"}
+
+func bookFields(book *Book) map[string]any {
+	fields := map[string]any{}
+
+	if book == nil {
+		return fields
+	}
+
+	encoded, _ := json.Marshal(book)
+	json.Unmarshal(encoded, &fields)
+
+	return fields
+}
+
+// DiffBooks lists the fields that differ between two versions of a book,
+// sorted by field name. A nil side stands for a book that did not exist.
+func DiffBooks(before *Book, after *Book) []FieldChange {
+	beforeFields := bookFields(before)
+	afterFields := bookFields(after)
+	names := []string{}
+
+	for name := range beforeFields {
+		names = append(names, name)
+	}
+
+	for name := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+	changes := []FieldChange{}
+
+	for _, name := range names {
+		if slices.Contains(ignoredDiffFields, name) || reflect.DeepEqual(beforeFields[name], afterFields[name]) {
+			continue
+		}
+
+		changes = append(changes, FieldChange{Field: name, Before: beforeFields[name], After: afterFields[name]})
+	}
+
+	return changes
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiffBooks(t *testing.T) {
+	before := Book{Title: "Go", Author: "Alan Donovan", Price: 30, Category: "Programming", Version: 1}
+	after := before
+	after.Price = 35
+	after.Category = ""
+	after.Version = 2
+	after.UpdatedAt = time.Now()
+
+	changes := DiffBooks(&before, &after)
+
+	if len(changes) != 2 || changes[0].Field != "category" || changes[1].Field != "price" {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+
+	if changes[1].Before != float64(30) || changes[1].After != float64(35) {
+		t.Fatalf("unexpected price change %+v", changes[1])
+	}
+
+	if changes := DiffBooks(&before, &before); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %+v", changes)
+	}
+
+	// a creation lists every field that was set
+	created := DiffBooks(nil, &before)
+
+	for _, change := range created {
+		if change.Before != nil {
+			t.Fatalf("creation has a before value %+v", change)
+		}
+	}
+
+	if len(created) != 9 {
+		t.Fatalf("expected 9 fields, got %+v", created)
+	}
+}
@@ -1,21 +1,138 @@
 package models
 
 import (
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/isbn"
+	"github.com/BULLKNIGHT/bookstore/validation"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Bounds of the book fields, prices are in minor units
+const (
+	MaxTitleLength    = 300
+	MaxAuthorLength   = 300
+	MaxCategoryLength = 100
+	MinPublishedYear  = 1450
+	MaxPrice          = 100_000_00
+	MaxStock          = 1_000_000
+)
+
 // Book represents a book in the bookstore
 // @Description Book information with details like title, author, price, etc.
 type Book struct {
-	ID            primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty" swaggerignore:"true"`
-	Title         string             `json:"title" bson:"title" example:"The Go Programming Language"`
-	Author        string             `json:"author" bson:"author" example:"Charles Babage"`
-	Isbn          string             `json:"isbn" bson:"isbn" example:"978-0134190440"`
-	PublishedYear int                `json:"published_year" bson:"published_year" example:"2015"`
-	Price         int                `json:"price" bson:"price" example:"2999"`
-	Category      string             `json:"category" bson:"category" example:"Programming"`
+	ID     primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty" swaggerignore:"true"`
+	Title  string             `json:"title" bson:"title" example:"The Go Programming Language"`
+	Author string             `json:"author" bson:"author" example:"Charles Babage"`
+	Isbn   string             `json:"isbn" bson:"isbn" example:"978-0-13-419044-0"`
+	// Isbn13 is the bare ISBN-13 the book is unique and looked up by, set by
+	// the repository on every write. Isbn is only the display form.
+	Isbn13        string `json:"-" bson:"isbn13" swaggerignore:"true"`
+	PublishedYear int    `json:"published_year" bson:"published_year" example:"2015"`
+	Price         int    `json:"price" bson:"price" example:"2999"`
+	Category      string `json:"category" bson:"category" example:"Programming"`
+	// Version counts the catalog writes. Stock, reservation and threshold
+	// writes count in StockVersion instead, so a sale never invalidates the
+	// ETag an admin edits the book with.
+	Version      int        `json:"version" bson:"version" swaggerignore:"true"`
+	StockVersion int        `json:"-" bson:"stock_version" swaggerignore:"true"`
+	UpdatedAt    time.Time  `json:"updated_at" bson:"updated_at" swaggerignore:"true"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty" swaggerignore:"true"`
+	DeletedBy    string     `json:"deleted_by,omitempty" bson:"deleted_by,omitempty" swaggerignore:"true"`
+
+	// Stock, Reserved and StockByLocation only change through the stock
+	// endpoints and orders; a stock sent on create is the opening stock
+	Stock            int `json:"stock" bson:"stock" example:"12"`
+	Reserved         int `json:"reserved" bson:"reserved" example:"2"`
+	ReorderThreshold int `json:"reorder_threshold" bson:"reorder_threshold" example:"5"`
+	// Copies held at each location, keyed by location id. The rest of the
+	// stock is unassigned.
+	StockByLocation map[string]int `json:"stock_by_location,omitempty" bson:"stock_by_location,omitempty"`
+}
+
+// IsbnKey returns the 13 digits of the ISBN of the book, empty when it has
+// no valid one
+func (book *Book) IsbnKey() string {
+	digits, err := isbn.To13(book.Isbn)
+
+	if err != nil {
+		return ""
+	}
+
+	return digits
 }
 
-func (book *Book) IsValid() bool {
-	return book.Title != "" && book.Author != "" && book.Price > 0
+// Validate reports every field breaking a rule. A zero published year or an
+// empty ISBN means unknown.
+func (book *Book) Validate() validation.Errors {
+	errs := validation.Errors{}
+
+	if errs.Required("title", book.Title) {
+		errs.Length("title", book.Title, 1, MaxTitleLength)
+	}
+
+	if errs.Required("author", book.Author) {
+		errs.Length("author", book.Author, 1, MaxAuthorLength)
+	}
+
+	errs.Length("category", book.Category, 0, MaxCategoryLength)
+
+	if book.Price == 0 {
+		errs.Add("price", validation.RuleRequired, "price is required")
+	} else {
+		errs.Range("price", book.Price, 1, MaxPrice)
+	}
+
+	// nothing is published in the future
+	if book.PublishedYear != 0 {
+		errs.Range("published_year", book.PublishedYear, MinPublishedYear, time.Now().Year())
+	}
+
+	if book.Isbn != "" {
+		if _, err := isbn.To13(book.Isbn); err != nil {
+			errs.Add("isbn", validation.RuleFormat, err.Error())
+		}
+	}
+
+	errs.Range("stock", book.Stock, 0, MaxStock)
+	errs.Range("reorder_threshold", book.ReorderThreshold, 0, MaxStock)
+
+	return errs
+}
+
+// InTrash reports whether the book was soft deleted
+func (book *Book) InTrash() bool {
+	return book.DeletedAt != nil
+}
+
+// Available is the stock that is not reserved by orders
+func (book *Book) Available() int {
+	return book.Stock - book.Reserved
+}
+
+// Unassigned is the stock held at no location
+func (book *Book) Unassigned() int {
+	unassigned := book.Stock
+
+	for _, quantity := range book.StockByLocation {
+		unassigned -= quantity
+	}
+
+	return unassigned
+}
+
+// LowOnStock reports whether the available stock is down to the reorder
+// threshold
+func (book *Book) LowOnStock() bool {
+	return book.Available() <= book.ReorderThreshold
+}
+
+// LastModified is the time of the last write, falling back to the creation
+// time encoded in the ObjectID for books stored before updated_at existed
+func (book *Book) LastModified() time.Time {
+	if !book.UpdatedAt.IsZero() {
+		return book.UpdatedAt
+	}
+
+	return book.ID.Timestamp()
 }
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// BookSortFields maps the public sort keys to stored field names
+var BookSortFields = map[string]string{
+	"title": "title",
+	"price": "price",
+	"year":  "published_year",
+}
+
+// BookQuery describes a filtered, sorted page of the catalog. Zero values
+// mean "no constraint"; an empty SortField sorts by insertion order (_id).
+type BookQuery struct {
+	Author     string
+	Category   string
+	MinYear    int
+	MaxYear    int
+	MinPrice   int
+	MaxPrice   int
+	SortField  string
+	Descending bool
+	Limit      int
+	After      *BookCursor
+	// Trash lists the soft deleted books instead of the live catalog
+	Trash bool
+}
+
+// BookCursor points just past the last book of a page: the value of the sort
+// field plus the _id as tie breaker.
+type BookCursor struct {
+	Value any                `json:"v,omitempty"`
+	ID    primitive.ObjectID `json:"id"`
+}
+
+// BookPage is one page of the catalog listing
+// @Description Page of books with the total number of matches and a link to the next page
+type BookPage struct {
+	Data       []Book `json:"data"`
+	Total      int64  `json:"total" example:"1250"`
+	NextCursor string `json:"next_cursor,omitempty" example:"eyJ2IjoiR28iLCJpZCI6IjY1MGYxYzJlOWI..."`
+	Next       string `json:"next,omitempty" example:"/books?limit=20&cursor=eyJ2IjoiR28iLCJpZCI6IjY1MGYxYzJlOWI..."`
+}
+
+// SortValue returns the value of the given stored field used for ordering
+func (book *Book) SortValue(field string) any {
+	switch field {
+	case "title":
+		return book.Title
+	case "price":
+		return book.Price
+	case "published_year":
+		return book.PublishedYear
+	}
+
+	return nil
+}
+
+// Encode returns the opaque cursor string handed to clients
+func (cursor *BookCursor) Encode() string {
+	body, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+// DecodeBookCursor parses a cursor produced for the given sort field
+func DecodeBookCursor(encoded string, sortField string) (*BookCursor, error) {
+	invalid := errors.New("invalid cursor")
+
+	body, err := base64.RawURLEncoding.DecodeString(encoded)
+
+	if err != nil {
+		return nil, invalid
+	}
+
+	var raw struct {
+		Value json.RawMessage    `json:"v"`
+		ID    primitive.ObjectID `json:"id"`
+	}
+
+	if err := json.Unmarshal(body, &raw); err != nil || raw.ID.IsZero() {
+		return nil, invalid
+	}
+
+	cursor := &BookCursor{ID: raw.ID}
+
+	switch sortField {
+	case "":
+		return cursor, nil
+	case "title":
+		var value string
+		err = json.Unmarshal(raw.Value, &value)
+		cursor.Value = value
+	default:
+		var value int
+		err = json.Unmarshal(raw.Value, &value)
+		cursor.Value = value
+	}
+
+	if err != nil {
+		return nil, invalid
+	}
+
+	return cursor, nil
+}
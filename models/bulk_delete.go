@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// BulkDeletePreview is the dry run of DELETE /books
+// @Description Number of books the filter would delete and the token that confirms it
+type BulkDeletePreview struct {
+	Count             int64     `json:"count" example:"42"`
+	ConfirmationToken string    `json:"confirmation_token" example:"Xk2m9V0cQ1w8r7T6y5U4i3O2p1A0s9D8f7G6h5J4k3L"`
+	ExpiresAt         time.Time `json:"expires_at" example:"2025-01-01T12:05:00Z"`
+}
+
+// BulkDeleteResult is returned once a confirmed bulk delete ran
+// @Description Number of books moved to the trash and the snapshot taken before
+type BulkDeleteResult struct {
+	Deleted    int64  `json:"deleted" example:"42"`
+	SnapshotID string `json:"snapshot_id" example:"6790f0c2a1b2c3d4e5f60718"`
+}
+
+// BulkDeleteConfirmation is the server-side record of a dry run. Only the
+// SHA-256 hash of the token is stored.
+type BulkDeleteConfirmation struct {
+	Hash   string `bson:"_id"`
+	Actor  string `bson:"actor"`
+	Filter string `bson:"filter"`
+	Count  int64  `bson:"count"`
+	// Books is the SHA-256 hash of the sorted ids of the previewed books, so
+	// a book swapped for another under the filter is noticed
+	Books     string    `bson:"books"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// BookSnapshot is a copy of the books taken right before a bulk delete
+// @Description Books as they were before a bulk delete
+type BookSnapshot struct {
+	ID      string    `json:"id" example:"6790f0c2a1b2c3d4e5f60718"`
+	TakenAt time.Time `json:"taken_at" example:"2025-01-01T12:03:00Z"`
+	TakenBy string    `json:"taken_by" example:"admin"`
+	Filter  string    `json:"filter" example:"author=Robert+Martin"`
+	Books   []Book    `json:"books"`
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/validation"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Bounds of a cart
+const (
+	MaxCartQuantity = 99
+	MaxCartItems    = 100
+)
+
+// Cart is the books a user intends to buy. Only book ids and quantities are
+// stored; prices are looked up whenever the cart is read.
+type Cart struct {
+	Owner string     `bson:"_id"`
+	Items []CartItem `bson:"items"`
+	// Version is incremented on each save, zero for a user without a cart
+	Version   int       `bson:"version"`
+	UpdatedAt time.Time `bson:"updated_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// CartItem is a quantity of a book in a cart
+type CartItem struct {
+	BookID   primitive.ObjectID `bson:"book_id"`
+	Quantity int                `bson:"quantity"`
+}
+
+// CartAddition puts copies of a book in the cart, on top of those already
+// there
+// @Description Book to put in the cart and how many copies
+type CartAddition struct {
+	BookID   string `json:"book_id" example:"6790f0c2a1b2c3d4e5f60719"`
+	Quantity int    `json:"quantity" example:"2"`
+}
+
+func (addition *CartAddition) Validate() validation.Errors {
+	errs := validation.Errors{}
+
+	if errs.Required("book_id", addition.BookID) && !primitive.IsValidObjectID(addition.BookID) {
+		errs.Add("book_id", validation.RuleFormat, "book_id must be a book id")
+	}
+
+	errs.Range("quantity", addition.Quantity, 1, MaxCartQuantity)
+
+	return errs
+}
+
+// CartQuantity sets the quantity of a line of the cart
+// @Description New number of copies of the book
+type CartQuantity struct {
+	Quantity int `json:"quantity" example:"3"`
+}
+
+func (quantity *CartQuantity) Validate() validation.Errors {
+	errs := validation.Errors{}
+	errs.Range("quantity", quantity.Quantity, 1, MaxCartQuantity)
+
+	return errs
+}
+
+// Find returns the index of the line of the book, -1 when absent
+func (cart *Cart) Find(bookId primitive.ObjectID) int {
+	for i, item := range cart.Items {
+		if item.BookID == bookId {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// CartLine is a line of the cart priced at the current book price
+// @Description Book, quantity and price of a cart line. Books no longer in the catalog are unavailable and left out of the total.
+type CartLine struct {
+	BookID    string `json:"book_id" example:"6790f0c2a1b2c3d4e5f60719"`
+	Title     string `json:"title,omitempty" example:"The Go Programming Language"`
+	Author    string `json:"author,omitempty" example:"Alan Donovan"`
+	Isbn      string `json:"isbn,omitempty" example:"978-0-13-419044-0"`
+	Quantity  int    `json:"quantity" example:"2"`
+	UnitPrice int    `json:"unit_price" example:"2999"`
+	LineTotal int    `json:"line_total" example:"5998"`
+	Available bool   `json:"available" example:"true"`
+}
+
+// PricedCart is a cart as shown to its owner, priced server side
+// @Description Cart lines priced at the current book prices, in minor units
+type PricedCart struct {
+	Owner     string     `json:"owner" example:"reader"`
+	Lines     []CartLine `json:"lines"`
+	ItemCount int        `json:"item_count" example:"2"`
+	Subtotal  int        `json:"subtotal" example:"5998"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" example:"2025-01-08T12:00:00Z"`
+}
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Columns of the CSV import and export, in order
+var BookCSVColumns = []string{"title", "author", "isbn", "published_year", "price", "category"}
+
+// Outcome of an imported row
+const (
+	ImportAccepted = "accepted"
+	ImportRejected = "rejected"
+)
+
+// What an accepted ONIX product did to the catalog
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+)
+
+// States of a background import
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// ImportRow is the outcome of one line of an import file
+// @Description Line of the upload and whether it became a book
+type ImportRow struct {
+	Line   int    `json:"line" bson:"line" example:"2"`
+	Status string `json:"status" bson:"status" example:"rejected"`
+	ID     string `json:"id,omitempty" bson:"id,omitempty" example:"6790f0c2a1b2c3d4e5f60718"`
+	Action string `json:"action,omitempty" bson:"action,omitempty" example:"created"`
+	Error  string `json:"error,omitempty" bson:"error,omitempty" example:"all fields (title, author, price) are required"`
+}
+
+// ImportReport sums up an import
+// @Description Number of accepted and rejected rows with the outcome of each
+type ImportReport struct {
+	Total    int         `json:"total" bson:"total" example:"3"`
+	Accepted int         `json:"accepted" bson:"accepted" example:"2"`
+	Rejected int         `json:"rejected" bson:"rejected" example:"1"`
+	Rows     []ImportRow `json:"rows" bson:"rows"`
+}
+
+// ImportJob is an import running in the background
+// @Description State of a background import, with its report once done
+type ImportJob struct {
+	ID         string        `json:"id" bson:"_id" example:"6790f0c2a1b2c3d4e5f60718"`
+	Status     string        `json:"status" bson:"status" example:"done"`
+	Format     string        `json:"format" bson:"format" example:"csv"`
+	Actor      string        `json:"actor" bson:"actor" example:"admin"`
+	CreatedAt  time.Time     `json:"created_at" bson:"created_at" example:"2025-01-01T12:00:00Z"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty" bson:"finished_at,omitempty" example:"2025-01-01T12:00:05Z"`
+	Error      string        `json:"error,omitempty" bson:"error,omitempty"`
+	Report     *ImportReport `json:"report,omitempty" bson:"report,omitempty"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "github.com/BULLKNIGHT/bookstore/validation"
+
+// StockChange is a number of copies received or sold, at a location or
+// from the unassigned stock when LocationID is empty
+// @Description Number of copies to add to or take from the stock, optionally at a location
+type StockChange struct {
+	Quantity   int    `json:"quantity" example:"3"`
+	LocationID string `json:"location_id,omitempty" example:"6790f0c2a1b2c3d4e5f60720"`
+}
+
+func (change *StockChange) Validate() validation.Errors {
+	errs := validation.Errors{}
+	errs.Range("quantity", change.Quantity, 1, MaxStock)
+
+	return errs
+}
+
+// ThresholdChange sets the available stock at or below which a book is
+// reported low on stock
+// @Description New reorder threshold of the book
+type ThresholdChange struct {
+	ReorderThreshold int `json:"reorder_threshold" example:"5"`
+}
+
+func (change *ThresholdChange) Validate() validation.Errors {
+	errs := validation.Errors{}
+	errs.Range("reorder_threshold", change.ReorderThreshold, 0, MaxStock)
+
+	return errs
+}
+
+// StockLevel is the stock of a book at the time of the report
+// @Description Stock of a book at or below its reorder threshold
+type StockLevel struct {
+	ID               string `json:"id" example:"6790f0c2a1b2c3d4e5f60718"`
+	Title            string `json:"title" example:"The Go Programming Language"`
+	Isbn             string `json:"isbn" example:"978-0-13-419044-0"`
+	Stock            int    `json:"stock" example:"3"`
+	Reserved         int    `json:"reserved" example:"1"`
+	Available        int    `json:"available" example:"2"`
+	ReorderThreshold int    `json:"reorder_threshold" example:"5"`
+}
+
+// NewStockLevel reports the stock of the book
+func NewStockLevel(book Book) StockLevel {
+	return StockLevel{
+		ID:               book.ID.Hex(),
+		Title:            book.Title,
+		Isbn:             book.Isbn,
+		Stock:            book.Stock,
+		Reserved:         book.Reserved,
+		Available:        book.Available(),
+		ReorderThreshold: book.ReorderThreshold,
+	}
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/validation"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of location stock is kept at
+const (
+	LocationWarehouse = "warehouse"
+	LocationStore     = "store"
+)
+
+var LocationKinds = []string{LocationWarehouse, LocationStore}
+
+const (
+	MaxLocationCodeLength = 32
+	MaxLocationNameLength = 100
+)
+
+// Location is a warehouse or shop holding stock
+// @Description A warehouse or store, identified by a unique code
+type Location struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id" swaggertype:"string" example:"6790f0c2a1b2c3d4e5f60720"`
+	Code      string             `json:"code" bson:"code" example:"WH-EAST"`
+	Name      string             `json:"name" bson:"name" example:"East warehouse"`
+	Kind      string             `json:"kind" bson:"kind" example:"warehouse"`
+	Address   string             `json:"address,omitempty" bson:"address,omitempty" example:"12 Dock Road, Leeds"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at" example:"2025-01-01T12:00:00Z"`
+}
+
+func (location *Location) Validate() validation.Errors {
+	errs := validation.Errors{}
+
+	if errs.Required("code", location.Code) {
+		errs.Length("code", location.Code, 1, MaxLocationCodeLength)
+	}
+
+	if errs.Required("name", location.Name) {
+		errs.Length("name", location.Name, 1, MaxLocationNameLength)
+	}
+
+	if errs.Required("kind", location.Kind) && !slices.Contains(LocationKinds, location.Kind) {
+		errs.Add("kind", validation.RuleFormat, "kind must be warehouse or store")
+	}
+
+	return errs
+}
+
+// Kinds of stock movement
+const (
+	MovementReceive  = "receive"
+	MovementSell     = "sell"
+	MovementTransfer = "transfer"
+	MovementShip     = "ship"
+)
+
+// StockMovement records copies of a book entering, leaving or moving between
+// locations. An empty From or To is outside any location: a delivery, a
+// sale or the unassigned stock.
+// @Description Copies of a book received, sold or transferred between locations
+type StockMovement struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id" swaggertype:"string" example:"6790f0c2a1b2c3d4e5f60721"`
+	BookID    primitive.ObjectID `json:"book_id" bson:"book_id" swaggertype:"string" example:"6790f0c2a1b2c3d4e5f60719"`
+	Kind      string             `json:"kind" bson:"kind" example:"transfer"`
+	From      string             `json:"from,omitempty" bson:"from,omitempty" example:"6790f0c2a1b2c3d4e5f60720"`
+	To        string             `json:"to,omitempty" bson:"to,omitempty" example:"6790f0c2a1b2c3d4e5f60722"`
+	Quantity  int                `json:"quantity" bson:"quantity" example:"3"`
+	Actor     string             `json:"actor" bson:"actor" example:"admin"`
+	RequestID string             `json:"request_id" bson:"request_id" example:"4f9c2d7e8a1b3c5d6e7f8a9b0c1d2e3f"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp" example:"2025-01-01T12:00:00Z"`
+}
+
+// StockTransfer moves copies of a book from one location to another. An
+// empty From or To is the unassigned stock.
+// @Description Copies of a book to move between two locations
+type StockTransfer struct {
+	BookID   string `json:"book_id" example:"6790f0c2a1b2c3d4e5f60719"`
+	From     string `json:"from,omitempty" example:"6790f0c2a1b2c3d4e5f60720"`
+	To       string `json:"to,omitempty" example:"6790f0c2a1b2c3d4e5f60722"`
+	Quantity int    `json:"quantity" example:"3"`
+}
+
+func (transfer *StockTransfer) Validate() validation.Errors {
+	errs := validation.Errors{}
+	if errs.Required("book_id", transfer.BookID) && !primitive.IsValidObjectID(transfer.BookID) {
+		errs.Add("book_id", validation.RuleFormat, "book_id must be a book id")
+	}
+
+	// an empty side is the unassigned stock
+	if strings.EqualFold(transfer.From, transfer.To) {
+		errs.Add("to", validation.RuleFormat, "to must differ from from")
+	}
+
+	errs.Range("quantity", transfer.Quantity, 1, MaxStock)
+
+	return errs
+}
+
+// LocationStock is the stock of a book at one location
+// @Description Copies of the book held at a location
+type LocationStock struct {
+	LocationID string `json:"location_id" example:"6790f0c2a1b2c3d4e5f60720"`
+	Code       string `json:"code" example:"WH-EAST"`
+	Name       string `json:"name" example:"East warehouse"`
+	Kind       string `json:"kind" example:"warehouse"`
+	Quantity   int    `json:"quantity" example:"8"`
+}
+
+// Availability is the stock of a book broken down by location
+// @Description Stock of a book in total and per location
+type Availability struct {
+	BookID    string `json:"book_id" example:"6790f0c2a1b2c3d4e5f60719"`
+	Stock     int    `json:"stock" example:"12"`
+	Reserved  int    `json:"reserved" example:"2"`
+	Available int    `json:"available" example:"10"`
+	// Stock received without a location
+	Unassigned int             `json:"unassigned" example:"4"`
+	Locations  []LocationStock `json:"locations"`
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/validation"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// States of an order
+const (
+	OrderPending   = "pending"
+	OrderPaid      = "paid"
+	OrderShipped   = "shipped"
+	OrderDelivered = "delivered"
+	OrderCancelled = "cancelled"
+	OrderRefunded  = "refunded"
+)
+
+var OrderStatuses = []string{OrderPending, OrderPaid, OrderShipped, OrderDelivered, OrderCancelled, OrderRefunded}
+
+// Who may move an order: the customer who placed it or an admin
+const (
+	ActorOwner = "owner"
+	ActorAdmin = "admin"
+)
+
+// What a transition does to the copies reserved by the order
+const (
+	// StockKeep leaves the reservation as it is
+	StockKeep = ""
+	// StockRelease gives the reserved copies back to the available stock
+	StockRelease = "release"
+	// StockCommit takes the reserved copies out of the stock, they left
+	StockCommit = "commit"
+)
+
+// OrderTransition is an allowed move between two states
+type OrderTransition struct {
+	Actors []string
+	Stock  string
+}
+
+// Allows reports whether the actor may make the transition
+func (transition OrderTransition) Allows(actor string) bool {
+	return slices.Contains(transition.Actors, actor)
+}
+
+// OrderTransitions is the order state machine, by current and next state.
+// Cancelled and refunded orders are final.
+var OrderTransitions = map[string]map[string]OrderTransition{
+	OrderPending: {
+		OrderPaid:      {Actors: []string{ActorAdmin}},
+		OrderCancelled: {Actors: []string{ActorOwner, ActorAdmin}, Stock: StockRelease},
+	},
+	OrderPaid: {
+		OrderShipped:  {Actors: []string{ActorAdmin}, Stock: StockCommit},
+		OrderRefunded: {Actors: []string{ActorAdmin}, Stock: StockRelease},
+	},
+	OrderShipped: {
+		OrderDelivered: {Actors: []string{ActorAdmin}},
+	},
+	OrderDelivered: {
+		OrderRefunded: {Actors: []string{ActorAdmin}},
+	},
+}
+
+// OrderLine is a book of the order as it was at checkout
+// @Description Title and price of a book at checkout, kept even if the book changes later
+type OrderLine struct {
+	BookID    primitive.ObjectID `json:"book_id" bson:"book_id" swaggertype:"string" example:"6790f0c2a1b2c3d4e5f60719"`
+	Title     string             `json:"title" bson:"title" example:"The Go Programming Language"`
+	Author    string             `json:"author" bson:"author" example:"Alan Donovan"`
+	Isbn      string             `json:"isbn,omitempty" bson:"isbn,omitempty" example:"978-0-13-419044-0"`
+	Quantity  int                `json:"quantity" bson:"quantity" example:"2"`
+	UnitPrice int                `json:"unit_price" bson:"unit_price" example:"2999"`
+	LineTotal int                `json:"line_total" bson:"line_total" example:"5998"`
+}
+
+// Provider calls a transition claims before making them
+const (
+	SettleCapture = "capture"
+	SettleRefund  = "refund"
+)
+
+// OrderPayment is the payment of the order at the provider
+// @Description Payment of the order: pending until the provider confirms it, then authorized, captured when the order is paid, voided or refunded when it is cancelled or refunded. Settling names a provider call claimed by a transition and not confirmed yet.
+type OrderPayment struct {
+	ID       string `json:"id" bson:"id" example:"pay_5f1c2a9b8e7d6c5b4a3f2e1d"`
+	Status   string `json:"status" bson:"status" example:"authorized"`
+	Amount   int    `json:"amount" bson:"amount" example:"5998"`
+	Settling string `json:"settling,omitempty" bson:"settling,omitempty" example:"refund"`
+}
+
+// OrderMove is a transition as the repository applies it
+type OrderMove struct {
+	// From is the state the order must still be in
+	From  string
+	Event OrderEvent
+	Stock string
+	// LocationID is where committed copies leave from, the unassigned stock
+	// when empty
+	LocationID string
+	// Payment replaces the payment of the order when not nil
+	Payment *OrderPayment
+	// Settling is the provider call the order must have in flight, empty
+	// for none
+	Settling string
+}
+
+// OrderEvent is a state the order entered
+// @Description State entered by the order, who moved it there and when
+type OrderEvent struct {
+	Status string    `json:"status" bson:"status" example:"paid"`
+	Actor  string    `json:"actor" bson:"actor" example:"admin"`
+	At     time.Time `json:"at" bson:"at" example:"2025-01-01T12:00:00Z"`
+}
+
+// Order is a checked out cart
+// @Description Books bought by a user, their prices at checkout and the state of the order
+type Order struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id" swaggertype:"string" example:"6790f0c2a1b2c3d4e5f60730"`
+	Owner     string             `json:"owner" bson:"owner" example:"reader"`
+	Status    string             `json:"status" bson:"status" example:"pending"`
+	Lines     []OrderLine        `json:"lines" bson:"lines"`
+	ItemCount int                `json:"item_count" bson:"item_count" example:"2"`
+	Total     int                `json:"total" bson:"total" example:"5998"`
+	Payment   *OrderPayment      `json:"payment,omitempty" bson:"payment,omitempty"`
+	History   []OrderEvent       `json:"history" bson:"history"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at" example:"2025-01-01T12:00:00Z"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at" example:"2025-01-01T12:00:00Z"`
+}
+
+// PaymentSettling returns the provider call in flight for the order, empty
+// when there is none
+func (order *Order) PaymentSettling() string {
+	if order.Payment == nil {
+		return ""
+	}
+
+	return order.Payment.Settling
+}
+
+// CheckoutRequest pays for the cart
+// @Description Payment method charged for the order. The fake gateway approves fake_approve, declines fake_decline, and confirms fake_delayed or declines fake_delayed_decline after a while.
+type CheckoutRequest struct {
+	PaymentMethod string `json:"payment_method" example:"fake_approve"`
+}
+
+func (checkout *CheckoutRequest) Validate() validation.Errors {
+	errs := validation.Errors{}
+	errs.Required("payment_method", checkout.PaymentMethod)
+	return errs
+}
+
+// OrderTransitionRequest moves an order to another state
+// @Description Next state of the order. Shipping may name the location the copies leave from.
+type OrderTransitionRequest struct {
+	Status     string `json:"status" example:"shipped"`
+	LocationID string `json:"location_id,omitempty" example:"6790f0c2a1b2c3d4e5f60720"`
+}
+
+func (request *OrderTransitionRequest) Validate() validation.Errors {
+	errs := validation.Errors{}
+
+	if errs.Required("status", request.Status) && !slices.Contains(OrderStatuses, request.Status) {
+		errs.Add("status", validation.RuleFormat, "status must be one of pending, paid, shipped, delivered, cancelled, refunded")
+	}
+
+	if request.LocationID != "" && request.Status != OrderShipped {
+		errs.Add("location_id", validation.RuleFormat, "location_id only applies when shipping")
+	}
+
+	return errs
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BookRevision is the full state of a book after one of its writes
+// @Description A version of a book, who produced it and what changed since the previous one
+type BookRevision struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id"`
+	BookID    primitive.ObjectID `json:"book_id" bson:"book_id" swaggertype:"string" example:"6790f0c2a1b2c3d4e5f60719"`
+	Version   int                `json:"version" bson:"version" example:"3"`
+	Action    string             `json:"action" bson:"action" example:"update"`
+	Actor     string             `json:"actor" bson:"actor" example:"admin"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp" example:"2025-01-01T12:00:00Z"`
+	Book      Book               `json:"book" bson:"book"`
+	// Changes against the previous revision, computed when read
+	Changes []FieldChange `json:"changes" bson:"-"`
+}
+
+// RollbackRequest selects the revision to restore
+// @Description Version of the book to roll back to
+type RollbackRequest struct {
+	Version int `json:"version" example:"2"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// SearchResult is a book matching a catalog search
+// @Description Book with its relevance score and highlighted matches (matched words wrapped in <mark>)
+type SearchResult struct {
+	Book       Book              `json:"book"`
+	Score      float64           `json:"score" example:"3.42"`
+	Highlights map[string]string `json:"highlights"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/validation"
+)
+
+// TokenPair is returned on login and refresh
+// @Description Short-lived access token and the rotating refresh token used to renew it
+type TokenPair struct {
+	AccessToken  string `json:"access_token" example:"eyJhbGciOiJSUzUxMiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string `json:"refresh_token" example:"q3Jm0b7y8W1c2v9T0x4Lk5uE6r7t8y9U0i1o2p3a4s5"`
+	TokenType    string `json:"token_type" example:"Bearer"`
+	ExpiresIn    int64  `json:"expires_in" example:"900"`
+}
+
+// RefreshRequest carries a refresh token to rotate or revoke
+// @Description Refresh token issued by /token or /token/refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" example:"q3Jm0b7y8W1c2v9T0x4Lk5uE6r7t8y9U0i1o2p3a4s5"`
+}
+
+// Validate reports a missing refresh token. Logging out may leave it out.
+func (request *RefreshRequest) Validate(required bool) validation.Errors {
+	errs := validation.Errors{}
+
+	if required {
+		errs.Required("refresh_token", request.RefreshToken)
+	}
+
+	return errs
+}
+
+// RefreshToken is the server-side record of an issued refresh token. Only the
+// SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	Hash      string     `bson:"_id"`
+	Username  string     `bson:"username"`
+	Family    string     `bson:"family"`
+	ExpiresAt time.Time  `bson:"expires_at"`
+	UsedAt    *time.Time `bson:"used_at,omitempty"`
+}
+
+// RevokedToken marks an access token (by jti) as no longer valid.
+type RevokedToken struct {
+	ID        string    `bson:"_id"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// SigningKey is a JWT signing key kept in the store, so that every instance
+// signs and verifies with the same keys and they survive restarts
+type SigningKey struct {
+	// ID is the kid, the JWK thumbprint of the public key
+	ID string `bson:"_id"`
+	// SealedKey is the private key encrypted with the key-encryption key of
+	// the instances, empty once the key is pruned so its kid is never taken
+	// again. The database never sees the key in the clear.
+	SealedKey string `bson:"sealed_key"`
+	// Previous is the kid of the key this one replaces. Each key is replaced
+	// at most once, so instances rotating together end up with one key.
+	Previous string `bson:"previous"`
+	// ActiveAt is when instances start signing with the key, late enough for
+	// every instance to accept its tokens by then
+	ActiveAt time.Time  `bson:"active_at"`
+	PrunedAt *time.Time `bson:"pruned_at,omitempty"`
+}
@@ -1,12 +1,66 @@
 package models
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/validation"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+	MaxNameLength     = 64
+)
+
 // User represents a user in the system
-// @Description User information for authentication and authorization
+// @Description User account stored server-side; the role is never taken from client input
 type User struct {
-	Name string `json:"name" example:"john_doe"`
-	Role string `json:"role" example:"guest"`
+	ID           primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty" swaggerignore:"true"`
+	Name         string             `json:"name" bson:"name" example:"john_doe"`
+	Role         string             `json:"role" bson:"role" example:"user"`
+	PasswordHash string             `json:"-" bson:"password_hash"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at" swaggerignore:"true"`
 }
 
-func (user *User) IsValid() bool {
-	return user.Name != "" && user.Role != ""
+// Credentials represents the login/registration payload
+// @Description User name and password used to register or obtain a token
+type Credentials struct {
+	Name     string `json:"name" example:"john_doe"`
+	Password string `json:"password" example:"correct-horse-battery"`
+}
+
+func (credentials *Credentials) IsValid() bool {
+	return credentials.Name != "" && credentials.Password != ""
+}
+
+// Validate reports missing fields. The lengths only apply to new accounts so
+// existing ones can still log in.
+func (credentials *Credentials) Validate(newAccount bool) validation.Errors {
+	errs := validation.Errors{}
+	nameSet := errs.Required("name", credentials.Name)
+	passwordSet := errs.Required("password", credentials.Password)
+
+	if !newAccount {
+		return errs
+	}
+
+	if nameSet {
+		errs.Length("name", credentials.Name, 1, MaxNameLength)
+	}
+
+	if passwordSet {
+		errs.Length("password", credentials.Password, MinPasswordLength, MaxPasswordLength)
+	}
+
+	// bcrypt counts bytes, not characters
+	if len(credentials.Password) > MaxPasswordLength && len([]rune(credentials.Password)) <= MaxPasswordLength {
+		errs.Add("password", validation.RuleMaxLength, fmt.Sprintf("password must be at most %d bytes long", MaxPasswordLength))
+	}
+
+	return errs
 }
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCredentialsPasswordLength(t *testing.T) {
+	short := Credentials{Name: "bob", Password: strings.Repeat("x", MinPasswordLength-1)}
+	errs := short.Validate(true)
+	want := fmt.Sprintf("password must be at least %d characters long", MinPasswordLength)
+
+	if len(errs) != 1 || errs[0].Field != "password" || errs[0].Message != want {
+		t.Fatalf("expected %q, got %+v", want, errs)
+	}
+
+	long := Credentials{Name: "bob", Password: strings.Repeat("x", MinPasswordLength)}
+
+	if errs := long.Validate(true); len(errs) != 0 {
+		t.Fatalf("a password of %d characters was refused: %+v", MinPasswordLength, errs)
+	}
+
+	// existing accounts log in whatever the current rules
+	if errs := short.Validate(false); len(errs) != 0 {
+		t.Fatalf("login checked the password length: %+v", errs)
+	}
+}
@@ -0,0 +1,260 @@
+package onix
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+)
+
+var ErrNoISBN = errors.New("product has no ISBN")
+
+func digits(value string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(value)))
+}
+
+// ISBN returns the ISBN of the product without hyphens, preferring ISBN-13
+func (product Product) ISBN() string {
+	isbn := ""
+
+	for _, identifier := range product.ProductIdentifiers {
+		value := digits(identifier.IDValue)
+
+		switch {
+		case identifier.ProductIDType == IDTypeISBN13:
+			return value
+		case identifier.ProductIDType == IDTypeGTIN13 && (strings.HasPrefix(value, "978") || strings.HasPrefix(value, "979")):
+			return value
+		case identifier.ProductIDType == IDTypeISBN10 && isbn == "":
+			isbn = value
+		}
+	}
+
+	return isbn
+}
+
+func (product Product) title() string {
+	for _, detail := range product.DescriptiveDetail.TitleDetails {
+		if detail.TitleType != TitleTypeDistinctive {
+			continue
+		}
+
+		for _, element := range detail.TitleElements {
+			if element.TitleElementLevel != TitleLevelProduct {
+				continue
+			}
+
+			if element.TitleText != "" {
+				return strings.TrimSpace(element.TitleText)
+			}
+
+			return strings.TrimSpace(element.TitlePrefix + " " + element.TitleWithoutPrefix)
+		}
+	}
+
+	return ""
+}
+
+func (contributor Contributor) name() string {
+	switch {
+	case contributor.PersonName != "":
+		return contributor.PersonName
+	case contributor.KeyNames != "":
+		return strings.TrimSpace(contributor.NamesBeforeKey + " " + contributor.KeyNames)
+	case contributor.PersonNameInverted != "":
+		return contributor.PersonNameInverted
+	}
+
+	return contributor.CorporateName
+}
+
+// The authors in sequence order, or every contributor if none is an author
+func (product Product) author() string {
+	contributors := slices.Clone(product.DescriptiveDetail.Contributors)
+	slices.SortStableFunc(contributors, func(a, b Contributor) int {
+		return cmp.Compare(a.SequenceNumber, b.SequenceNumber)
+	})
+
+	authors := []string{}
+	others := []string{}
+
+	for _, contributor := range contributors {
+		name := strings.TrimSpace(contributor.name())
+
+		if name == "" {
+			continue
+		}
+
+		if slices.Contains(contributor.ContributorRoles, RoleAuthor) {
+			authors = append(authors, name)
+		} else {
+			others = append(others, name)
+		}
+	}
+
+	if len(authors) == 0 {
+		authors = others
+	}
+
+	return strings.Join(authors, ", ")
+}
+
+// The main subject, or the first one, by heading or else by code
+func (product Product) category() string {
+	subjects := product.DescriptiveDetail.Subjects
+
+	if len(subjects) == 0 {
+		return ""
+	}
+
+	subject := subjects[0]
+
+	for _, candidate := range subjects {
+		if candidate.MainSubject != nil {
+			subject = candidate
+			break
+		}
+	}
+
+	if subject.SubjectHeadingText != "" {
+		return strings.TrimSpace(subject.SubjectHeadingText)
+	}
+
+	return strings.TrimSpace(subject.SubjectCode)
+}
+
+func (product Product) publishedYear() (int, error) {
+	if product.PublishingDetail == nil {
+		return 0, nil
+	}
+
+	for _, date := range product.PublishingDetail.PublishingDates {
+		value := strings.TrimSpace(date.Date.Value)
+
+		if date.PublishingDateRole != DateRolePublication || len(value) < 4 {
+			continue
+		}
+
+		year, err := strconv.Atoi(value[:4])
+
+		if err != nil {
+			return 0, fmt.Errorf("invalid publication date %q", value)
+		}
+
+		return year, nil
+	}
+
+	return 0, nil
+}
+
+// The first price in the currency, or the first price at all when currency
+// is empty, in cents
+func (product Product) price(currency string) (int, error) {
+	for _, supply := range product.ProductSupply {
+		for _, detail := range supply.SupplyDetails {
+			for _, price := range detail.Prices {
+				if currency != "" && !strings.EqualFold(price.CurrencyCode, currency) {
+					continue
+				}
+
+				amount, err := strconv.ParseFloat(strings.TrimSpace(price.PriceAmount), 64)
+
+				if err != nil || amount < 0 {
+					return 0, fmt.Errorf("invalid price amount %q", price.PriceAmount)
+				}
+
+				return int(math.Round(amount * 100)), nil
+			}
+		}
+	}
+
+	return 0, nil
+}
+
+// Book maps the product to a book. Fields the product does not carry are
+// left zero so an update can keep the stored values.
+func (product Product) Book(currency string) (models.Book, error) {
+	book := models.Book{
+		Title:    product.title(),
+		Author:   product.author(),
+		Isbn:     product.ISBN(),
+		Category: product.category(),
+	}
+
+	if book.Isbn == "" {
+		return book, ErrNoISBN
+	}
+
+	var err error
+
+	if book.PublishedYear, err = product.publishedYear(); err != nil {
+		return book, err
+	}
+
+	if book.Price, err = product.price(currency); err != nil {
+		return book, err
+	}
+
+	return book, nil
+}
+
+// FromBook maps a book to a product record priced in the currency and
+// supplied by supplier
+func FromBook(book models.Book, currency string, supplier string) Product {
+	product := Product{
+		RecordReference:  book.ID.Hex(),
+		NotificationType: NotificationConfirmed,
+		ProductIdentifiers: []ProductIdentifier{
+			{ProductIDType: IDTypeProprietary, IDValue: book.ID.Hex()},
+		},
+		DescriptiveDetail: DescriptiveDetail{
+			ProductComposition: CompositionSingleItem,
+			ProductForm:        FormBook,
+			TitleDetails: []TitleDetail{{
+				TitleType:     TitleTypeDistinctive,
+				TitleElements: []TitleElement{{TitleElementLevel: TitleLevelProduct, TitleText: book.Title}},
+			}},
+		},
+	}
+
+	if isbn := digits(book.Isbn); len(isbn) == 13 {
+		product.ProductIdentifiers = append(product.ProductIdentifiers, ProductIdentifier{ProductIDType: IDTypeISBN13, IDValue: isbn})
+	} else if len(isbn) == 10 {
+		product.ProductIdentifiers = append(product.ProductIdentifiers, ProductIdentifier{ProductIDType: IDTypeISBN10, IDValue: isbn})
+	}
+
+	if book.Author != "" {
+		product.DescriptiveDetail.Contributors = []Contributor{
+			{SequenceNumber: 1, ContributorRoles: []string{RoleAuthor}, PersonName: book.Author},
+		}
+	}
+
+	if book.Category != "" {
+		product.DescriptiveDetail.Subjects = []Subject{
+			{MainSubject: &struct{}{}, SubjectSchemeIdentifier: SchemeKeywords, SubjectHeadingText: book.Category},
+		}
+	}
+
+	if book.PublishedYear > 0 {
+		product.PublishingDetail = &PublishingDetail{PublishingDates: []PublishingDate{
+			{PublishingDateRole: DateRolePublication, Date: Date{Format: DateFormatYear, Value: strconv.Itoa(book.PublishedYear)}},
+		}}
+	}
+
+	product.ProductSupply = []ProductSupply{{SupplyDetails: []SupplyDetail{{
+		Supplier:            Supplier{SupplierRole: SupplierRolePublisher, SupplierName: supplier},
+		ProductAvailability: AvailabilityAvailable,
+		Prices: []Price{{
+			PriceType:    PriceTypeRRP,
+			PriceAmount:  fmt.Sprintf("%d.%02d", book.Price/100, book.Price%100),
+			CurrencyCode: currency,
+		}},
+	}}}}
+
+	return product
+}
@@ -0,0 +1,270 @@
+// Package onix reads and writes ONIX 3.0 product records with reference tags,
+// the book trade's metadata exchange format. Only the composites mapped to a
+// book are modelled; everything else is skipped when reading.
+package onix
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	Release   = "3.0"
+	Namespace = "http://ns.editeur.org/onix/3.0/reference"
+	MediaType = "application/xml"
+)
+
+// Values of the ONIX code lists used here
+const (
+	NotificationConfirmed = "03" // list 1
+	NotificationDelete    = "05"
+
+	IDTypeProprietary = "01" // list 5
+	IDTypeISBN10      = "02"
+	IDTypeGTIN13      = "03"
+	IDTypeISBN13      = "15"
+
+	CompositionSingleItem = "00"  // list 2
+	FormBook              = "BA"  // list 150
+	TitleTypeDistinctive  = "01"  // list 15
+	TitleLevelProduct     = "01"  // list 149
+	RoleAuthor            = "A01" // list 17
+	SchemeKeywords        = "20"  // list 26
+	DateRolePublication   = "01"  // list 163
+	DateFormatYear        = "05"  // list 55
+	SupplierRolePublisher = "01"  // list 93
+	AvailabilityAvailable = "20"  // list 65
+	PriceTypeRRP          = "01"  // list 58
+)
+
+var ErrShortTags = errors.New("ONIX short tags are not supported, send reference tags")
+var ErrUnsupportedRelease = errors.New("only ONIX 3.0 is supported")
+
+type Header struct {
+	Sender       Sender `xml:"Sender"`
+	SentDateTime string `xml:"SentDateTime"`
+}
+
+type Sender struct {
+	SenderName string `xml:"SenderName"`
+}
+
+// Product is an ONIX product record
+type Product struct {
+	RecordReference    string              `xml:"RecordReference"`
+	NotificationType   string              `xml:"NotificationType"`
+	ProductIdentifiers []ProductIdentifier `xml:"ProductIdentifier"`
+	DescriptiveDetail  DescriptiveDetail   `xml:"DescriptiveDetail"`
+	PublishingDetail   *PublishingDetail   `xml:"PublishingDetail"`
+	ProductSupply      []ProductSupply     `xml:"ProductSupply"`
+	// Line of the record in the decoded message
+	Line int `xml:"-"`
+}
+
+type ProductIdentifier struct {
+	ProductIDType string `xml:"ProductIDType"`
+	IDValue       string `xml:"IDValue"`
+}
+
+type DescriptiveDetail struct {
+	ProductComposition string        `xml:"ProductComposition"`
+	ProductForm        string        `xml:"ProductForm"`
+	TitleDetails       []TitleDetail `xml:"TitleDetail"`
+	Contributors       []Contributor `xml:"Contributor"`
+	Subjects           []Subject     `xml:"Subject"`
+}
+
+type TitleDetail struct {
+	TitleType     string         `xml:"TitleType"`
+	TitleElements []TitleElement `xml:"TitleElement"`
+}
+
+type TitleElement struct {
+	TitleElementLevel  string `xml:"TitleElementLevel"`
+	TitlePrefix        string `xml:"TitlePrefix,omitempty"`
+	TitleWithoutPrefix string `xml:"TitleWithoutPrefix,omitempty"`
+	TitleText          string `xml:"TitleText,omitempty"`
+	Subtitle           string `xml:"Subtitle,omitempty"`
+}
+
+type Contributor struct {
+	SequenceNumber     int      `xml:"SequenceNumber,omitempty"`
+	ContributorRoles   []string `xml:"ContributorRole"`
+	PersonName         string   `xml:"PersonName,omitempty"`
+	PersonNameInverted string   `xml:"PersonNameInverted,omitempty"`
+	NamesBeforeKey     string   `xml:"NamesBeforeKey,omitempty"`
+	KeyNames           string   `xml:"KeyNames,omitempty"`
+	CorporateName      string   `xml:"CorporateName,omitempty"`
+}
+
+type Subject struct {
+	MainSubject             *struct{} `xml:"MainSubject"`
+	SubjectSchemeIdentifier string    `xml:"SubjectSchemeIdentifier"`
+	SubjectCode             string    `xml:"SubjectCode,omitempty"`
+	SubjectHeadingText      string    `xml:"SubjectHeadingText,omitempty"`
+}
+
+type PublishingDetail struct {
+	PublishingDates []PublishingDate `xml:"PublishingDate"`
+}
+
+type PublishingDate struct {
+	PublishingDateRole string `xml:"PublishingDateRole"`
+	Date               Date   `xml:"Date"`
+}
+
+// Date defaults to the YYYYMMDD format
+type Date struct {
+	Format string `xml:"dateformat,attr,omitempty"`
+	Value  string `xml:",chardata"`
+}
+
+type ProductSupply struct {
+	SupplyDetails []SupplyDetail `xml:"SupplyDetail"`
+}
+
+type SupplyDetail struct {
+	Supplier            Supplier `xml:"Supplier"`
+	ProductAvailability string   `xml:"ProductAvailability"`
+	Prices              []Price  `xml:"Price"`
+}
+
+type Supplier struct {
+	SupplierRole string `xml:"SupplierRole"`
+	SupplierName string `xml:"SupplierName"`
+}
+
+type Price struct {
+	PriceType    string `xml:"PriceType,omitempty"`
+	PriceAmount  string `xml:"PriceAmount"`
+	CurrencyCode string `xml:"CurrencyCode,omitempty"`
+}
+
+// Decode reads the products of an ONIX 3.0 message, recording the line each
+// one starts on
+func Decode(r io.Reader) ([]Product, error) {
+	decoder := xml.NewDecoder(r)
+	products := []Product{}
+	root := true
+
+	for {
+		token, err := decoder.Token()
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+
+		if !ok {
+			continue
+		}
+
+		if root {
+			if err := checkRoot(start); err != nil {
+				return nil, err
+			}
+
+			root = false
+			continue
+		}
+
+		if start.Name.Local != "Product" {
+			continue
+		}
+
+		line, _ := decoder.InputPos()
+		product := Product{Line: line}
+
+		if err := decoder.DecodeElement(&product, &start); err != nil {
+			return nil, err
+		}
+
+		products = append(products, product)
+	}
+
+	if root {
+		return nil, errors.New("empty ONIX message")
+	}
+
+	return products, nil
+}
+
+func checkRoot(start xml.StartElement) error {
+	if start.Name.Local == "ONIXmessage" {
+		return ErrShortTags
+	}
+
+	if start.Name.Local != "ONIXMessage" {
+		return fmt.Errorf("expected an ONIXMessage, got %s", start.Name.Local)
+	}
+
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "release" && !strings.HasPrefix(attr.Value, "3.") {
+			return ErrUnsupportedRelease
+		}
+	}
+
+	return nil
+}
+
+// Writer streams an ONIX 3.0 message one product at a time
+type Writer struct {
+	encoder *xml.Encoder
+}
+
+// NewWriter writes the opening of a message and its header
+func NewWriter(w io.Writer, sender string, sent time.Time) (*Writer, error) {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return nil, err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	root := xml.StartElement{
+		Name: xml.Name{Local: "ONIXMessage"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns"}, Value: Namespace},
+			{Name: xml.Name{Local: "release"}, Value: Release},
+		},
+	}
+
+	if err := encoder.EncodeToken(root); err != nil {
+		return nil, err
+	}
+
+	header := Header{Sender: Sender{SenderName: sender}, SentDateTime: sent.UTC().Format("20060102T1504Z")}
+
+	if err := encoder.EncodeElement(header, xml.StartElement{Name: xml.Name{Local: "Header"}}); err != nil {
+		return nil, err
+	}
+
+	return &Writer{encoder: encoder}, nil
+}
+
+func (writer *Writer) Write(product Product) error {
+	return writer.encoder.EncodeElement(product, xml.StartElement{Name: xml.Name{Local: "Product"}})
+}
+
+// Flush sends the products written so far
+func (writer *Writer) Flush() error {
+	return writer.encoder.Flush()
+}
+
+// Close ends the message
+func (writer *Writer) Close() error {
+	if err := writer.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "ONIXMessage"}}); err != nil {
+		return err
+	}
+
+	return writer.encoder.Flush()
+}
@@ -0,0 +1,181 @@
+package onix
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const message = `<?xml version="1.0" encoding="UTF-8"?>
+<ONIXMessage release="3.0" xmlns="http://ns.editeur.org/onix/3.0/reference">
+  <Header><Sender><SenderName>Distributor</SenderName></Sender><SentDateTime>20250101</SentDateTime></Header>
+  <Product>
+    <RecordReference>com.example.1</RecordReference>
+    <NotificationType>03</NotificationType>
+    <ProductIdentifier><ProductIDType>02</ProductIDType><IDValue>0134190440</IDValue></ProductIdentifier>
+    <ProductIdentifier><ProductIDType>15</ProductIDType><IDValue>978-0134190440</IDValue></ProductIdentifier>
+    <DescriptiveDetail>
+      <ProductComposition>00</ProductComposition>
+      <ProductForm>BC</ProductForm>
+      <TitleDetail>
+        <TitleType>01</TitleType>
+        <TitleElement>
+          <TitleElementLevel>01</TitleElementLevel>
+          <TitlePrefix>The</TitlePrefix>
+          <TitleWithoutPrefix>Go Programming Language</TitleWithoutPrefix>
+        </TitleElement>
+      </TitleDetail>
+      <Contributor>
+        <SequenceNumber>2</SequenceNumber>
+        <ContributorRole>A01</ContributorRole>
+        <NamesBeforeKey>Brian W.</NamesBeforeKey>
+        <KeyNames>Kernighan</KeyNames>
+      </Contributor>
+      <Contributor>
+        <SequenceNumber>1</SequenceNumber>
+        <ContributorRole>A01</ContributorRole>
+        <PersonName>Alan Donovan</PersonName>
+      </Contributor>
+      <Contributor>
+        <SequenceNumber>3</SequenceNumber>
+        <ContributorRole>B01</ContributorRole>
+        <PersonName>An Editor</PersonName>
+      </Contributor>
+      <Subject>
+        <SubjectSchemeIdentifier>93</SubjectSchemeIdentifier>
+        <SubjectCode>UMX</SubjectCode>
+      </Subject>
+      <Subject>
+        <MainSubject/>
+        <SubjectSchemeIdentifier>10</SubjectSchemeIdentifier>
+        <SubjectCode>COM051010</SubjectCode>
+        <SubjectHeadingText>Programming</SubjectHeadingText>
+      </Subject>
+    </DescriptiveDetail>
+    <PublishingDetail>
+      <PublishingDate><PublishingDateRole>01</PublishingDateRole><Date>20151026</Date></PublishingDate>
+    </PublishingDetail>
+    <ProductSupply>
+      <SupplyDetail>
+        <Supplier><SupplierRole>01</SupplierRole><SupplierName>Publisher</SupplierName></Supplier>
+        <ProductAvailability>20</ProductAvailability>
+        <Price><PriceType>01</PriceType><PriceAmount>39.99</PriceAmount><CurrencyCode>GBP</CurrencyCode></Price>
+        <Price><PriceType>01</PriceType><PriceAmount>44.99</PriceAmount><CurrencyCode>USD</CurrencyCode></Price>
+      </SupplyDetail>
+    </ProductSupply>
+  </Product>
+  <Product>
+    <RecordReference>com.example.2</RecordReference>
+    <NotificationType>03</NotificationType>
+    <ProductIdentifier><ProductIDType>01</ProductIDType><IDValue>X-1</IDValue></ProductIdentifier>
+  </Product>
+</ONIXMessage>`
+
+func TestDecode(t *testing.T) {
+	products, err := Decode(strings.NewReader(message))
+
+	if err != nil || len(products) != 2 {
+		t.Fatalf("got %d products, %v", len(products), err)
+	}
+
+	if products[0].Line != 4 || products[1].Line != 59 {
+		t.Fatalf("unexpected lines %d, %d", products[0].Line, products[1].Line)
+	}
+
+	book, err := products[0].Book("USD")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := models.Book{
+		Title:         "The Go Programming Language",
+		Author:        "Alan Donovan, Brian W. Kernighan",
+		Isbn:          "9780134190440",
+		PublishedYear: 2015,
+		Price:         4499,
+		Category:      "Programming",
+	}
+
+	if !reflect.DeepEqual(book, want) {
+		t.Fatalf("got %+v, want %+v", book, want)
+	}
+
+	if book, _ := products[0].Book(""); book.Price != 3999 {
+		t.Fatalf("expected the first price without a currency, got %d", book.Price)
+	}
+
+	if _, err := products[1].Book("USD"); !errors.Is(err, ErrNoISBN) {
+		t.Fatalf("expected ErrNoISBN, got %v", err)
+	}
+}
+
+func TestDecodeRejectsOtherMessages(t *testing.T) {
+	cases := map[string]error{
+		`<ONIXmessage release="3.0"><header/></ONIXmessage>`: ErrShortTags,
+		`<ONIXMessage release="2.1"><Header/></ONIXMessage>`: ErrUnsupportedRelease,
+	}
+
+	for document, want := range cases {
+		if _, err := Decode(strings.NewReader(document)); !errors.Is(err, want) {
+			t.Fatalf("%s: got %v, want %v", document, err, want)
+		}
+	}
+
+	if _, err := Decode(strings.NewReader(`<ONIXMessage><Product>`)); err == nil {
+		t.Fatal("expected a syntax error")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	book := models.Book{
+		ID:            primitive.NewObjectID(),
+		Title:         "Clean Code",
+		Author:        "Robert Martin",
+		Isbn:          "978-0132350884",
+		PublishedYear: 2008,
+		Price:         3505,
+		Category:      "Programming",
+	}
+
+	var buffer bytes.Buffer
+	writer, err := NewWriter(&buffer, "Bookstore", time.Date(2025, 1, 2, 3, 4, 0, 0, time.UTC))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Write(FromBook(book, "EUR", "Bookstore")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buffer.String(), `<ONIXMessage xmlns="http://ns.editeur.org/onix/3.0/reference" release="3.0">`) ||
+		!strings.Contains(buffer.String(), "<SentDateTime>20250102T0304Z</SentDateTime>") {
+		t.Fatalf("unexpected message:\n%s", buffer.String())
+	}
+
+	products, err := Decode(&buffer)
+
+	if err != nil || len(products) != 1 {
+		t.Fatalf("got %d products, %v", len(products), err)
+	}
+
+	got, err := products[0].Book("EUR")
+	want := book
+	want.ID = primitive.NilObjectID
+	want.Isbn = "9780132350884"
+
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v (%v), want %+v", got, err, want)
+	}
+}
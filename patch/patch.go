@@ -0,0 +1,358 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+var ErrInvalidPatch = errors.New("invalid patch document")
+
+// ErrTestFailed is returned when a JSON Patch "test" operation does not match
+var ErrTestFailed = errors.New("patch test operation failed")
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to a JSON document
+func MergePatch(document []byte, patch []byte) ([]byte, error) {
+	var target any
+	var changes any
+
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	return json.Marshal(mergeValue(target, changes))
+}
+
+func mergeValue(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+
+	// anything but an object replaces the target as a whole
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+
+		targetObject[key] = mergeValue(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// Operation is a single RFC 6902 JSON Patch operation
+type Operation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from,omitempty"`
+	Value *json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch applies an RFC 6902 JSON Patch to a JSON document. Operations are
+// applied in order and the document is left untouched if any of them fails.
+func JSONPatch(document []byte, patch []byte) ([]byte, error) {
+	var target any
+	var operations []Operation
+
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	for i, operation := range operations {
+		var err error
+
+		if target, err = apply(target, operation); err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, operation.Op, operation.Path, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func apply(target any, operation Operation) (any, error) {
+	path, err := parsePointer(operation.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch operation.Op {
+	case "add", "replace", "test":
+		if operation.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", ErrInvalidPatch)
+		}
+
+		var value any
+
+		if err := json.Unmarshal(*operation.Value, &value); err != nil {
+			return nil, ErrInvalidPatch
+		}
+
+		switch operation.Op {
+		case "add":
+			return add(target, path, value)
+		case "replace":
+			if _, err := get(target, path); err != nil {
+				return nil, err
+			}
+
+			if target, err = remove(target, path); err != nil {
+				return nil, err
+			}
+
+			return add(target, path, value)
+		default:
+			current, err := get(target, path)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !reflect.DeepEqual(current, value) {
+				return nil, ErrTestFailed
+			}
+
+			return target, nil
+		}
+	case "remove":
+		return remove(target, path)
+	case "move", "copy":
+		from, err := parsePointer(operation.From)
+
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := get(target, from)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if operation.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("%w: cannot move a value into itself", ErrInvalidPatch)
+			}
+
+			if target, err = remove(target, from); err != nil {
+				return nil, err
+			}
+		} else {
+			// deep copy so later operations do not alias the source
+			encoded, _ := json.Marshal(value)
+			json.Unmarshal(encoded, &value)
+		}
+
+		return add(target, path, value)
+	}
+
+	return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, operation.Op)
+}
+
+// Split an RFC 6901 JSON Pointer into unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func isPrefix(prefix []string, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+
+	index, err := strconv.Atoi(token)
+	limit := length - 1
+
+	if allowEnd {
+		limit = length
+	}
+
+	if err != nil || index < 0 || index > limit || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+
+	return index, nil
+}
+
+func get(target any, path []string) (any, error) {
+	current := target
+
+	for _, token := range path {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[token]
+
+			if !ok {
+				return nil, fmt.Errorf("%w: path not found", ErrInvalidPatch)
+			}
+
+			current = value
+		case []any:
+			index, err := arrayIndex(token, len(node), false)
+
+			if err != nil {
+				return nil, err
+			}
+
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("%w: path not found", ErrInvalidPatch)
+		}
+	}
+
+	return current, nil
+}
+
+// Rebuild the document with fn applied to the parent of the last token
+func update(target any, path []string, fn func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 0 {
+		return fn(nil, "")
+	}
+
+	if len(path) == 1 {
+		return fn(target, path[0])
+	}
+
+	switch node := target.(type) {
+	case map[string]any:
+		child, ok := node[path[0]]
+
+		if !ok {
+			return nil, fmt.Errorf("%w: path not found", ErrInvalidPatch)
+		}
+
+		updated, err := update(child, path[1:], fn)
+
+		if err != nil {
+			return nil, err
+		}
+
+		node[path[0]] = updated
+		return node, nil
+	case []any:
+		index, err := arrayIndex(path[0], len(node), false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		updated, err := update(node[index], path[1:], fn)
+
+		if err != nil {
+			return nil, err
+		}
+
+		node[index] = updated
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("%w: path not found", ErrInvalidPatch)
+}
+
+func add(target any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(target, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[token] = value
+			return node, nil
+		case []any:
+			index, err := arrayIndex(token, len(node), true)
+
+			if err != nil {
+				return nil, err
+			}
+
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+
+			return node, nil
+		}
+
+		return nil, fmt.Errorf("%w: path not found", ErrInvalidPatch)
+	})
+}
+
+func remove(target any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalidPatch)
+	}
+
+	return update(target, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("%w: path not found", ErrInvalidPatch)
+			}
+
+			delete(node, token)
+			return node, nil
+		case []any:
+			index, err := arrayIndex(token, len(node), false)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return append(node[:index], node[index+1:]...), nil
+		}
+
+		return nil, fmt.Errorf("%w: path not found", ErrInvalidPatch)
+	})
+}
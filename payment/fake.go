@@ -0,0 +1,194 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/logger"
+)
+
+// Payment methods understood by the fake gateway, each simulating an
+// outcome. Any other method is declined.
+const (
+	MethodApprove        = "fake_approve"
+	MethodDecline        = "fake_decline"
+	MethodDelayed        = "fake_delayed"
+	MethodDelayedDecline = "fake_delayed_decline"
+)
+
+// ProviderFake selects the fake gateway in PAYMENT_PROVIDER
+const ProviderFake = "fake"
+
+// DefaultConfirmDelay is how long the fake gateway takes to confirm a
+// delayed payment
+const DefaultConfirmDelay = 5 * time.Second
+
+type fakePayment struct {
+	reference string
+	method    string
+	status    string
+	amount    int
+	captured  int
+}
+
+// FakeGateway is an in-process PaymentProvider for development and tests.
+// Delayed payments stay pending until Confirm settles them, which happens
+// on its own after Delay when a WebhookURL is set, by posting the signed
+// event there like a real provider would.
+type FakeGateway struct {
+	Delay      time.Duration
+	WebhookURL string
+
+	mutex    sync.Mutex
+	secret   []byte
+	payments map[string]*fakePayment
+}
+
+// NewFakeGateway signs its webhooks with the secret. An empty secret
+// verifies no signature.
+func NewFakeGateway(secret string) *FakeGateway {
+	return &FakeGateway{Delay: DefaultConfirmDelay, secret: []byte(secret), payments: map[string]*fakePayment{}}
+}
+
+func newID(prefix string) string {
+	id := make([]byte, 12)
+	rand.Read(id)
+	return prefix + hex.EncodeToString(id)
+}
+
+func (gateway *FakeGateway) Authorize(request Request, ctx context.Context) (Result, error) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	id := newID("pay_")
+	payment := &fakePayment{reference: request.Reference, method: request.Method, amount: request.Amount}
+	gateway.payments[id] = payment
+
+	switch request.Method {
+	case MethodApprove:
+		payment.status = StatusAuthorized
+	case MethodDelayed, MethodDelayedDecline:
+		payment.status = StatusPending
+
+		if gateway.WebhookURL != "" {
+			time.AfterFunc(gateway.Delay, func() { gateway.deliver(id) })
+		}
+	default:
+		payment.status = StatusDeclined
+		return Result{ID: id, Status: payment.status}, ErrDeclined
+	}
+
+	return Result{ID: id, Status: payment.status}, nil
+}
+
+func (gateway *FakeGateway) Capture(paymentId string, amount int, ctx context.Context) (Result, error) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	payment, ok := gateway.payments[paymentId]
+
+	if !ok {
+		return Result{}, ErrUnknownPayment
+	}
+
+	if payment.status != StatusAuthorized || amount > payment.amount {
+		return Result{ID: paymentId, Status: payment.status}, ErrInvalidState
+	}
+
+	payment.status = StatusCaptured
+	payment.captured = amount
+	return Result{ID: paymentId, Status: payment.status}, nil
+}
+
+func (gateway *FakeGateway) Refund(paymentId string, amount int, ctx context.Context) (Result, error) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	payment, ok := gateway.payments[paymentId]
+
+	if !ok {
+		return Result{}, ErrUnknownPayment
+	}
+
+	switch {
+	case payment.status == StatusPending || payment.status == StatusAuthorized:
+		payment.status = StatusVoided
+	case payment.status == StatusCaptured && amount <= payment.captured:
+		payment.status = StatusRefunded
+	default:
+		return Result{ID: paymentId, Status: payment.status}, ErrInvalidState
+	}
+
+	return Result{ID: paymentId, Status: payment.status}, nil
+}
+
+func (gateway *FakeGateway) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	return Verify(payload, signature, gateway.secret)
+}
+
+// Confirm settles a pending payment now, authorized or declined depending
+// on its method, and returns the signed webhook event announcing it
+func (gateway *FakeGateway) Confirm(paymentId string) ([]byte, string, error) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	payment, ok := gateway.payments[paymentId]
+
+	if !ok {
+		return nil, "", ErrUnknownPayment
+	}
+
+	if payment.status != StatusPending {
+		return nil, "", ErrInvalidState
+	}
+
+	payment.status = StatusAuthorized
+
+	if payment.method == MethodDelayedDecline {
+		payment.status = StatusDeclined
+	}
+
+	event := Event{ID: newID("evt_"), PaymentID: paymentId, Reference: payment.reference, Status: payment.status}
+	payload, err := json.Marshal(event)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return payload, Sign(payload, gateway.secret), nil
+}
+
+// Confirm the payment and post the event to the webhook. Payments settled
+// in the meantime, voided by a cancellation for instance, send nothing.
+func (gateway *FakeGateway) deliver(paymentId string) {
+	payload, signature, err := gateway.Confirm(paymentId)
+
+	if err != nil {
+		return
+	}
+
+	request, err := http.NewRequest(http.MethodPost, gateway.WebhookURL, bytes.NewReader(payload))
+
+	if err != nil {
+		logger.Log.WithError(err).Error("Payment webhook delivery failed!! 👎")
+		return
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(SignatureHeader, signature)
+	response, err := http.DefaultClient.Do(request)
+
+	if err != nil {
+		logger.Log.WithError(err).WithField("payment", paymentId).Error("Payment webhook delivery failed!! 👎")
+		return
+	}
+
+	response.Body.Close()
+	logger.Log.WithField("payment", paymentId).WithField("status", response.StatusCode).Info("Payment webhook delivered!! 💳")
+}
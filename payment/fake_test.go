@@ -0,0 +1,147 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFakeGatewayApproves(t *testing.T) {
+	gateway := NewFakeGateway("secret")
+	ctx := context.Background()
+
+	authorized, err := gateway.Authorize(Request{Reference: "order", Amount: 5000, Method: MethodApprove}, ctx)
+
+	if err != nil || authorized.Status != StatusAuthorized {
+		t.Fatalf("expected an authorization, got %+v, %v", authorized, err)
+	}
+
+	if _, err := gateway.Capture(authorized.ID, 6000, ctx); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("captured more than authorized: %v", err)
+	}
+
+	if captured, err := gateway.Capture(authorized.ID, 5000, ctx); err != nil || captured.Status != StatusCaptured {
+		t.Fatalf("expected a capture, got %+v, %v", captured, err)
+	}
+
+	if _, err := gateway.Capture(authorized.ID, 5000, ctx); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("captured twice: %v", err)
+	}
+
+	if refunded, err := gateway.Refund(authorized.ID, 5000, ctx); err != nil || refunded.Status != StatusRefunded {
+		t.Fatalf("expected a refund, got %+v, %v", refunded, err)
+	}
+
+	if _, err := gateway.Refund(authorized.ID, 5000, ctx); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("refunded twice: %v", err)
+	}
+
+	if _, err := gateway.Capture("pay_missing", 1, ctx); !errors.Is(err, ErrUnknownPayment) {
+		t.Fatalf("expected an unknown payment, got %v", err)
+	}
+}
+
+func TestFakeGatewayDeclines(t *testing.T) {
+	gateway := NewFakeGateway("secret")
+	ctx := context.Background()
+
+	for _, method := range []string{MethodDecline, "card_unknown"} {
+		declined, err := gateway.Authorize(Request{Amount: 5000, Method: method}, ctx)
+
+		if !errors.Is(err, ErrDeclined) || declined.Status != StatusDeclined {
+			t.Fatalf("%s: expected a decline, got %+v, %v", method, declined, err)
+		}
+
+		if _, err := gateway.Capture(declined.ID, 5000, ctx); !errors.Is(err, ErrInvalidState) {
+			t.Fatalf("%s: captured a declined payment: %v", method, err)
+		}
+	}
+}
+
+func TestFakeGatewayConfirmsLater(t *testing.T) {
+	gateway := NewFakeGateway("secret")
+	ctx := context.Background()
+
+	cases := []struct{ method, want string }{
+		{MethodDelayed, StatusAuthorized},
+		{MethodDelayedDecline, StatusDeclined},
+	}
+
+	for _, c := range cases {
+		pending, err := gateway.Authorize(Request{Reference: "order", Amount: 5000, Method: c.method}, ctx)
+
+		if err != nil || pending.Status != StatusPending {
+			t.Fatalf("%s: expected a pending payment, got %+v, %v", c.method, pending, err)
+		}
+
+		if _, err := gateway.Capture(pending.ID, 5000, ctx); !errors.Is(err, ErrInvalidState) {
+			t.Fatalf("%s: captured a pending payment: %v", c.method, err)
+		}
+
+		payload, signature, err := gateway.Confirm(pending.ID)
+
+		if err != nil {
+			t.Fatalf("%s: confirm: %v", c.method, err)
+		}
+
+		event, err := gateway.VerifyWebhook(payload, signature)
+
+		if err != nil || event.PaymentID != pending.ID || event.Reference != "order" || event.Status != c.want {
+			t.Fatalf("%s: unexpected event %+v, %v", c.method, event, err)
+		}
+
+		if _, _, err := gateway.Confirm(pending.ID); !errors.Is(err, ErrInvalidState) {
+			t.Fatalf("%s: confirmed twice: %v", c.method, err)
+		}
+	}
+
+	// a voided payment is never confirmed
+	pending, _ := gateway.Authorize(Request{Amount: 5000, Method: MethodDelayed}, ctx)
+
+	if voided, err := gateway.Refund(pending.ID, 5000, ctx); err != nil || voided.Status != StatusVoided {
+		t.Fatalf("expected a void, got %+v, %v", voided, err)
+	}
+
+	if _, _, err := gateway.Confirm(pending.ID); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("confirmed a voided payment: %v", err)
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	gateway := NewFakeGateway("secret")
+	pending, _ := gateway.Authorize(Request{Amount: 5000, Method: MethodDelayed}, context.Background())
+	payload, signature, _ := gateway.Confirm(pending.ID)
+
+	forged := append([]byte{}, payload...)
+	forged[len(forged)-3] = 'X'
+
+	cases := []struct {
+		name      string
+		payload   []byte
+		signature string
+	}{
+		{"tampered payload", forged, signature},
+		{"other secret", payload, Sign(payload, []byte("guess"))},
+		{"missing", payload, ""},
+		{"bare hex", payload, signature[len("sha256="):]},
+	}
+
+	for _, c := range cases {
+		if _, err := gateway.VerifyWebhook(c.payload, c.signature); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("%s: expected an invalid signature, got %v", c.name, err)
+		}
+	}
+
+	if _, err := NewFakeGateway("other").VerifyWebhook(payload, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal("a gateway with another secret accepted the signature")
+	}
+
+	// an empty secret would let anyone sign
+	unsigned := NewFakeGateway("")
+	pending, _ = unsigned.Authorize(Request{Amount: 5000, Method: MethodDelayed}, context.Background())
+	payload, signature, _ = unsigned.Confirm(pending.ID)
+
+	if _, err := unsigned.VerifyWebhook(payload, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal("a gateway without a secret accepted a signature")
+	}
+}
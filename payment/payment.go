@@ -0,0 +1,96 @@
+// Package payment defines what the store needs from a payment provider, and
+// an in-process fake gateway standing in for a real one.
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// SignatureHeader carries the signature of a webhook payload
+const SignatureHeader = "X-Payment-Signature"
+
+// States of a payment
+const (
+	// StatusPending waits for the provider to confirm the authorization
+	StatusPending    = "pending"
+	StatusAuthorized = "authorized"
+	StatusDeclined   = "declined"
+	StatusCaptured   = "captured"
+	// StatusVoided is an authorization released before capture
+	StatusVoided   = "voided"
+	StatusRefunded = "refunded"
+)
+
+var (
+	ErrDeclined         = errors.New("payment declined")
+	ErrUnknownPayment   = errors.New("unknown payment")
+	ErrInvalidState     = errors.New("payment is not in a state allowing this")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// Request asks for an amount, in minor units, to be held on a payment
+// method. The reference ties the payment back to the order.
+type Request struct {
+	Reference string
+	Amount    int
+	Method    string
+}
+
+// Result is the state of a payment after a call to the provider
+type Result struct {
+	ID     string
+	Status string
+}
+
+// Event is a webhook notification that a payment changed state
+type Event struct {
+	ID        string `json:"id"`
+	PaymentID string `json:"payment_id"`
+	Reference string `json:"reference"`
+	Status    string `json:"status"`
+}
+
+// PaymentProvider is a payment gateway. Authorize holds the amount, Capture
+// takes it and Refund gives it back, or releases it when not captured yet.
+// Authorizations the provider cannot decide at once come back pending and
+// are settled later by a webhook event.
+type PaymentProvider interface {
+	// Authorize returns ErrDeclined when the payment method is refused
+	Authorize(request Request, ctx context.Context) (Result, error)
+	// Capture takes at most the authorized amount of an authorized payment
+	Capture(paymentId string, amount int, ctx context.Context) (Result, error)
+	// Refund gives back a captured amount, or voids a payment not captured
+	Refund(paymentId string, amount int, ctx context.Context) (Result, error)
+	// VerifyWebhook checks the signature of a webhook payload and decodes it
+	VerifyWebhook(payload []byte, signature string) (Event, error)
+}
+
+// Sign computes the signature sent along a payload: sha256= followed by the
+// hex HMAC-SHA256 of the payload under the shared secret
+func Sign(payload []byte, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature made by Sign and decodes the event. Without a
+// secret anyone could sign, so nothing verifies.
+func Verify(payload []byte, signature string, secret []byte) (Event, error) {
+	var event Event
+
+	if len(secret) == 0 || !strings.HasPrefix(signature, "sha256=") || !hmac.Equal([]byte(signature), []byte(Sign(payload, secret))) {
+		return event, ErrInvalidSignature
+	}
+
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return event, err
+	}
+
+	return event, nil
+}
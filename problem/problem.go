@@ -0,0 +1,86 @@
+// Package problem writes error responses as RFC 7807 problem details, the
+// one error shape of the API.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/BULLKNIGHT/bookstore/validation"
+)
+
+const MediaType = "application/problem+json"
+
+// Problem types clients can branch on beyond the status. Problems without a
+// more specific type are about:blank and titled after their status.
+const (
+	TypeBlank                = "about:blank"
+	TypeValidation           = "/problems/validation"
+	TypePreconditionFailed   = "/problems/precondition-failed"
+	TypePreconditionRequired = "/problems/precondition-required"
+	TypeConcurrentWrite      = "/problems/concurrent-write"
+	TypeDuplicate            = "/problems/duplicate"
+	TypeInsufficientStock    = "/problems/insufficient-stock"
+	TypeInvalidTransition    = "/problems/invalid-transition"
+	TypePaymentDeclined      = "/problems/payment-declined"
+)
+
+var titles = map[string]string{
+	TypeValidation:           "Invalid payload",
+	TypePreconditionFailed:   "Precondition failed",
+	TypePreconditionRequired: "Precondition required",
+	TypeConcurrentWrite:      "Concurrent modification",
+	TypeDuplicate:            "Already exists",
+	TypeInsufficientStock:    "Insufficient stock",
+	TypeInvalidTransition:    "Transition not allowed",
+	TypePaymentDeclined:      "Payment declined",
+}
+
+// Details is an RFC 7807 problem, extended with the request id and, for
+// validation problems, the field errors
+// @Description Error response (application/problem+json)
+type Details struct {
+	Type      string                  `json:"type" example:"about:blank"`
+	Title     string                  `json:"title" example:"Not Found"`
+	Status    int                     `json:"status" example:"404"`
+	Detail    string                  `json:"detail,omitempty" example:"no data found by given id"`
+	Instance  string                  `json:"instance,omitempty" example:"/book/6790f0c2a1b2c3d4e5f60718"`
+	RequestID string                  `json:"request_id,omitempty" example:"9f86d081884c7d65"`
+	Errors    []validation.FieldError `json:"errors,omitempty"`
+}
+
+// New describes a problem of the given type, about:blank when empty
+func New(problemType string, status int, detail string) Details {
+	title, ok := titles[problemType]
+
+	if !ok {
+		problemType = TypeBlank
+		title = http.StatusText(status)
+	}
+
+	return Details{Type: problemType, Title: title, Status: status, Detail: detail}
+}
+
+// Write sends the problem for the request. The request id is the one
+// RequestIDMiddleware put on the response.
+func (details Details) Write(w http.ResponseWriter, r *http.Request) {
+	details.Instance = r.URL.Path
+	details.RequestID = w.Header().Get("X-Request-ID")
+
+	w.Header().Set("Content-Type", MediaType)
+	w.WriteHeader(details.Status)
+	json.NewEncoder(w).Encode(details)
+}
+
+// Error sends an about:blank problem with the status
+func Error(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	New(TypeBlank, status, detail).Write(w, r)
+}
+
+// Invalid sends a validation problem listing the field errors of err
+func Invalid(w http.ResponseWriter, r *http.Request, err error) {
+	errs := validation.From(err)
+	details := New(TypeValidation, http.StatusBadRequest, errs.Error())
+	details.Errors = errs
+	details.Write(w, r)
+}
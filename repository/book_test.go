@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestIsbnKey(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, books BookRepository, orders OrderRepository) {
+		ctx := context.Background()
+
+		// stored as a legacy ISBN-10 without hyphens
+		legacy := models.Book{ID: primitive.NewObjectID(), Title: "Go", Author: "Someone", Isbn: "0134190440", Version: 1}
+
+		if err := books.Insert(legacy, ctx); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+
+		if found, err := books.GetByIsbn("9780134190440", ctx); err != nil || found.ID != legacy.ID {
+			t.Fatalf("expected the legacy book by its ISBN-13, got %+v, %v", found, err)
+		}
+
+		other := models.Book{ID: primitive.NewObjectID(), Title: "Go", Author: "Someone else", Isbn: "978-0-13-419044-0", Version: 1}
+
+		if err := books.Insert(other, ctx); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("expected the hyphenated form to be a duplicate, got %v", err)
+		}
+
+		other.Isbn = ""
+
+		if err := books.Insert(other, ctx); err != nil {
+			t.Fatalf("insert without an ISBN: %v", err)
+		}
+
+		other.Isbn = "978 0134190440"
+
+		if _, err := books.Update(other, AnyVersion, ctx); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("expected an update to a taken ISBN to fail, got %v", err)
+		}
+
+		if _, err := books.GetByIsbn("", ctx); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected no book for an empty ISBN, got %v", err)
+		}
+	})
+}
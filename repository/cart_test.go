@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCartVersions(t *testing.T) {
+	backends := map[string]func(t *testing.T) CartRepository{
+		"memory": func(t *testing.T) CartRepository { return NewMemoryCartRepository() },
+		"mongo": func(t *testing.T) CartRepository {
+			return &mongoCartRepository{collection: testDatabase(t).Collection("carts")}
+		},
+	}
+
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			carts := backend(t)
+			now := time.Now().UTC()
+			cart := models.Cart{
+				Owner:     "reader",
+				Items:     []models.CartItem{{BookID: primitive.NewObjectID(), Quantity: 1}},
+				UpdatedAt: now,
+				ExpiresAt: now.Add(time.Hour),
+			}
+
+			first, err := carts.Save(cart, 0, ctx)
+
+			if err != nil || first.Version != 1 {
+				t.Fatalf("expected the first cart at version 1, got %d: %v", first.Version, err)
+			}
+
+			// a second request that also found no cart must not replace it
+			if _, err := carts.Save(cart, 0, ctx); !errors.Is(err, ErrVersionConflict) {
+				t.Fatalf("expected a conflict for a new cart over a saved one, got %v", err)
+			}
+
+			second, err := carts.Save(first, first.Version, ctx)
+
+			if err != nil || second.Version != 2 {
+				t.Fatalf("expected the cart at version 2, got %d: %v", second.Version, err)
+			}
+
+			if _, err := carts.Save(first, first.Version, ctx); !errors.Is(err, ErrVersionConflict) {
+				t.Fatalf("expected a conflict for a stale save, got %v", err)
+			}
+
+			if err := carts.Delete(cart.Owner, first.Version, ctx); !errors.Is(err, ErrVersionConflict) {
+				t.Fatalf("expected a conflict for a stale delete, got %v", err)
+			}
+
+			if err := carts.Delete(cart.Owner, second.Version, ctx); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+
+			if _, err := carts.Get(cart.Owner, now, ctx); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected the cart removed, got %v", err)
+			}
+
+			// an expired cart counts as none
+			cart.ExpiresAt = now
+			expired, _ := carts.Save(cart, 0, ctx)
+			cart.UpdatedAt = now.Add(time.Second)
+			cart.ExpiresAt = now.Add(time.Hour)
+
+			if renewed, err := carts.Save(cart, 0, ctx); err != nil || renewed.Version != 1 {
+				t.Fatalf("expected a new cart over the expired version %d, got %v", expired.Version, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+)
+
+// MemoryAuditRepository keeps the audit log in a slice, oldest first
+type MemoryAuditRepository struct {
+	mutex   sync.RWMutex
+	entries []models.AuditEntry
+}
+
+func NewMemoryAuditRepository() *MemoryAuditRepository {
+	return &MemoryAuditRepository{}
+}
+
+func (repo *MemoryAuditRepository) Append(entries []models.AuditEntry, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.entries = append(repo.entries, entries...)
+	return nil
+}
+
+func matchesAuditQuery(entry models.AuditEntry, query models.AuditQuery) bool {
+	return (query.Actor == "" || entry.Actor == query.Actor) &&
+		(query.BookID.IsZero() || entry.BookID == query.BookID) &&
+		(query.From.IsZero() || !entry.Timestamp.Before(query.From)) &&
+		(query.To.IsZero() || !entry.Timestamp.After(query.To)) &&
+		(query.After.IsZero() || bytes.Compare(entry.ID[:], query.After[:]) < 0)
+}
+
+func (repo *MemoryAuditRepository) List(query models.AuditQuery, ctx context.Context) ([]models.AuditEntry, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	entries := []models.AuditEntry{}
+
+	for i := len(repo.entries) - 1; i >= 0; i-- {
+		if query.Limit > 0 && len(entries) == query.Limit {
+			break
+		}
+
+		if matchesAuditQuery(repo.entries[i], query) {
+			entries = append(entries, repo.entries[i])
+		}
+	}
+
+	return entries, nil
+}
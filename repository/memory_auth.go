@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+)
+
+// MemoryUserRepository keeps user accounts in a map keyed by name
+type MemoryUserRepository struct {
+	mutex sync.RWMutex
+	users map[string]models.User
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[string]models.User{}}
+}
+
+func (repo *MemoryUserRepository) FindByName(name string, ctx context.Context) (models.User, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	user, ok := repo.users[name]
+
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+
+	return user, nil
+}
+
+func (repo *MemoryUserRepository) Insert(user models.User, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.users[user.Name]; ok {
+		return ErrDuplicate
+	}
+
+	repo.users[user.Name] = user
+	return nil
+}
+
+// MemoryTokenRepository keeps refresh tokens and revocations in maps.
+// Expired revocations are dropped lazily.
+type MemoryTokenRepository struct {
+	mutex         sync.Mutex
+	refreshTokens map[string]models.RefreshToken
+	revokedTokens map[string]time.Time
+}
+
+func NewMemoryTokenRepository() *MemoryTokenRepository {
+	return &MemoryTokenRepository{
+		refreshTokens: map[string]models.RefreshToken{},
+		revokedTokens: map[string]time.Time{},
+	}
+}
+
+func (repo *MemoryTokenRepository) InsertRefresh(token models.RefreshToken, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.refreshTokens[token.Hash] = token
+	return nil
+}
+
+func (repo *MemoryTokenRepository) FindRefresh(hash string, ctx context.Context) (models.RefreshToken, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	token, ok := repo.refreshTokens[hash]
+
+	if !ok {
+		return models.RefreshToken{}, ErrNotFound
+	}
+
+	return token, nil
+}
+
+func (repo *MemoryTokenRepository) UseRefresh(hash string, now time.Time, ctx context.Context) (models.RefreshToken, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	token, ok := repo.refreshTokens[hash]
+
+	if !ok {
+		return models.RefreshToken{}, ErrNotFound
+	}
+
+	if token.UsedAt != nil {
+		return token, ErrAlreadyUsed
+	}
+
+	token.UsedAt = &now
+	repo.refreshTokens[hash] = token
+
+	return token, nil
+}
+
+func (repo *MemoryTokenRepository) RevokeFamily(family string, ctx context.Context) (int64, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	var count int64
+
+	for hash, token := range repo.refreshTokens {
+		if token.Family == family {
+			delete(repo.refreshTokens, hash)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (repo *MemoryTokenRepository) Revoke(tokenID string, expiresAt time.Time, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.revokedTokens[tokenID] = expiresAt
+	return nil
+}
+
+func (repo *MemoryTokenRepository) IsRevoked(tokenID string, ctx context.Context) (bool, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	expiresAt, ok := repo.revokedTokens[tokenID]
+
+	if ok && time.Now().After(expiresAt) {
+		delete(repo.revokedTokens, tokenID)
+		return false, nil
+	}
+
+	return ok, nil
+}
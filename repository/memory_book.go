@@ -0,0 +1,431 @@
+package repository
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryBookRepository keeps the catalog in a map. Listing mirrors the
+// filtering, ordering and cursor semantics of the MongoDB implementation.
+type MemoryBookRepository struct {
+	mutex sync.RWMutex
+	books map[primitive.ObjectID]models.Book
+}
+
+func NewMemoryBookRepository() *MemoryBookRepository {
+	return &MemoryBookRepository{books: map[primitive.ObjectID]models.Book{}}
+}
+
+func inRange(value int, min int, max int) bool {
+	return (min == 0 || value >= min) && (max == 0 || value <= max)
+}
+
+func matchesQuery(book models.Book, query models.BookQuery) bool {
+	return book.InTrash() == query.Trash &&
+		(query.Author == "" || strings.EqualFold(book.Author, query.Author)) &&
+		(query.Category == "" || strings.EqualFold(book.Category, query.Category)) &&
+		inRange(book.PublishedYear, query.MinYear, query.MaxYear) &&
+		inRange(book.Price, query.MinPrice, query.MaxPrice)
+}
+
+func compareSortValues(a any, b any) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int:
+		return cmp.Compare(a, b.(int))
+	}
+
+	return 0
+}
+
+// Order a book against a (sort value, _id) position, ascending
+func compareBook(book models.Book, value any, id primitive.ObjectID, field string) int {
+	if field != "" {
+		if order := compareSortValues(book.SortValue(field), value); order != 0 {
+			return order
+		}
+	}
+
+	return bytes.Compare(book.ID[:], id[:])
+}
+
+func (repo *MemoryBookRepository) List(query models.BookQuery, ctx context.Context) ([]models.Book, int64, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	books := []models.Book{}
+
+	for _, book := range repo.books {
+		if matchesQuery(book, query) {
+			books = append(books, book)
+		}
+	}
+
+	total := int64(len(books))
+	direction := 1
+
+	if query.Descending {
+		direction = -1
+	}
+
+	slices.SortFunc(books, func(a models.Book, b models.Book) int {
+		return direction * compareBook(a, b.SortValue(query.SortField), b.ID, query.SortField)
+	})
+
+	if query.After != nil {
+		after := books[:0]
+
+		for _, book := range books {
+			if direction*compareBook(book, query.After.Value, query.After.ID, query.SortField) > 0 {
+				after = append(after, book)
+			}
+		}
+
+		books = after
+	}
+
+	if query.Limit > 0 && len(books) > query.Limit {
+		books = books[:query.Limit]
+	}
+
+	return books, total, nil
+}
+
+func (repo *MemoryBookRepository) Stream(query models.BookQuery, fn func(models.Book) error, ctx context.Context) error {
+	books, _, _ := repo.List(query, ctx)
+
+	for _, book := range books {
+		if err := fn(book); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (repo *MemoryBookRepository) Get(bookId primitive.ObjectID, ctx context.Context) (models.Book, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	book, ok := repo.books[bookId]
+
+	if !ok || book.InTrash() {
+		return models.Book{}, ErrNotFound
+	}
+
+	return book, nil
+}
+
+func (repo *MemoryBookRepository) GetMany(bookIds []primitive.ObjectID, ctx context.Context) (map[primitive.ObjectID]models.Book, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	books := map[primitive.ObjectID]models.Book{}
+
+	for _, bookId := range bookIds {
+		if book, ok := repo.books[bookId]; ok && !book.InTrash() {
+			books[bookId] = book
+		}
+	}
+
+	return books, nil
+}
+
+func (repo *MemoryBookRepository) GetByIsbn(isbn13 string, ctx context.Context) (models.Book, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	for _, book := range repo.books {
+		if isbn13 != "" && book.Isbn13 == isbn13 && !book.InTrash() {
+			return book, nil
+		}
+	}
+
+	return models.Book{}, ErrNotFound
+}
+
+// Mirrors the unique ISBN index, which also covers books in the trash
+func (repo *MemoryBookRepository) isbnTaken(book models.Book) bool {
+	if book.Isbn13 == "" {
+		return false
+	}
+
+	for _, stored := range repo.books {
+		if stored.Isbn13 == book.Isbn13 && stored.ID != book.ID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (repo *MemoryBookRepository) Insert(book models.Book, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	book.Isbn13 = book.IsbnKey()
+
+	if _, ok := repo.books[book.ID]; ok || repo.isbnTaken(book) {
+		return ErrDuplicate
+	}
+
+	repo.books[book.ID] = book
+	return nil
+}
+
+func (repo *MemoryBookRepository) InsertMany(books []models.Book, ctx context.Context) ([]error, error) {
+	errs := make([]error, len(books))
+
+	for i, book := range books {
+		errs[i] = repo.Insert(book, ctx)
+	}
+
+	return errs, nil
+}
+
+// Check the stored live book against the expected version, caller holds the lock
+func (repo *MemoryBookRepository) checkVersion(bookId primitive.ObjectID, expectedVersion int) (models.Book, error) {
+	stored, ok := repo.books[bookId]
+
+	if !ok || stored.InTrash() {
+		return models.Book{}, ErrNotFound
+	}
+
+	if expectedVersion != AnyVersion && stored.Version != expectedVersion {
+		return models.Book{}, ErrVersionConflict
+	}
+
+	return stored, nil
+}
+
+func (repo *MemoryBookRepository) Update(book models.Book, expectedVersion int, ctx context.Context) (models.Book, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	stored, err := repo.checkVersion(book.ID, expectedVersion)
+
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	book.Isbn13 = book.IsbnKey()
+
+	if repo.isbnTaken(book) {
+		return models.Book{}, ErrDuplicate
+	}
+
+	// trash state only changes through Delete and Restore, stock through
+	// AdjustStock and TransferStock, the threshold through SetReorderThreshold
+	book.Version = stored.Version + 1
+	book.DeletedAt = nil
+	book.DeletedBy = ""
+	book.Stock = stored.Stock
+	book.Reserved = stored.Reserved
+	book.StockByLocation = stored.StockByLocation
+	book.ReorderThreshold = stored.ReorderThreshold
+	book.StockVersion = stored.StockVersion
+	repo.books[book.ID] = book
+
+	return book, nil
+}
+
+// Move a book to the trash, caller holds the lock
+func (repo *MemoryBookRepository) trash(book models.Book, deletedBy string, now time.Time) {
+	book.DeletedAt = &now
+	book.DeletedBy = deletedBy
+	book.UpdatedAt = now
+	book.Version++
+	repo.books[book.ID] = book
+}
+
+func (repo *MemoryBookRepository) Delete(bookId primitive.ObjectID, expectedVersion int, deletedBy string, now time.Time, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	stored, err := repo.checkVersion(bookId, expectedVersion)
+
+	if err != nil {
+		return err
+	}
+
+	repo.trash(stored, deletedBy, now)
+	return nil
+}
+
+func (repo *MemoryBookRepository) DeleteMany(bookIds []primitive.ObjectID, deletedBy string, now time.Time, ctx context.Context) (int64, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	var count int64
+
+	for _, bookId := range bookIds {
+		if book, ok := repo.books[bookId]; ok && !book.InTrash() {
+			repo.trash(book, deletedBy, now)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (repo *MemoryBookRepository) Restore(bookId primitive.ObjectID, now time.Time, ctx context.Context) (models.Book, models.Book, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	trashed, ok := repo.books[bookId]
+
+	if !ok || !trashed.InTrash() {
+		return models.Book{}, models.Book{}, ErrNotFound
+	}
+
+	book := trashed
+	book.DeletedAt = nil
+	book.DeletedBy = ""
+	book.UpdatedAt = now
+	book.Version++
+	repo.books[bookId] = book
+
+	return trashed, book, nil
+}
+
+func (repo *MemoryBookRepository) Purge(deletedBefore time.Time, ctx context.Context) (int64, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	var count int64
+
+	for id, book := range repo.books {
+		if book.InTrash() && !book.DeletedAt.After(deletedBefore) {
+			delete(repo.books, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Number of copies a location, or the unassigned stock, holds
+func heldAt(book models.Book, locationId string) int {
+	if locationId == "" {
+		return book.Unassigned()
+	}
+
+	return book.StockByLocation[locationId]
+}
+
+// Store a stock write, caller holds the lock. The map is copied because
+// books handed out share it with the stored one.
+func (repo *MemoryBookRepository) writeStock(book models.Book, byLocation map[string]int, now time.Time) models.Book {
+	book.StockByLocation = byLocation
+	book.UpdatedAt = now
+	book.StockVersion++
+	repo.books[book.ID] = book
+
+	return book
+}
+
+func (repo *MemoryBookRepository) AdjustStock(bookId primitive.ObjectID, locationId string, delta int, now time.Time, ctx context.Context) (models.Book, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	book, err := repo.checkVersion(bookId, AnyVersion)
+
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	if delta < 0 && (book.Available() < -delta || heldAt(book, locationId) < -delta) {
+		return models.Book{}, ErrInsufficientStock
+	}
+
+	byLocation := maps.Clone(book.StockByLocation)
+
+	if locationId != "" {
+		if byLocation == nil {
+			byLocation = map[string]int{}
+		}
+
+		byLocation[locationId] += delta
+	}
+
+	book.Stock += delta
+	return repo.writeStock(book, byLocation, now), nil
+}
+
+func (repo *MemoryBookRepository) SetReorderThreshold(bookId primitive.ObjectID, threshold int, now time.Time, ctx context.Context) (models.Book, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	book, err := repo.checkVersion(bookId, AnyVersion)
+
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	book.ReorderThreshold = threshold
+	return repo.writeStock(book, book.StockByLocation, now), nil
+}
+
+func (repo *MemoryBookRepository) TransferStock(bookId primitive.ObjectID, from string, to string, quantity int, now time.Time, ctx context.Context) (models.Book, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	book, err := repo.checkVersion(bookId, AnyVersion)
+
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	if heldAt(book, from) < quantity {
+		return models.Book{}, ErrInsufficientStock
+	}
+
+	byLocation := maps.Clone(book.StockByLocation)
+
+	if byLocation == nil {
+		byLocation = map[string]int{}
+	}
+
+	if from != "" {
+		byLocation[from] -= quantity
+	}
+
+	if to != "" {
+		byLocation[to] += quantity
+	}
+
+	return repo.writeStock(book, byLocation, now), nil
+}
+
+func (repo *MemoryBookRepository) LowStock(ctx context.Context) ([]models.Book, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	books := []models.Book{}
+
+	for _, book := range repo.books {
+		if !book.InTrash() && book.LowOnStock() {
+			books = append(books, book)
+		}
+	}
+
+	slices.SortFunc(books, func(a models.Book, b models.Book) int {
+		if order := cmp.Compare(a.Available(), b.Available()); order != 0 {
+			return order
+		}
+
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+
+	return books, nil
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+)
+
+// MemoryBulkDeleteRepository keeps confirmations and snapshots in maps.
+// Expired confirmations are left to the caller to reject.
+type MemoryBulkDeleteRepository struct {
+	mutex         sync.Mutex
+	confirmations map[string]models.BulkDeleteConfirmation
+	snapshots     map[string]models.BookSnapshot
+}
+
+func NewMemoryBulkDeleteRepository() *MemoryBulkDeleteRepository {
+	return &MemoryBulkDeleteRepository{
+		confirmations: map[string]models.BulkDeleteConfirmation{},
+		snapshots:     map[string]models.BookSnapshot{},
+	}
+}
+
+func (repo *MemoryBulkDeleteRepository) InsertConfirmation(confirmation models.BulkDeleteConfirmation, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.confirmations[confirmation.Hash] = confirmation
+	return nil
+}
+
+func (repo *MemoryBulkDeleteRepository) UseConfirmation(hash string, ctx context.Context) (models.BulkDeleteConfirmation, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	confirmation, ok := repo.confirmations[hash]
+
+	if !ok {
+		return models.BulkDeleteConfirmation{}, ErrNotFound
+	}
+
+	delete(repo.confirmations, hash)
+	return confirmation, nil
+}
+
+func (repo *MemoryBulkDeleteRepository) InsertSnapshot(snapshot models.BookSnapshot, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if len(snapshot.Books) == 0 {
+		return nil
+	}
+
+	if _, ok := repo.snapshots[snapshot.ID]; ok {
+		return ErrDuplicate
+	}
+
+	repo.snapshots[snapshot.ID] = snapshot
+	return nil
+}
+
+func (repo *MemoryBulkDeleteRepository) GetSnapshot(snapshotId string, ctx context.Context) (models.BookSnapshot, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	snapshot, ok := repo.snapshots[snapshotId]
+
+	if !ok {
+		return models.BookSnapshot{}, ErrNotFound
+	}
+
+	return snapshot, nil
+}
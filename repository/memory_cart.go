@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+)
+
+// MemoryCartRepository keeps the carts in a map. Expired carts are dropped
+// when read.
+type MemoryCartRepository struct {
+	mutex sync.Mutex
+	carts map[string]models.Cart
+}
+
+func NewMemoryCartRepository() *MemoryCartRepository {
+	return &MemoryCartRepository{carts: map[string]models.Cart{}}
+}
+
+func (repo *MemoryCartRepository) Get(owner string, now time.Time, ctx context.Context) (models.Cart, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	cart, ok := repo.carts[owner]
+
+	if ok && !cart.ExpiresAt.After(now) {
+		delete(repo.carts, owner)
+		ok = false
+	}
+
+	if !ok {
+		return models.Cart{}, ErrNotFound
+	}
+
+	// callers may change the items
+	cart.Items = slices.Clone(cart.Items)
+	return cart, nil
+}
+
+// The version of the stored cart, zero when there is none or it expired
+// before now, caller holds the lock
+func (repo *MemoryCartRepository) version(owner string, now time.Time) int {
+	stored, ok := repo.carts[owner]
+
+	if !ok || !stored.ExpiresAt.After(now) {
+		return 0
+	}
+
+	return stored.Version
+}
+
+func (repo *MemoryCartRepository) Save(cart models.Cart, expectedVersion int, ctx context.Context) (models.Cart, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if repo.version(cart.Owner, cart.UpdatedAt) != expectedVersion {
+		return models.Cart{}, ErrVersionConflict
+	}
+
+	cart.Version = expectedVersion + 1
+	cart.Items = slices.Clone(cart.Items)
+	repo.carts[cart.Owner] = cart
+
+	saved := cart
+	saved.Items = slices.Clone(cart.Items)
+	return saved, nil
+}
+
+func (repo *MemoryCartRepository) Delete(owner string, expectedVersion int, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	_, ok := repo.carts[owner]
+
+	if expectedVersion != AnyVersion && (!ok || repo.carts[owner].Version != expectedVersion) {
+		return ErrVersionConflict
+	}
+
+	delete(repo.carts, owner)
+	return nil
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+)
+
+// MemoryImportJobRepository keeps import jobs in a map
+type MemoryImportJobRepository struct {
+	mutex sync.RWMutex
+	jobs  map[string]models.ImportJob
+}
+
+func NewMemoryImportJobRepository() *MemoryImportJobRepository {
+	return &MemoryImportJobRepository{jobs: map[string]models.ImportJob{}}
+}
+
+func (repo *MemoryImportJobRepository) Insert(job models.ImportJob, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.jobs[job.ID]; ok {
+		return ErrDuplicate
+	}
+
+	repo.jobs[job.ID] = job
+	return nil
+}
+
+func (repo *MemoryImportJobRepository) Update(job models.ImportJob, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.jobs[job.ID]; !ok {
+		return ErrNotFound
+	}
+
+	repo.jobs[job.ID] = job
+	return nil
+}
+
+func (repo *MemoryImportJobRepository) Get(jobId string, ctx context.Context) (models.ImportJob, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	job, ok := repo.jobs[jobId]
+
+	if !ok {
+		return models.ImportJob{}, ErrNotFound
+	}
+
+	return job, nil
+}
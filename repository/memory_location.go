@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryLocationRepository keeps the locations in a map
+type MemoryLocationRepository struct {
+	mutex     sync.RWMutex
+	locations map[primitive.ObjectID]models.Location
+}
+
+func NewMemoryLocationRepository() *MemoryLocationRepository {
+	return &MemoryLocationRepository{locations: map[primitive.ObjectID]models.Location{}}
+}
+
+func (repo *MemoryLocationRepository) Insert(location models.Location, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for _, stored := range repo.locations {
+		if stored.ID == location.ID || stored.Code == location.Code {
+			return ErrDuplicate
+		}
+	}
+
+	repo.locations[location.ID] = location
+	return nil
+}
+
+func (repo *MemoryLocationRepository) Get(locationId primitive.ObjectID, ctx context.Context) (models.Location, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	location, ok := repo.locations[locationId]
+
+	if !ok {
+		return models.Location{}, ErrNotFound
+	}
+
+	return location, nil
+}
+
+func (repo *MemoryLocationRepository) List(ctx context.Context) ([]models.Location, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	locations := []models.Location{}
+
+	for _, location := range repo.locations {
+		locations = append(locations, location)
+	}
+
+	slices.SortFunc(locations, func(a models.Location, b models.Location) int {
+		return strings.Compare(a.Code, b.Code)
+	})
+
+	return locations, nil
+}
+
+// MemoryMovementRepository keeps the stock movements in a slice, oldest first
+type MemoryMovementRepository struct {
+	mutex     sync.RWMutex
+	movements []models.StockMovement
+}
+
+func NewMemoryMovementRepository() *MemoryMovementRepository {
+	return &MemoryMovementRepository{}
+}
+
+func (repo *MemoryMovementRepository) Append(movement models.StockMovement, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.movements = append(repo.movements, movement)
+	return nil
+}
+
+func (repo *MemoryMovementRepository) List(bookId primitive.ObjectID, ctx context.Context) ([]models.StockMovement, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	movements := []models.StockMovement{}
+
+	for i := len(repo.movements) - 1; i >= 0; i-- {
+		if repo.movements[i].BookID == bookId {
+			movements = append(movements, repo.movements[i])
+		}
+	}
+
+	return movements, nil
+}
@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryOrderRepository keeps the orders in a map. It changes the stock of
+// the books it shares with the catalog under the catalog lock, so an order
+// and its stock change together.
+type MemoryOrderRepository struct {
+	mutex  sync.RWMutex
+	orders map[primitive.ObjectID]models.Order
+	books  *MemoryBookRepository
+}
+
+func NewMemoryOrderRepository(books *MemoryBookRepository) *MemoryOrderRepository {
+	return &MemoryOrderRepository{orders: map[primitive.ObjectID]models.Order{}, books: books}
+}
+
+func (repo *MemoryOrderRepository) Place(order models.Order, ctx context.Context) error {
+	repo.books.mutex.Lock()
+	defer repo.books.mutex.Unlock()
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	// check the order and every line before touching any book
+	if _, ok := repo.orders[order.ID]; ok {
+		return ErrDuplicate
+	}
+
+	for _, line := range order.Lines {
+		book, ok := repo.books.books[line.BookID]
+
+		if !ok || book.InTrash() {
+			return ErrNotFound
+		}
+
+		if book.Available() < line.Quantity {
+			return ErrInsufficientStock
+		}
+	}
+
+	for _, line := range order.Lines {
+		book := repo.books.books[line.BookID]
+		book.Reserved += line.Quantity
+		book.UpdatedAt = order.CreatedAt
+		book.StockVersion++
+		repo.books.books[line.BookID] = book
+	}
+
+	repo.orders[order.ID] = order
+	return nil
+}
+
+func (repo *MemoryOrderRepository) Get(orderId primitive.ObjectID, ctx context.Context) (models.Order, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	order, ok := repo.orders[orderId]
+
+	if !ok {
+		return models.Order{}, ErrNotFound
+	}
+
+	return order, nil
+}
+
+func (repo *MemoryOrderRepository) List(owner string, ctx context.Context) ([]models.Order, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	orders := []models.Order{}
+
+	for _, order := range repo.orders {
+		if order.Owner == owner {
+			orders = append(orders, order)
+		}
+	}
+
+	slices.SortFunc(orders, func(a models.Order, b models.Order) int {
+		return bytes.Compare(b.ID[:], a.ID[:])
+	})
+
+	return orders, nil
+}
+
+func (repo *MemoryOrderRepository) Transition(orderId primitive.ObjectID, move models.OrderMove, ctx context.Context) (models.Order, error) {
+	event := move.Event
+
+	repo.books.mutex.Lock()
+	defer repo.books.mutex.Unlock()
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	order, ok := repo.orders[orderId]
+
+	if !ok {
+		return models.Order{}, ErrNotFound
+	}
+
+	if order.Status != move.From || order.PaymentSettling() != move.Settling {
+		return models.Order{}, ErrVersionConflict
+	}
+
+	if move.Stock == models.StockCommit {
+		for _, line := range order.Lines {
+			book, ok := repo.books.books[line.BookID]
+
+			if !ok || heldAt(book, move.LocationID) < line.Quantity {
+				return models.Order{}, ErrInsufficientStock
+			}
+		}
+	}
+
+	for _, line := range order.Lines {
+		book, ok := repo.books.books[line.BookID]
+
+		// books purged since checkout have no copies left to hand back
+		if !ok || move.Stock == models.StockKeep {
+			continue
+		}
+
+		book.Reserved -= line.Quantity
+
+		if move.Stock == models.StockCommit {
+			book.Stock -= line.Quantity
+
+			if move.LocationID != "" {
+				book.StockByLocation = maps.Clone(book.StockByLocation)
+				book.StockByLocation[move.LocationID] -= line.Quantity
+			}
+		}
+
+		book.UpdatedAt = event.At
+		book.StockVersion++
+		repo.books.books[line.BookID] = book
+	}
+
+	if move.Payment != nil {
+		order.Payment = move.Payment
+	}
+
+	order.Status = event.Status
+	order.UpdatedAt = event.At
+	order.History = append(slices.Clone(order.History), event)
+	repo.orders[orderId] = order
+
+	return order, nil
+}
+
+func (repo *MemoryOrderRepository) SetPayment(orderId primitive.ObjectID, from models.OrderPayment, payment models.OrderPayment, now time.Time, ctx context.Context) (models.Order, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	order, ok := repo.orders[orderId]
+
+	if !ok {
+		return models.Order{}, ErrNotFound
+	}
+
+	if order.Payment == nil || order.Payment.Status != from.Status || order.Payment.Settling != from.Settling {
+		return models.Order{}, ErrVersionConflict
+	}
+
+	order.Payment = &payment
+	order.UpdatedAt = now
+	repo.orders[orderId] = order
+
+	return order, nil
+}
+
+func (repo *MemoryOrderRepository) Settling(claimedBefore time.Time, ctx context.Context) ([]models.Order, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	orders := []models.Order{}
+
+	for _, order := range repo.orders {
+		if order.PaymentSettling() != "" && !order.UpdatedAt.After(claimedBefore) {
+			orders = append(orders, order)
+		}
+	}
+
+	return orders, nil
+}
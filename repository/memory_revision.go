@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRevisionRepository keeps the revisions of each book, oldest first
+type MemoryRevisionRepository struct {
+	mutex     sync.RWMutex
+	revisions map[primitive.ObjectID][]models.BookRevision
+}
+
+func NewMemoryRevisionRepository() *MemoryRevisionRepository {
+	return &MemoryRevisionRepository{revisions: map[primitive.ObjectID][]models.BookRevision{}}
+}
+
+func (repo *MemoryRevisionRepository) Append(revisions []models.BookRevision, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for _, revision := range revisions {
+		if _, err := repo.find(revision.BookID, revision.Version); err == nil {
+			return ErrDuplicate
+		}
+	}
+
+	for _, revision := range revisions {
+		repo.revisions[revision.BookID] = append(repo.revisions[revision.BookID], revision)
+	}
+
+	return nil
+}
+
+func (repo *MemoryRevisionRepository) List(bookId primitive.ObjectID, ctx context.Context) ([]models.BookRevision, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	revisions := slices.Clone(repo.revisions[bookId])
+
+	slices.SortFunc(revisions, func(a models.BookRevision, b models.BookRevision) int {
+		return a.Version - b.Version
+	})
+
+	if revisions == nil {
+		revisions = []models.BookRevision{}
+	}
+
+	return revisions, nil
+}
+
+// Look up a revision, caller holds the lock
+func (repo *MemoryRevisionRepository) find(bookId primitive.ObjectID, version int) (models.BookRevision, error) {
+	for _, revision := range repo.revisions[bookId] {
+		if revision.Version == version {
+			return revision, nil
+		}
+	}
+
+	return models.BookRevision{}, ErrNotFound
+}
+
+func (repo *MemoryRevisionRepository) Get(bookId primitive.ObjectID, version int, ctx context.Context) (models.BookRevision, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	return repo.find(bookId, version)
+}
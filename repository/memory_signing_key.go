@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+)
+
+// MemorySigningKeyRepository keeps the signing keys in a slice
+type MemorySigningKeyRepository struct {
+	mutex sync.RWMutex
+	keys  []models.SigningKey
+}
+
+func NewMemorySigningKeyRepository() *MemorySigningKeyRepository {
+	return &MemorySigningKeyRepository{}
+}
+
+func (repo *MemorySigningKeyRepository) List(ctx context.Context) ([]models.SigningKey, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	keys := slices.Clone(repo.keys)
+	slices.SortStableFunc(keys, func(a, b models.SigningKey) int { return a.ActiveAt.Compare(b.ActiveAt) })
+	return keys, nil
+}
+
+func (repo *MemorySigningKeyRepository) Insert(key models.SigningKey, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for _, stored := range repo.keys {
+		if stored.ID == key.ID || stored.Previous == key.Previous {
+			return ErrDuplicate
+		}
+	}
+
+	repo.keys = append(repo.keys, key)
+	return nil
+}
+
+func (repo *MemorySigningKeyRepository) Prune(keyId string, now time.Time, ctx context.Context) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for i := range repo.keys {
+		if repo.keys[i].ID == keyId {
+			repo.keys[i].SealedKey = ""
+			repo.keys[i].PrunedAt = &now
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoAuditRepository struct {
+	collection *mongo.Collection
+}
+
+func (repo *mongoAuditRepository) Append(entries []models.AuditEntry, ctx context.Context) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	documents := make([]any, len(entries))
+
+	for i, entry := range entries {
+		documents[i] = entry
+	}
+
+	_, err := repo.collection.InsertMany(ctx, documents)
+	return err
+}
+
+func auditFilter(query models.AuditQuery) bson.M {
+	filter := bson.M{}
+
+	if query.Actor != "" {
+		filter["actor"] = query.Actor
+	}
+
+	if !query.BookID.IsZero() {
+		filter["book_id"] = query.BookID
+	}
+
+	timestamp := bson.M{}
+
+	if !query.From.IsZero() {
+		timestamp["$gte"] = query.From
+	}
+
+	if !query.To.IsZero() {
+		timestamp["$lte"] = query.To
+	}
+
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	if !query.After.IsZero() {
+		filter["_id"] = bson.M{"$lt": query.After}
+	}
+
+	return filter
+}
+
+func (repo *mongoAuditRepository) List(query models.AuditQuery, ctx context.Context) ([]models.AuditEntry, error) {
+	entries := []models.AuditEntry{}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(query.Limit))
+	cursor, err := repo.collection.Find(ctx, auditFilter(query), findOptions)
+
+	if err != nil {
+		return entries, err
+	}
+
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &entries); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoUserRepository struct {
+	collection *mongo.Collection
+}
+
+func (repo *mongoUserRepository) FindByName(name string, ctx context.Context) (models.User, error) {
+	var user models.User
+	err := repo.collection.FindOne(ctx, bson.M{"name": name}).Decode(&user)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return user, ErrNotFound
+	}
+
+	return user, err
+}
+
+func (repo *mongoUserRepository) Insert(user models.User, ctx context.Context) error {
+	_, err := repo.collection.InsertOne(ctx, user)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+
+	return err
+}
+
+type mongoTokenRepository struct {
+	refreshTokens *mongo.Collection
+	revokedTokens *mongo.Collection
+}
+
+func (repo *mongoTokenRepository) InsertRefresh(token models.RefreshToken, ctx context.Context) error {
+	_, err := repo.refreshTokens.InsertOne(ctx, token)
+	return err
+}
+
+func (repo *mongoTokenRepository) FindRefresh(hash string, ctx context.Context) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := repo.refreshTokens.FindOne(ctx, bson.M{"_id": hash}).Decode(&token)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return token, ErrNotFound
+	}
+
+	return token, err
+}
+
+func (repo *mongoTokenRepository) UseRefresh(hash string, now time.Time, ctx context.Context) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	filter := bson.M{"_id": hash, "used_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"used_at": now}}
+	err := repo.refreshTokens.FindOneAndUpdate(ctx, filter, update).Decode(&token)
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return token, err
+	}
+
+	// either unknown or used before
+	token, err = repo.FindRefresh(hash, ctx)
+
+	if err != nil {
+		return token, err
+	}
+
+	return token, ErrAlreadyUsed
+}
+
+func (repo *mongoTokenRepository) RevokeFamily(family string, ctx context.Context) (int64, error) {
+	result, err := repo.refreshTokens.DeleteMany(ctx, bson.M{"family": family})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+func (repo *mongoTokenRepository) Revoke(tokenID string, expiresAt time.Time, ctx context.Context) error {
+	filter := bson.M{"_id": tokenID}
+	update := bson.M{"$set": bson.M{"expires_at": expiresAt}}
+	_, err := repo.revokedTokens.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+
+	return err
+}
+
+func (repo *mongoTokenRepository) IsRevoked(tokenID string, ctx context.Context) (bool, error) {
+	count, err := repo.revokedTokens.CountDocuments(ctx, bson.M{"_id": tokenID})
+
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
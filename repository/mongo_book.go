@@ -0,0 +1,498 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoBookRepository struct {
+	collection *mongo.Collection
+}
+
+// Translate the listing filters into a MongoDB filter
+func bookFilter(query models.BookQuery) bson.M {
+	filter := bson.M{"deleted_at": nil}
+
+	if query.Trash {
+		filter["deleted_at"] = bson.M{"$ne": nil}
+	}
+
+	if query.Author != "" {
+		filter["author"] = bson.M{"$regex": "^" + regexp.QuoteMeta(query.Author) + "$", "$options": "i"}
+	}
+
+	if query.Category != "" {
+		filter["category"] = bson.M{"$regex": "^" + regexp.QuoteMeta(query.Category) + "$", "$options": "i"}
+	}
+
+	if bounds := rangeFilter(query.MinYear, query.MaxYear); len(bounds) > 0 {
+		filter["published_year"] = bounds
+	}
+
+	if bounds := rangeFilter(query.MinPrice, query.MaxPrice); len(bounds) > 0 {
+		filter["price"] = bounds
+	}
+
+	return filter
+}
+
+func rangeFilter(min int, max int) bson.M {
+	bounds := bson.M{}
+
+	if min > 0 {
+		bounds["$gte"] = min
+	}
+
+	if max > 0 {
+		bounds["$lte"] = max
+	}
+
+	return bounds
+}
+
+// Keyset condition selecting the books after the cursor in sort order
+func cursorFilter(query models.BookQuery) bson.M {
+	op := "$gt"
+
+	if query.Descending {
+		op = "$lt"
+	}
+
+	if query.SortField == "" {
+		return bson.M{"_id": bson.M{op: query.After.ID}}
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{query.SortField: bson.M{op: query.After.Value}},
+		bson.M{query.SortField: query.After.Value, "_id": bson.M{op: query.After.ID}},
+	}}
+}
+
+// Open a cursor over the page described by the query
+func (repo *mongoBookRepository) find(query models.BookQuery, ctx context.Context) (*mongo.Cursor, error) {
+	filter := bookFilter(query)
+
+	if query.After != nil {
+		filter = bson.M{"$and": bson.A{filter, cursorFilter(query)}}
+	}
+
+	direction := 1
+
+	if query.Descending {
+		direction = -1
+	}
+
+	sort := bson.D{{Key: "_id", Value: direction}}
+
+	if query.SortField != "" {
+		sort = append(bson.D{{Key: query.SortField, Value: direction}}, sort...)
+	}
+
+	findOptions := options.Find().SetSort(sort).SetLimit(int64(query.Limit))
+	return repo.collection.Find(ctx, filter, findOptions)
+}
+
+func (repo *mongoBookRepository) List(query models.BookQuery, ctx context.Context) ([]models.Book, int64, error) {
+	books := []models.Book{}
+	total, err := repo.collection.CountDocuments(ctx, bookFilter(query))
+
+	if err != nil {
+		return books, 0, err
+	}
+
+	err = repo.Stream(query, func(book models.Book) error {
+		books = append(books, book)
+		return nil
+	}, ctx)
+
+	return books, total, err
+}
+
+func (repo *mongoBookRepository) Stream(query models.BookQuery, fn func(models.Book) error, ctx context.Context) error {
+	cursor, err := repo.find(query, ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var book models.Book
+
+		if err = cursor.Decode(&book); err != nil {
+			return err
+		}
+
+		if err = fn(book); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (repo *mongoBookRepository) Get(bookId primitive.ObjectID, ctx context.Context) (models.Book, error) {
+	var book models.Book
+	err := repo.collection.FindOne(ctx, bson.M{"_id": bookId, "deleted_at": nil}).Decode(&book)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return book, ErrNotFound
+	}
+
+	return book, err
+}
+
+func (repo *mongoBookRepository) GetMany(bookIds []primitive.ObjectID, ctx context.Context) (map[primitive.ObjectID]models.Book, error) {
+	books := map[primitive.ObjectID]models.Book{}
+
+	if len(bookIds) == 0 {
+		return books, nil
+	}
+
+	cursor, err := repo.collection.Find(ctx, bson.M{"_id": bson.M{"$in": bookIds}, "deleted_at": nil})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var found []models.Book
+
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	for _, book := range found {
+		books[book.ID] = book
+	}
+
+	return books, nil
+}
+
+func (repo *mongoBookRepository) GetByIsbn(isbn13 string, ctx context.Context) (models.Book, error) {
+	var book models.Book
+
+	if isbn13 == "" {
+		return book, ErrNotFound
+	}
+
+	err := repo.collection.FindOne(ctx, bson.M{"isbn13": isbn13, "deleted_at": nil}).Decode(&book)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return book, ErrNotFound
+	}
+
+	return book, err
+}
+
+func (repo *mongoBookRepository) Insert(book models.Book, ctx context.Context) error {
+	book.Isbn13 = book.IsbnKey()
+	_, err := repo.collection.InsertOne(ctx, book)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+
+	return err
+}
+
+func (repo *mongoBookRepository) InsertMany(books []models.Book, ctx context.Context) ([]error, error) {
+	errs := make([]error, len(books))
+
+	if len(books) == 0 {
+		return errs, nil
+	}
+
+	documents := make([]any, len(books))
+
+	for i, book := range books {
+		book.Isbn13 = book.IsbnKey()
+		documents[i] = book
+	}
+
+	// unordered so one bad book does not stop the rest of the batch
+	_, err := repo.collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+
+	var bulkErr mongo.BulkWriteException
+
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if mongo.IsDuplicateKeyError(writeErr) {
+				errs[writeErr.Index] = ErrDuplicate
+			} else {
+				errs[writeErr.Index] = errors.New(writeErr.Message)
+			}
+		}
+
+		return errs, nil
+	}
+
+	return errs, err
+}
+
+// Filter a live book on _id and, unless AnyVersion, on the version. Books
+// stored before versioning have no version field and count as version 0.
+func versionFilter(bookId primitive.ObjectID, expectedVersion int) bson.M {
+	filter := bson.M{"_id": bookId, "deleted_at": nil}
+
+	if expectedVersion == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	} else if expectedVersion != AnyVersion {
+		filter["version"] = expectedVersion
+	}
+
+	return filter
+}
+
+// Tell a missing book apart from a version mismatch after a failed write
+func (repo *mongoBookRepository) missOrConflict(bookId primitive.ObjectID, ctx context.Context) error {
+	if _, err := repo.Get(bookId, ctx); err != nil {
+		return err
+	}
+
+	return ErrVersionConflict
+}
+
+func (repo *mongoBookRepository) Update(book models.Book, expectedVersion int, ctx context.Context) (models.Book, error) {
+	book.Isbn13 = book.IsbnKey()
+	fields, err := bson.Marshal(book)
+
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	var set bson.M
+
+	if err := bson.Unmarshal(fields, &set); err != nil {
+		return models.Book{}, err
+	}
+
+	// trash state only changes through Delete and Restore, stock through
+	// AdjustStock and TransferStock, the threshold through SetReorderThreshold
+	delete(set, "_id")
+	delete(set, "version")
+	delete(set, "stock_version")
+	delete(set, "deleted_at")
+	delete(set, "deleted_by")
+	delete(set, "stock")
+	delete(set, "reserved")
+	delete(set, "stock_by_location")
+	delete(set, "reorder_threshold")
+
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated models.Book
+	err = repo.collection.FindOneAndUpdate(ctx, versionFilter(book.ID, expectedVersion), update, updateOptions).Decode(&updated)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.Book{}, repo.missOrConflict(book.ID, ctx)
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		return models.Book{}, ErrDuplicate
+	}
+
+	return updated, err
+}
+
+func trashUpdate(deletedBy string, now time.Time) bson.M {
+	return bson.M{
+		"$set": bson.M{"deleted_at": now, "deleted_by": deletedBy, "updated_at": now},
+		"$inc": bson.M{"version": 1},
+	}
+}
+
+func (repo *mongoBookRepository) Delete(bookId primitive.ObjectID, expectedVersion int, deletedBy string, now time.Time, ctx context.Context) error {
+	result, err := repo.collection.UpdateOne(ctx, versionFilter(bookId, expectedVersion), trashUpdate(deletedBy, now))
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return repo.missOrConflict(bookId, ctx)
+	}
+
+	return nil
+}
+
+func (repo *mongoBookRepository) DeleteMany(bookIds []primitive.ObjectID, deletedBy string, now time.Time, ctx context.Context) (int64, error) {
+	filter := bson.M{"_id": bson.M{"$in": bookIds}, "deleted_at": nil}
+	result, err := repo.collection.UpdateMany(ctx, filter, trashUpdate(deletedBy, now))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+func (repo *mongoBookRepository) Restore(bookId primitive.ObjectID, now time.Time, ctx context.Context) (models.Book, models.Book, error) {
+	filter := bson.M{"_id": bookId, "deleted_at": bson.M{"$ne": nil}}
+	update := bson.M{
+		"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+		"$set":   bson.M{"updated_at": now},
+		"$inc":   bson.M{"version": 1},
+	}
+
+	var trashed models.Book
+	err := repo.collection.FindOneAndUpdate(ctx, filter, update).Decode(&trashed)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return trashed, trashed, ErrNotFound
+	}
+
+	if err != nil {
+		return trashed, trashed, err
+	}
+
+	// the update is fully known, so derive the result instead of reading it
+	restored := trashed
+	restored.DeletedAt = nil
+	restored.DeletedBy = ""
+	restored.UpdatedAt = now
+	restored.Version++
+
+	return trashed, restored, nil
+}
+
+func (repo *mongoBookRepository) Purge(deletedBefore time.Time, ctx context.Context) (int64, error) {
+	result, err := repo.collection.DeleteMany(ctx, bson.M{"deleted_at": bson.M{"$lte": deletedBefore}})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+// Stock not reserved by orders. Books stored before inventory have neither
+// field and count as out of stock.
+var availableExpr = bson.M{"$subtract": bson.A{
+	bson.M{"$ifNull": bson.A{"$stock", 0}},
+	bson.M{"$ifNull": bson.A{"$reserved", 0}},
+}}
+
+// Stock held at no location
+var unassignedExpr = bson.M{"$subtract": bson.A{
+	bson.M{"$ifNull": bson.A{"$stock", 0}},
+	bson.M{"$sum": bson.M{"$map": bson.M{
+		"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$stock_by_location", bson.M{}}}},
+		"in":    "$$this.v",
+	}}},
+}}
+
+// Condition that a location, or the unassigned stock, holds quantity copies
+func holdsExpr(locationId string, quantity int) bson.M {
+	if locationId == "" {
+		return bson.M{"$gte": bson.A{unassignedExpr, quantity}}
+	}
+
+	return bson.M{"$gte": bson.A{bson.M{"$ifNull": bson.A{"$stock_by_location." + locationId, 0}}, quantity}}
+}
+
+// Apply a stock update to a live book matching the extra conditions. A miss
+// on an existing book means the conditions did not hold.
+func (repo *mongoBookRepository) updateStock(bookId primitive.ObjectID, conditions bson.A, inc bson.M, now time.Time, ctx context.Context) (models.Book, error) {
+	filter := bson.M{"_id": bookId, "deleted_at": nil}
+
+	// the check and the write are one operation, so concurrent requests
+	// cannot both take the last copy
+	if len(conditions) > 0 {
+		filter["$expr"] = bson.M{"$and": conditions}
+	}
+
+	inc["stock_version"] = 1
+	update := bson.M{"$inc": inc, "$set": bson.M{"updated_at": now}}
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated models.Book
+	err := repo.collection.FindOneAndUpdate(ctx, filter, update, updateOptions).Decode(&updated)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := repo.Get(bookId, ctx); err != nil {
+			return updated, err
+		}
+
+		return updated, ErrInsufficientStock
+	}
+
+	return updated, err
+}
+
+func (repo *mongoBookRepository) AdjustStock(bookId primitive.ObjectID, locationId string, delta int, now time.Time, ctx context.Context) (models.Book, error) {
+	conditions := bson.A{}
+	inc := bson.M{"stock": delta}
+
+	if delta < 0 {
+		conditions = append(conditions, bson.M{"$gte": bson.A{availableExpr, -delta}}, holdsExpr(locationId, -delta))
+	}
+
+	if locationId != "" {
+		inc["stock_by_location."+locationId] = delta
+	}
+
+	return repo.updateStock(bookId, conditions, inc, now, ctx)
+}
+
+func (repo *mongoBookRepository) SetReorderThreshold(bookId primitive.ObjectID, threshold int, now time.Time, ctx context.Context) (models.Book, error) {
+	update := bson.M{
+		"$set": bson.M{"reorder_threshold": threshold, "updated_at": now},
+		"$inc": bson.M{"stock_version": 1},
+	}
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated models.Book
+	err := repo.collection.FindOneAndUpdate(ctx, bson.M{"_id": bookId, "deleted_at": nil}, update, updateOptions).Decode(&updated)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return updated, ErrNotFound
+	}
+
+	return updated, err
+}
+
+func (repo *mongoBookRepository) TransferStock(bookId primitive.ObjectID, from string, to string, quantity int, now time.Time, ctx context.Context) (models.Book, error) {
+	inc := bson.M{}
+
+	if from != "" {
+		inc["stock_by_location."+from] = -quantity
+	}
+
+	if to != "" {
+		inc["stock_by_location."+to] = quantity
+	}
+
+	return repo.updateStock(bookId, bson.A{holdsExpr(from, quantity)}, inc, now, ctx)
+}
+
+func (repo *mongoBookRepository) LowStock(ctx context.Context) ([]models.Book, error) {
+	books := []models.Book{}
+	filter := bson.M{
+		"deleted_at": nil,
+		"$expr":      bson.M{"$lte": bson.A{availableExpr, bson.M{"$ifNull": bson.A{"$reorder_threshold", 0}}}},
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{"available": availableExpr}}},
+		{{Key: "$sort", Value: bson.D{{Key: "available", Value: 1}, {Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := repo.collection.Aggregate(ctx, pipeline)
+
+	if err != nil {
+		return books, err
+	}
+
+	err = cursor.All(ctx, &books)
+	return books, err
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoBulkDeleteRepository struct {
+	confirmations *mongo.Collection
+	snapshots     *mongo.Collection
+}
+
+// A snapshot is stored as one document per book so that large deletes stay
+// below the MongoDB document size limit
+type snapshotEntry struct {
+	SnapshotID string      `bson:"snapshot_id"`
+	TakenAt    time.Time   `bson:"taken_at"`
+	TakenBy    string      `bson:"taken_by"`
+	Filter     string      `bson:"filter"`
+	Book       models.Book `bson:"book"`
+}
+
+func (repo *mongoBulkDeleteRepository) InsertConfirmation(confirmation models.BulkDeleteConfirmation, ctx context.Context) error {
+	_, err := repo.confirmations.InsertOne(ctx, confirmation)
+	return err
+}
+
+func (repo *mongoBulkDeleteRepository) UseConfirmation(hash string, ctx context.Context) (models.BulkDeleteConfirmation, error) {
+	var confirmation models.BulkDeleteConfirmation
+	err := repo.confirmations.FindOneAndDelete(ctx, bson.M{"_id": hash}).Decode(&confirmation)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return confirmation, ErrNotFound
+	}
+
+	return confirmation, err
+}
+
+func (repo *mongoBulkDeleteRepository) InsertSnapshot(snapshot models.BookSnapshot, ctx context.Context) error {
+	if len(snapshot.Books) == 0 {
+		return nil
+	}
+
+	entries := make([]any, 0, len(snapshot.Books))
+
+	for _, book := range snapshot.Books {
+		entries = append(entries, snapshotEntry{
+			SnapshotID: snapshot.ID,
+			TakenAt:    snapshot.TakenAt,
+			TakenBy:    snapshot.TakenBy,
+			Filter:     snapshot.Filter,
+			Book:       book,
+		})
+	}
+
+	_, err := repo.snapshots.InsertMany(ctx, entries)
+	return err
+}
+
+func (repo *mongoBulkDeleteRepository) GetSnapshot(snapshotId string, ctx context.Context) (models.BookSnapshot, error) {
+	snapshot := models.BookSnapshot{ID: snapshotId, Books: []models.Book{}}
+	cursor, err := repo.snapshots.Find(ctx, bson.M{"snapshot_id": snapshotId})
+
+	if err != nil {
+		return snapshot, err
+	}
+
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry snapshotEntry
+
+		if err = cursor.Decode(&entry); err != nil {
+			return snapshot, err
+		}
+
+		snapshot.TakenAt = entry.TakenAt
+		snapshot.TakenBy = entry.TakenBy
+		snapshot.Filter = entry.Filter
+		snapshot.Books = append(snapshot.Books, entry.Book)
+	}
+
+	if err = cursor.Err(); err != nil {
+		return snapshot, err
+	}
+
+	if len(snapshot.Books) == 0 {
+		return snapshot, ErrNotFound
+	}
+
+	return snapshot, nil
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoCartRepository struct {
+	collection *mongo.Collection
+}
+
+func (repo *mongoCartRepository) Get(owner string, now time.Time, ctx context.Context) (models.Cart, error) {
+	var cart models.Cart
+	// the TTL monitor only runs every minute, so filter out expired carts
+	err := repo.collection.FindOne(ctx, bson.M{"_id": owner, "expires_at": bson.M{"$gt": now}}).Decode(&cart)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return cart, ErrNotFound
+	}
+
+	return cart, err
+}
+
+// Match the cart of the owner at the version, carts saved before versions
+// were stored count as zero
+func cartFilter(owner string, expectedVersion int) bson.M {
+	filter := bson.M{"_id": owner}
+
+	switch expectedVersion {
+	case AnyVersion:
+	case 0:
+		filter["version"] = nil
+	default:
+		filter["version"] = expectedVersion
+	}
+
+	return filter
+}
+
+func (repo *mongoCartRepository) Save(cart models.Cart, expectedVersion int, ctx context.Context) (models.Cart, error) {
+	filter := cartFilter(cart.Owner, expectedVersion)
+
+	// an expired cart the TTL monitor has not removed yet is no cart
+	if expectedVersion == 0 {
+		filter = bson.M{"_id": cart.Owner, "$or": bson.A{
+			bson.M{"version": nil},
+			bson.M{"expires_at": bson.M{"$lte": cart.UpdatedAt}},
+		}}
+	}
+
+	cart.Version = expectedVersion + 1
+	// a first save inserts, and fails on the key if another one did first
+	result, err := repo.collection.ReplaceOne(ctx, filter, cart, options.Replace().SetUpsert(expectedVersion == 0))
+
+	if mongo.IsDuplicateKeyError(err) {
+		return models.Cart{}, ErrVersionConflict
+	}
+
+	if err != nil {
+		return models.Cart{}, err
+	}
+
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return models.Cart{}, ErrVersionConflict
+	}
+
+	return cart, nil
+}
+
+func (repo *mongoCartRepository) Delete(owner string, expectedVersion int, ctx context.Context) error {
+	result, err := repo.collection.DeleteOne(ctx, cartFilter(owner, expectedVersion))
+
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 && expectedVersion != AnyVersion {
+		return ErrVersionConflict
+	}
+
+	return nil
+}
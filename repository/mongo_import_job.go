@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoImportJobRepository struct {
+	collection *mongo.Collection
+}
+
+func (repo *mongoImportJobRepository) Insert(job models.ImportJob, ctx context.Context) error {
+	_, err := repo.collection.InsertOne(ctx, job)
+	return err
+}
+
+func (repo *mongoImportJobRepository) Update(job models.ImportJob, ctx context.Context) error {
+	result, err := repo.collection.ReplaceOne(ctx, bson.M{"_id": job.ID}, job)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *mongoImportJobRepository) Get(jobId string, ctx context.Context) (models.ImportJob, error) {
+	var job models.ImportJob
+	err := repo.collection.FindOne(ctx, bson.M{"_id": jobId}).Decode(&job)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return job, ErrNotFound
+	}
+
+	return job, err
+}
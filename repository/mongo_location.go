@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoLocationRepository struct {
+	collection *mongo.Collection
+}
+
+func (repo *mongoLocationRepository) Insert(location models.Location, ctx context.Context) error {
+	_, err := repo.collection.InsertOne(ctx, location)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+
+	return err
+}
+
+func (repo *mongoLocationRepository) Get(locationId primitive.ObjectID, ctx context.Context) (models.Location, error) {
+	var location models.Location
+	err := repo.collection.FindOne(ctx, bson.M{"_id": locationId}).Decode(&location)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return location, ErrNotFound
+	}
+
+	return location, err
+}
+
+func (repo *mongoLocationRepository) List(ctx context.Context) ([]models.Location, error) {
+	locations := []models.Location{}
+	cursor, err := repo.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "code", Value: 1}}))
+
+	if err != nil {
+		return locations, err
+	}
+
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &locations); err != nil {
+		return locations, err
+	}
+
+	return locations, nil
+}
+
+type mongoMovementRepository struct {
+	collection *mongo.Collection
+}
+
+func (repo *mongoMovementRepository) Append(movement models.StockMovement, ctx context.Context) error {
+	_, err := repo.collection.InsertOne(ctx, movement)
+	return err
+}
+
+func (repo *mongoMovementRepository) List(bookId primitive.ObjectID, ctx context.Context) ([]models.StockMovement, error) {
+	movements := []models.StockMovement{}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := repo.collection.Find(ctx, bson.M{"book_id": bookId}, findOptions)
+
+	if err != nil {
+		return movements, err
+	}
+
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &movements); err != nil {
+		return movements, err
+	}
+
+	return movements, nil
+}
@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Orders and book stock are written in one transaction, which needs MongoDB
+// to run as a replica set
+type mongoOrderRepository struct {
+	collection *mongo.Collection
+	books      *mongo.Collection
+}
+
+// Run fn in a transaction, retried by the driver on transient errors
+func (repo *mongoOrderRepository) transaction(ctx context.Context, fn func(mongo.SessionContext) (any, error)) (any, error) {
+	session, err := repo.collection.Database().Client().StartSession()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer session.EndSession(ctx)
+	return session.WithTransaction(ctx, fn)
+}
+
+// Update the stock of a book of the order, or fail with the reason the
+// conditions did not hold. Like every stock write it counts in the stock
+// version, the catalog version and its revisions are left alone.
+func (repo *mongoOrderRepository) updateBook(ctx mongo.SessionContext, filter bson.M, inc bson.M, now time.Time) error {
+	inc["stock_version"] = 1
+	result, err := repo.books.UpdateOne(ctx, filter, bson.M{"$inc": inc, "$set": bson.M{"updated_at": now}})
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	count, err := repo.books.CountDocuments(ctx, bson.M{"_id": filter["_id"], "deleted_at": nil})
+
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrNotFound
+	}
+
+	return ErrInsufficientStock
+}
+
+func (repo *mongoOrderRepository) Place(order models.Order, ctx context.Context) error {
+	_, err := repo.transaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		for _, line := range order.Lines {
+			filter := bson.M{
+				"_id":        line.BookID,
+				"deleted_at": nil,
+				"$expr":      bson.M{"$gte": bson.A{availableExpr, line.Quantity}},
+			}
+
+			if err := repo.updateBook(sc, filter, bson.M{"reserved": line.Quantity}, order.CreatedAt); err != nil {
+				return nil, err
+			}
+		}
+
+		_, err := repo.collection.InsertOne(sc, order)
+		return nil, err
+	})
+
+	// the transaction is aborted, so no copies stay reserved
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+
+	return err
+}
+
+func (repo *mongoOrderRepository) Get(orderId primitive.ObjectID, ctx context.Context) (models.Order, error) {
+	var order models.Order
+	err := repo.collection.FindOne(ctx, bson.M{"_id": orderId}).Decode(&order)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return order, ErrNotFound
+	}
+
+	return order, err
+}
+
+func (repo *mongoOrderRepository) List(owner string, ctx context.Context) ([]models.Order, error) {
+	orders := []models.Order{}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := repo.collection.Find(ctx, bson.M{"owner": owner}, findOptions)
+
+	if err != nil {
+		return orders, err
+	}
+
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &orders); err != nil {
+		return orders, err
+	}
+
+	return orders, nil
+}
+
+// Match the payment call in flight, a missing one when empty
+func settlingFilter(settling string) any {
+	if settling == "" {
+		return nil
+	}
+
+	return settling
+}
+
+func (repo *mongoOrderRepository) Transition(orderId primitive.ObjectID, move models.OrderMove, ctx context.Context) (models.Order, error) {
+	event := move.Event
+
+	updated, err := repo.transaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		set := bson.M{"status": event.Status, "updated_at": event.At}
+
+		if move.Payment != nil {
+			set["payment"] = move.Payment
+		}
+
+		filter := bson.M{"_id": orderId, "status": move.From, "payment.settling": settlingFilter(move.Settling)}
+		update := bson.M{"$set": set, "$push": bson.M{"history": event}}
+		updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var order models.Order
+		err := repo.collection.FindOneAndUpdate(sc, filter, update, updateOptions).Decode(&order)
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if _, err := repo.Get(orderId, sc); err != nil {
+				return nil, err
+			}
+
+			return nil, ErrVersionConflict
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// books deleted since checkout still hand back or ship their copies
+		for _, line := range order.Lines {
+			var err error
+
+			switch move.Stock {
+			case models.StockRelease:
+				err = repo.updateBook(sc, bson.M{"_id": line.BookID}, bson.M{"reserved": -line.Quantity}, event.At)
+
+				// books purged since checkout have no copies left to hand back
+				if errors.Is(err, ErrNotFound) {
+					err = nil
+				}
+			case models.StockCommit:
+				inc := bson.M{"stock": -line.Quantity, "reserved": -line.Quantity}
+
+				if move.LocationID != "" {
+					inc["stock_by_location."+move.LocationID] = -line.Quantity
+				}
+
+				filter := bson.M{"_id": line.BookID, "$expr": holdsExpr(move.LocationID, line.Quantity)}
+				err = repo.updateBook(sc, filter, inc, event.At)
+
+				// a deleted book has nothing left to ship from
+				if errors.Is(err, ErrNotFound) {
+					err = ErrInsufficientStock
+				}
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return order, nil
+	})
+
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	return updated.(models.Order), nil
+}
+
+func (repo *mongoOrderRepository) SetPayment(orderId primitive.ObjectID, from models.OrderPayment, payment models.OrderPayment, now time.Time, ctx context.Context) (models.Order, error) {
+	var order models.Order
+	filter := bson.M{"_id": orderId, "payment.status": from.Status, "payment.settling": settlingFilter(from.Settling)}
+	update := bson.M{"$set": bson.M{"payment": payment, "updated_at": now}}
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := repo.collection.FindOneAndUpdate(ctx, filter, update, updateOptions).Decode(&order)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := repo.Get(orderId, ctx); err != nil {
+			return order, err
+		}
+
+		return order, ErrVersionConflict
+	}
+
+	return order, err
+}
+
+func (repo *mongoOrderRepository) Settling(claimedBefore time.Time, ctx context.Context) ([]models.Order, error) {
+	orders := []models.Order{}
+	filter := bson.M{"payment.settling": bson.M{"$exists": true}, "updated_at": bson.M{"$lte": claimedBefore}}
+	cursor, err := repo.collection.Find(ctx, filter)
+
+	if err != nil {
+		return orders, err
+	}
+
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &orders); err != nil {
+		return orders, err
+	}
+
+	return orders, nil
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoRevisionRepository struct {
+	collection *mongo.Collection
+}
+
+func (repo *mongoRevisionRepository) Append(revisions []models.BookRevision, ctx context.Context) error {
+	if len(revisions) == 0 {
+		return nil
+	}
+
+	documents := make([]any, len(revisions))
+
+	for i, revision := range revisions {
+		documents[i] = revision
+	}
+
+	_, err := repo.collection.InsertMany(ctx, documents)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+
+	return err
+}
+
+func (repo *mongoRevisionRepository) List(bookId primitive.ObjectID, ctx context.Context) ([]models.BookRevision, error) {
+	revisions := []models.BookRevision{}
+	findOptions := options.Find().SetSort(bson.D{{Key: "version", Value: 1}})
+	cursor, err := repo.collection.Find(ctx, bson.M{"book_id": bookId}, findOptions)
+
+	if err != nil {
+		return revisions, err
+	}
+
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &revisions); err != nil {
+		return revisions, err
+	}
+
+	return revisions, nil
+}
+
+func (repo *mongoRevisionRepository) Get(bookId primitive.ObjectID, version int, ctx context.Context) (models.BookRevision, error) {
+	var revision models.BookRevision
+	err := repo.collection.FindOne(ctx, bson.M{"book_id": bookId, "version": version}).Decode(&revision)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return revision, ErrNotFound
+	}
+
+	return revision, err
+}
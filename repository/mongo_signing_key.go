@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoSigningKeyRepository struct {
+	collection *mongo.Collection
+}
+
+func (repo *mongoSigningKeyRepository) List(ctx context.Context) ([]models.SigningKey, error) {
+	keys := []models.SigningKey{}
+	cursor, err := repo.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "active_at", Value: 1}}))
+
+	if err != nil {
+		return keys, err
+	}
+
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &keys); err != nil {
+		return keys, err
+	}
+
+	return keys, nil
+}
+
+func (repo *mongoSigningKeyRepository) Insert(key models.SigningKey, ctx context.Context) error {
+	_, err := repo.collection.InsertOne(ctx, key)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+
+	return err
+}
+
+func (repo *mongoSigningKeyRepository) Prune(keyId string, now time.Time, ctx context.Context) error {
+	update := bson.M{"$set": bson.M{"sealed_key": "", "pruned_at": now}}
+	_, err := repo.collection.UpdateOne(ctx, bson.M{"_id": keyId}, update)
+	return err
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Run the test against the in-memory store, and against MongoDB as well
+// when MONGO_TEST_URL points at a replica set. Each MongoDB run gets a
+// database of its own, dropped afterwards.
+func forEachBackend(t *testing.T, test func(t *testing.T, books BookRepository, orders OrderRepository)) {
+	t.Run("memory", func(t *testing.T) {
+		books := NewMemoryBookRepository()
+		test(t, books, NewMemoryOrderRepository(books))
+	})
+
+	t.Run("mongo", func(t *testing.T) {
+		ctx := context.Background()
+		database := testDatabase(t)
+
+		// the unique ISBN index, as db.Init builds it
+		books := database.Collection("books")
+		_, err := books.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "isbn13", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"isbn13": bson.M{"$gt": ""}}),
+		})
+
+		if err != nil {
+			t.Fatalf("create index: %v", err)
+		}
+
+		test(t, &mongoBookRepository{collection: books}, &mongoOrderRepository{collection: database.Collection("orders"), books: books})
+	})
+}
+
+// A MongoDB database of its own for the test, dropped afterwards. The test
+// is skipped unless MONGO_TEST_URL points at a replica set.
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	url := os.Getenv("MONGO_TEST_URL")
+
+	if url == "" {
+		t.Skip("MONGO_TEST_URL not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	database := client.Database("bookstore_test_" + primitive.NewObjectID().Hex())
+
+	t.Cleanup(func() {
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+
+	return database
+}
+
+func placeOrder(t *testing.T, books BookRepository, orders OrderRepository, quantity int) (models.Book, models.Order) {
+	t.Helper()
+
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	book := models.Book{ID: primitive.NewObjectID(), Title: "Go", Author: "Someone", Stock: 5, Version: 1}
+
+	if err := books.Insert(book, ctx); err != nil {
+		t.Fatalf("insert book: %v", err)
+	}
+
+	order := models.Order{
+		ID:        primitive.NewObjectID(),
+		Owner:     "reader",
+		Status:    models.OrderPending,
+		Lines:     []models.OrderLine{{BookID: book.ID, Title: book.Title, Quantity: quantity}},
+		History:   []models.OrderEvent{{Status: models.OrderPending, Actor: "reader", At: now}},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := orders.Place(order, ctx); err != nil {
+		t.Fatalf("place: %v", err)
+	}
+
+	return book, order
+}
+
+func TestPlaceDuplicateOrder(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, books BookRepository, orders OrderRepository) {
+		ctx := context.Background()
+		book, order := placeOrder(t, books, orders, 2)
+
+		if err := orders.Place(order, ctx); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("expected a duplicate, got %v", err)
+		}
+
+		if stored, _ := books.Get(book.ID, ctx); stored.Reserved != 2 {
+			t.Fatalf("the duplicate order reserved copies, %d reserved", stored.Reserved)
+		}
+	})
+}
+
+// Orders move stock, not the catalog, so the book keeps the version its
+// revisions and ETag are keyed by
+func TestOrdersKeepCatalogVersion(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, books BookRepository, orders OrderRepository) {
+		ctx := context.Background()
+		book, order := placeOrder(t, books, orders, 2)
+		event := models.OrderEvent{Status: models.OrderCancelled, Actor: "reader", At: time.Now().UTC()}
+
+		if _, err := orders.Transition(order.ID, models.OrderMove{From: models.OrderPending, Event: event, Stock: models.StockRelease}, ctx); err != nil {
+			t.Fatalf("cancel: %v", err)
+		}
+
+		stored, _ := books.Get(book.ID, ctx)
+
+		if stored.Version != book.Version || stored.StockVersion != book.StockVersion+2 || stored.Reserved != 0 {
+			t.Fatalf("expected version %d with two stock writes, got %d.%d", book.Version, stored.Version, stored.StockVersion)
+		}
+	})
+}
+
+func TestCancelOrderOfPurgedBook(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, books BookRepository, orders OrderRepository) {
+		ctx := context.Background()
+		book, order := placeOrder(t, books, orders, 2)
+		now := time.Now().UTC()
+
+		if err := books.Delete(book.ID, AnyVersion, "admin", now, ctx); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+
+		if purged, err := books.Purge(now.Add(time.Second), ctx); err != nil || purged != 1 {
+			t.Fatalf("expected the book purged, got %d, %v", purged, err)
+		}
+
+		event := models.OrderEvent{Status: models.OrderCancelled, Actor: "reader", At: now}
+		cancelled, err := orders.Transition(order.ID, models.OrderMove{From: models.OrderPending, Event: event, Stock: models.StockRelease}, ctx)
+
+		if err != nil || cancelled.Status != models.OrderCancelled {
+			t.Fatalf("expected the order cancelled, got %+v, %v", cancelled, err)
+		}
+
+		// there is nothing left to ship
+		_, order = placeOrder(t, books, orders, 1)
+		paid := models.OrderEvent{Status: models.OrderPaid, Actor: "admin", At: now}
+
+		if _, err := orders.Transition(order.ID, models.OrderMove{From: models.OrderPending, Event: paid}, ctx); err != nil {
+			t.Fatalf("pay: %v", err)
+		}
+
+		if err := books.Delete(order.Lines[0].BookID, AnyVersion, "admin", now, ctx); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+
+		books.Purge(now.Add(time.Second), ctx)
+		shipped := models.OrderEvent{Status: models.OrderShipped, Actor: "admin", At: now}
+
+		if _, err := orders.Transition(order.ID, models.OrderMove{From: models.OrderPaid, Event: shipped, Stock: models.StockCommit}, ctx); !errors.Is(err, ErrInsufficientStock) {
+			t.Fatalf("expected shipping a purged book to fail, got %v", err)
+		}
+	})
+}
+
+func TestPaymentClaim(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, books BookRepository, orders OrderRepository) {
+		ctx := context.Background()
+		_, order := placeOrder(t, books, orders, 1)
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		authorized := models.OrderPayment{ID: "pay_1", Status: "authorized", Amount: 100}
+
+		if _, err := orders.SetPayment(order.ID, authorized, authorized, now, ctx); !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("expected a conflict on an order without a payment, got %v", err)
+		}
+
+		claimed := authorized
+		claimed.Settling = models.SettleCapture
+		paid := models.OrderEvent{Status: models.OrderPaid, Actor: "admin", At: now}
+
+		if _, err := orders.Transition(order.ID, models.OrderMove{From: models.OrderPending, Event: paid, Payment: &claimed}, ctx); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+
+		// nothing else moves the order while the capture is in flight
+		shipped := models.OrderEvent{Status: models.OrderShipped, Actor: "admin", At: now}
+
+		if _, err := orders.Transition(order.ID, models.OrderMove{From: models.OrderPaid, Event: shipped}, ctx); !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("expected a conflict while settling, got %v", err)
+		}
+
+		if settling, err := orders.Settling(now, ctx); err != nil || len(settling) != 1 || settling[0].ID != order.ID {
+			t.Fatalf("expected the claimed order, got %+v, %v", settling, err)
+		}
+
+		if settling, _ := orders.Settling(now.Add(-time.Minute), ctx); len(settling) != 0 {
+			t.Fatalf("a recent claim is listed as stale: %+v", settling)
+		}
+
+		captured := models.OrderPayment{ID: "pay_1", Status: "captured", Amount: 100}
+
+		if _, err := orders.SetPayment(order.ID, authorized, captured, now, ctx); !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("expected a conflict without the claim, got %v", err)
+		}
+
+		stored, err := orders.SetPayment(order.ID, claimed, captured, now, ctx)
+
+		if err != nil || stored.Payment.Status != "captured" || stored.Payment.Settling != "" {
+			t.Fatalf("expected the capture stored, got %+v, %v", stored.Payment, err)
+		}
+
+		if settling, _ := orders.Settling(now, ctx); len(settling) != 0 {
+			t.Fatalf("a settled order is still listed: %+v", settling)
+		}
+
+		if _, err := orders.Transition(order.ID, models.OrderMove{From: models.OrderPaid, Event: shipped}, ctx); err != nil {
+			t.Fatalf("ship: %v", err)
+		}
+	})
+}
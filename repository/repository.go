@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/db"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNotFound        = errors.New("no data found")
+	ErrDuplicate       = errors.New("duplicate data")
+	ErrAlreadyUsed     = errors.New("already used")
+	ErrVersionConflict = errors.New("version does not match")
+	// ErrInsufficientStock is returned by stock writes that would take more
+	// copies than are available
+	ErrInsufficientStock = errors.New("not enough stock")
+)
+
+// AnyVersion skips the optimistic concurrency check on writes
+const AnyVersion = -1
+
+// BookRepository stores the catalog
+type BookRepository interface {
+	// List returns the books matching the query and the total number of
+	// matches ignoring the cursor and limit. A zero limit returns every book.
+	List(query models.BookQuery, ctx context.Context) ([]models.Book, int64, error)
+	// Stream calls fn for each book of the query in order without buffering
+	// them, stopping at the first error
+	Stream(query models.BookQuery, fn func(models.Book) error, ctx context.Context) error
+	Get(bookId primitive.ObjectID, ctx context.Context) (models.Book, error)
+	// GetMany returns the live books among the given ones by id, leaving
+	// out those that do not exist or are in the trash
+	GetMany(bookIds []primitive.ObjectID, ctx context.Context) (map[primitive.ObjectID]models.Book, error)
+	// GetByIsbn returns the live book with the given ISBN-13 digits
+	GetByIsbn(isbn13 string, ctx context.Context) (models.Book, error)
+	Insert(book models.Book, ctx context.Context) error
+	// InsertMany inserts the books independently of each other. The returned
+	// slice holds the error of each book, nil for the inserted ones; the
+	// error is set only if the batch as a whole failed.
+	InsertMany(books []models.Book, ctx context.Context) ([]error, error)
+	// Update replaces the book if its stored version equals expectedVersion
+	// (or unconditionally for AnyVersion), increments the version and returns
+	// the stored result. A mismatch returns ErrVersionConflict.
+	Update(book models.Book, expectedVersion int, ctx context.Context) (models.Book, error)
+	// Delete moves the book to the trash, recording who deleted it and when.
+	// Books in the trash are hidden from List (unless query.Trash), Get and
+	// Update.
+	Delete(bookId primitive.ObjectID, expectedVersion int, deletedBy string, now time.Time, ctx context.Context) error
+	// DeleteMany moves the given live books to the trash and returns how many
+	// it moved
+	DeleteMany(bookIds []primitive.ObjectID, deletedBy string, now time.Time, ctx context.Context) (int64, error)
+	// Restore takes a book out of the trash and returns it as it was in the
+	// trash and as restored, ErrNotFound if it is not there
+	Restore(bookId primitive.ObjectID, now time.Time, ctx context.Context) (models.Book, models.Book, error)
+	// Purge permanently removes the books deleted before the given time
+	Purge(deletedBefore time.Time, ctx context.Context) (int64, error)
+	// AdjustStock atomically adds delta to the stock of a live book, at the
+	// location or to the unassigned stock when locationId is empty, and
+	// returns the result. Taking more than the unreserved stock or than the
+	// location holds returns ErrInsufficientStock and changes nothing.
+	AdjustStock(bookId primitive.ObjectID, locationId string, delta int, now time.Time, ctx context.Context) (models.Book, error)
+	// SetReorderThreshold changes the reorder threshold of a live book. It is
+	// the only write changing it, Update keeps the stored one.
+	SetReorderThreshold(bookId primitive.ObjectID, threshold int, now time.Time, ctx context.Context) (models.Book, error)
+	// TransferStock atomically moves copies of a live book between two
+	// locations, an empty one being the unassigned stock. Moving more than
+	// the source holds returns ErrInsufficientStock.
+	TransferStock(bookId primitive.ObjectID, from string, to string, quantity int, now time.Time, ctx context.Context) (models.Book, error)
+	// LowStock returns the live books whose unreserved stock is at or below
+	// their reorder threshold, lowest stock first
+	LowStock(ctx context.Context) ([]models.Book, error)
+}
+
+// BulkDeleteRepository stores the dry run confirmations and the snapshots
+// guarding bulk deletes
+type BulkDeleteRepository interface {
+	InsertConfirmation(confirmation models.BulkDeleteConfirmation, ctx context.Context) error
+	// UseConfirmation removes the confirmation and returns it, so a token
+	// can only be used once
+	UseConfirmation(hash string, ctx context.Context) (models.BulkDeleteConfirmation, error)
+	InsertSnapshot(snapshot models.BookSnapshot, ctx context.Context) error
+	GetSnapshot(snapshotId string, ctx context.Context) (models.BookSnapshot, error)
+}
+
+// AuditRepository is the append-only log of catalog writes
+type AuditRepository interface {
+	Append(entries []models.AuditEntry, ctx context.Context) error
+	// List returns the entries matching the query, newest first
+	List(query models.AuditQuery, ctx context.Context) ([]models.AuditEntry, error)
+}
+
+// RevisionRepository keeps every version of every book
+type RevisionRepository interface {
+	Append(revisions []models.BookRevision, ctx context.Context) error
+	// List returns the revisions of a book, oldest first
+	List(bookId primitive.ObjectID, ctx context.Context) ([]models.BookRevision, error)
+	Get(bookId primitive.ObjectID, version int, ctx context.Context) (models.BookRevision, error)
+}
+
+// ImportJobRepository tracks background bulk imports
+type ImportJobRepository interface {
+	Insert(job models.ImportJob, ctx context.Context) error
+	Update(job models.ImportJob, ctx context.Context) error
+	Get(jobId string, ctx context.Context) (models.ImportJob, error)
+}
+
+// LocationRepository stores the warehouses and stores
+type LocationRepository interface {
+	// Insert returns ErrDuplicate when the code is taken
+	Insert(location models.Location, ctx context.Context) error
+	Get(locationId primitive.ObjectID, ctx context.Context) (models.Location, error)
+	// List returns every location ordered by code
+	List(ctx context.Context) ([]models.Location, error)
+}
+
+// MovementRepository is the append-only log of stock movements
+type MovementRepository interface {
+	Append(movement models.StockMovement, ctx context.Context) error
+	// List returns the movements of a book, newest first
+	List(bookId primitive.ObjectID, ctx context.Context) ([]models.StockMovement, error)
+}
+
+// CartRepository stores one cart per user
+type CartRepository interface {
+	// Get returns the cart of the user, ErrNotFound when there is none or
+	// it expired before now
+	Get(owner string, now time.Time, ctx context.Context) (models.Cart, error)
+	// Save creates or replaces the cart of its owner if the stored cart is
+	// still at expectedVersion, zero when the owner has none, and returns it
+	// with its new version. A mismatch returns ErrVersionConflict.
+	Save(cart models.Cart, expectedVersion int, ctx context.Context) (models.Cart, error)
+	// Delete removes the cart if it is still at expectedVersion, or whatever
+	// its version for AnyVersion
+	Delete(owner string, expectedVersion int, ctx context.Context) error
+}
+
+// OrderRepository stores orders. Each write changes the order and the stock
+// of its books together or not at all.
+type OrderRepository interface {
+	// Place reserves the copies of every line and inserts the order. A line
+	// short of available stock returns ErrInsufficientStock, a book no
+	// longer in the catalog ErrNotFound.
+	Place(order models.Order, ctx context.Context) error
+	Get(orderId primitive.ObjectID, ctx context.Context) (models.Order, error)
+	// List returns the orders of the owner, newest first
+	List(owner string, ctx context.Context) ([]models.Order, error)
+	// Transition moves the order from move.From to the state of the event
+	// and applies the stock effect to its lines, replacing the payment in
+	// the same write when one is given. An order no longer in move.From, or
+	// whose payment call in flight is not move.Settling, returns
+	// ErrVersionConflict; a location short of copies ErrInsufficientStock.
+	Transition(orderId primitive.ObjectID, move models.OrderMove, ctx context.Context) (models.Order, error)
+	// SetPayment replaces the payment of the order if its status and call in
+	// flight are still those of from, ErrVersionConflict otherwise
+	SetPayment(orderId primitive.ObjectID, from models.OrderPayment, payment models.OrderPayment, now time.Time, ctx context.Context) (models.Order, error)
+	// Settling returns the orders with a payment call claimed at or before
+	// the given time and never confirmed
+	Settling(claimedBefore time.Time, ctx context.Context) ([]models.Order, error)
+}
+
+// UserRepository stores user accounts
+type UserRepository interface {
+	FindByName(name string, ctx context.Context) (models.User, error)
+	Insert(user models.User, ctx context.Context) error
+}
+
+// TokenRepository stores refresh tokens and revoked access tokens
+type TokenRepository interface {
+	InsertRefresh(token models.RefreshToken, ctx context.Context) error
+	FindRefresh(hash string, ctx context.Context) (models.RefreshToken, error)
+	// UseRefresh marks the refresh token as used. It returns ErrAlreadyUsed
+	// together with the stored token when it was used before.
+	UseRefresh(hash string, now time.Time, ctx context.Context) (models.RefreshToken, error)
+	RevokeFamily(family string, ctx context.Context) (int64, error)
+	Revoke(tokenID string, expiresAt time.Time, ctx context.Context) error
+	IsRevoked(tokenID string, ctx context.Context) (bool, error)
+}
+
+// SigningKeyRepository shares the JWT signing keys between instances
+type SigningKeyRepository interface {
+	// List returns every key, pruned ones included, by activation time
+	List(ctx context.Context) ([]models.SigningKey, error)
+	// Insert returns ErrDuplicate when the kid is taken or another key
+	// already replaced key.Previous
+	Insert(key models.SigningKey, ctx context.Context) error
+	// Prune forgets the private key of the key but keeps its kid taken
+	Prune(keyId string, now time.Time, ctx context.Context) error
+}
+
+var Books BookRepository
+var Users UserRepository
+var Tokens TokenRepository
+var BulkDeletes BulkDeleteRepository
+var Audit AuditRepository
+var Revisions RevisionRepository
+var ImportJobs ImportJobRepository
+var Locations LocationRepository
+var Movements MovementRepository
+var Carts CartRepository
+var Orders OrderRepository
+var SigningKeys SigningKeyRepository
+
+// UseMongo backs every repository with the collections opened by db.Init
+func UseMongo() {
+	Books = &mongoBookRepository{collection: db.Collection}
+	Users = &mongoUserRepository{collection: db.UserCollection}
+	Tokens = &mongoTokenRepository{
+		refreshTokens: db.RefreshTokenCollection,
+		revokedTokens: db.RevokedTokenCollection,
+	}
+	BulkDeletes = &mongoBulkDeleteRepository{
+		confirmations: db.BulkDeleteConfirmationCollection,
+		snapshots:     db.SnapshotCollection,
+	}
+	Audit = &mongoAuditRepository{collection: db.AuditCollection}
+	Revisions = &mongoRevisionRepository{collection: db.RevisionCollection}
+	ImportJobs = &mongoImportJobRepository{collection: db.ImportJobCollection}
+	Locations = &mongoLocationRepository{collection: db.LocationCollection}
+	Movements = &mongoMovementRepository{collection: db.MovementCollection}
+	Carts = &mongoCartRepository{collection: db.CartCollection}
+	Orders = &mongoOrderRepository{collection: db.OrderCollection, books: db.Collection}
+	SigningKeys = &mongoSigningKeyRepository{collection: db.SigningKeyCollection}
+}
+
+// UseMemory backs every repository with process memory. Data is lost on
+// restart; meant for development and tests.
+func UseMemory() {
+	books := NewMemoryBookRepository()
+	Books = books
+	Users = NewMemoryUserRepository()
+	Tokens = NewMemoryTokenRepository()
+	BulkDeletes = NewMemoryBulkDeleteRepository()
+	Audit = NewMemoryAuditRepository()
+	Revisions = NewMemoryRevisionRepository()
+	ImportJobs = NewMemoryImportJobRepository()
+	Locations = NewMemoryLocationRepository()
+	Movements = NewMemoryMovementRepository()
+	Carts = NewMemoryCartRepository()
+	Orders = NewMemoryOrderRepository(books)
+	SigningKeys = NewMemorySigningKeyRepository()
+}
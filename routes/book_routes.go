@@ -13,35 +13,218 @@ func RegisterBook(router *mux.Router) {
 	// Health check
 	router.HandleFunc("/health", controllers.ServeHome).Methods("GET")
 
-	// Auth token
+	// Auth
+	router.HandleFunc("/register", controllers.Register).Methods("POST")
 	router.HandleFunc("/token", controllers.GenerateToken).Methods("POST")
+	router.HandleFunc("/login", controllers.GenerateToken).Methods("POST")
+	router.HandleFunc("/token/refresh", controllers.RefreshToken).Methods("POST")
+	router.HandleFunc("/.well-known/jwks.json", controllers.JWKS).Methods("GET")
+	router.Handle("/logout", middlewares.Chain(
+		http.HandlerFunc(controllers.Logout),
+		middlewares.AuthMiddleware),
+	).Methods("POST")
 
 	// bookstore CRUD
 	router.Handle("/books", middlewares.Chain(
 		http.HandlerFunc(controllers.GetAllBooks),
 		middlewares.AuthMiddleware),
 	).Methods("GET")
+	router.Handle("/books/isbn/{isbn}", middlewares.Chain(
+		http.HandlerFunc(controllers.GetBookByIsbn),
+		middlewares.AuthMiddleware),
+	).Methods("GET")
+	router.Handle("/books/export", middlewares.Chain(
+		http.HandlerFunc(controllers.ExportBooks),
+		middlewares.AuthMiddleware),
+	).Methods("GET")
+	router.Handle("/books/search", middlewares.Chain(
+		http.HandlerFunc(controllers.SearchBooks),
+		middlewares.AuthMiddleware),
+	).Methods("GET")
 	router.Handle("/book", middlewares.Chain(
 		http.HandlerFunc(controllers.CreateBook),
 		middlewares.AuthMiddleware,
 		middlewares.RoleMiddleware("admin")),
 	).Methods("POST")
+	router.Handle("/book/{id}", middlewares.Chain(
+		http.HandlerFunc(controllers.GetBook),
+		middlewares.AuthMiddleware),
+	).Methods("GET")
 	router.Handle("/book/{id}", middlewares.Chain(
 		http.HandlerFunc(controllers.UpdateBook),
 		middlewares.AuthMiddleware,
 		middlewares.RoleMiddleware("admin")),
 	).Methods("PUT")
+	router.Handle("/book/{id}", middlewares.Chain(
+		http.HandlerFunc(controllers.PatchBook),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("PATCH")
 	router.Handle("/book/{id}", middlewares.Chain(
 		http.HandlerFunc(controllers.DeleteBook),
 		middlewares.AuthMiddleware,
 		middlewares.RoleMiddleware("admin")),
 	).Methods("DELETE")
 	router.Handle("/books", middlewares.Chain(
-		http.HandlerFunc(controllers.DeleteAllBooks),
+		http.HandlerFunc(controllers.BulkDeleteBooks),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("DELETE")
+	router.Handle("/books/snapshots/{id}", middlewares.Chain(
+		http.HandlerFunc(controllers.GetSnapshot),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("GET")
+
+	router.Handle("/book/{id}/history", middlewares.Chain(
+		http.HandlerFunc(controllers.GetBookHistory),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("GET")
+	router.Handle("/book/{id}/rollback", middlewares.Chain(
+		http.HandlerFunc(controllers.RollbackBook),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("POST")
+
+	// import
+	router.Handle("/books/onix", middlewares.Chain(
+		http.HandlerFunc(controllers.ExportOnix),
+		middlewares.AuthMiddleware),
+	).Methods("GET")
+	router.Handle("/books/onix", middlewares.Chain(
+		http.HandlerFunc(controllers.ImportOnix),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("POST")
+	router.Handle("/books/import", middlewares.Chain(
+		http.HandlerFunc(controllers.ImportBooks),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("POST")
+	router.Handle("/books/import/jobs/{id}", middlewares.Chain(
+		http.HandlerFunc(controllers.GetImportJob),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("GET")
+
+	// trash
+	router.Handle("/books/trash", middlewares.Chain(
+		http.HandlerFunc(controllers.GetTrash),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("GET")
+	router.Handle("/book/{id}/restore", middlewares.Chain(
+		http.HandlerFunc(controllers.RestoreBook),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("POST")
+
+	// inventory
+	router.Handle("/book/{id}/stock/receive", middlewares.Chain(
+		http.HandlerFunc(controllers.ReceiveStock),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("POST")
+	router.Handle("/book/{id}/stock/sell", middlewares.Chain(
+		http.HandlerFunc(controllers.SellStock),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("POST")
+	router.Handle("/book/{id}/stock/reorder-threshold", middlewares.Chain(
+		http.HandlerFunc(controllers.SetReorderThreshold),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("PUT")
+	router.Handle("/books/low-stock", middlewares.Chain(
+		http.HandlerFunc(controllers.GetLowStock),
 		middlewares.AuthMiddleware,
 		middlewares.RoleMiddleware("admin")),
+	).Methods("GET")
+
+	// locations
+	router.Handle("/locations", middlewares.Chain(
+		http.HandlerFunc(controllers.CreateLocation),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("POST")
+	router.Handle("/locations", middlewares.Chain(
+		http.HandlerFunc(controllers.GetLocations),
+		middlewares.AuthMiddleware),
+	).Methods("GET")
+	router.Handle("/locations/{id}", middlewares.Chain(
+		http.HandlerFunc(controllers.GetLocation),
+		middlewares.AuthMiddleware),
+	).Methods("GET")
+	router.Handle("/stock/transfers", middlewares.Chain(
+		http.HandlerFunc(controllers.TransferStock),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("POST")
+	router.Handle("/book/{id}/availability", middlewares.Chain(
+		http.HandlerFunc(controllers.GetAvailability),
+		middlewares.AuthMiddleware),
+	).Methods("GET")
+	router.Handle("/book/{id}/movements", middlewares.Chain(
+		http.HandlerFunc(controllers.GetStockMovements),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("GET")
+
+	// cart
+	router.Handle("/cart", middlewares.Chain(
+		http.HandlerFunc(controllers.GetCart),
+		middlewares.AuthMiddleware),
+	).Methods("GET")
+	router.Handle("/cart", middlewares.Chain(
+		http.HandlerFunc(controllers.ClearCart),
+		middlewares.AuthMiddleware),
+	).Methods("DELETE")
+	router.Handle("/cart/items", middlewares.Chain(
+		http.HandlerFunc(controllers.AddCartItem),
+		middlewares.AuthMiddleware),
+	).Methods("POST")
+	router.Handle("/cart/items/{bookId}", middlewares.Chain(
+		http.HandlerFunc(controllers.UpdateCartItem),
+		middlewares.AuthMiddleware),
+	).Methods("PUT")
+	router.Handle("/cart/items/{bookId}", middlewares.Chain(
+		http.HandlerFunc(controllers.RemoveCartItem),
+		middlewares.AuthMiddleware),
 	).Methods("DELETE")
 
+	// orders
+	router.Handle("/checkout", middlewares.Chain(
+		http.HandlerFunc(controllers.Checkout),
+		middlewares.AuthMiddleware),
+	).Methods("POST")
+	router.Handle("/orders", middlewares.Chain(
+		http.HandlerFunc(controllers.GetOrders),
+		middlewares.AuthMiddleware),
+	).Methods("GET")
+	router.Handle("/orders/{id}", middlewares.Chain(
+		http.HandlerFunc(controllers.GetOrder),
+		middlewares.AuthMiddleware),
+	).Methods("GET")
+	router.Handle("/orders/{id}/transitions", middlewares.Chain(
+		http.HandlerFunc(controllers.TransitionOrder),
+		middlewares.AuthMiddleware),
+	).Methods("POST")
+
+	// payments, signed by the provider instead of a token
+	router.HandleFunc("/payments/webhook", controllers.PaymentWebhook).Methods("POST")
+
+	// audit
+	router.Handle("/audit", middlewares.Chain(
+		http.HandlerFunc(controllers.GetAuditLog),
+		middlewares.AuthMiddleware,
+		middlewares.RoleMiddleware("admin")),
+	).Methods("GET")
+
 	// Swagger
 	router.PathPrefix("/swagger").HandlerFunc(httpSwagger.WrapHandler)
+
+	// mux runs no middleware for unmatched requests, so give them a request id here
+	router.NotFoundHandler = middlewares.RequestIDMiddleware(http.HandlerFunc(controllers.NotFound))
+	router.MethodNotAllowedHandler = middlewares.RequestIDMiddleware(http.HandlerFunc(controllers.MethodNotAllowed))
 }
@@ -0,0 +1,2096 @@
+package routes_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BULLKNIGHT/bookstore/controllers"
+	"github.com/BULLKNIGHT/bookstore/keys"
+	"github.com/BULLKNIGHT/bookstore/logger"
+	"github.com/BULLKNIGHT/bookstore/middlewares"
+	"github.com/BULLKNIGHT/bookstore/models"
+	"github.com/BULLKNIGHT/bookstore/onix"
+	"github.com/BULLKNIGHT/bookstore/payment"
+	"github.com/BULLKNIGHT/bookstore/problem"
+	"github.com/BULLKNIGHT/bookstore/repository"
+	"github.com/BULLKNIGHT/bookstore/routes"
+	"github.com/BULLKNIGHT/bookstore/validation"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const adminName = "admin"
+const adminPassword = "admin-password"
+
+var signingKey *rsa.PrivateKey
+
+func TestMain(m *testing.M) {
+	logger.Init()
+	logger.Log.SetLevel(logrus.FatalLevel)
+
+	var err error
+	signingKey, err = rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		panic(err)
+	}
+
+	os.Setenv("ADMIN_NAME", adminName)
+	os.Setenv("ADMIN_PASSWORD", adminPassword)
+
+	os.Exit(m.Run())
+}
+
+// newRouter returns the API router backed by a fresh in-memory store
+func newRouter(t *testing.T) *mux.Router {
+	t.Helper()
+
+	repository.UseMemory()
+	keys.Default = keys.NewManager(time.Hour)
+	keys.Default.AddSigningKey(signingKey)
+
+	if err := controllers.SeedAdmin(context.Background()); err != nil {
+		t.Fatalf("seed admin: %v", err)
+	}
+
+	if err := controllers.BuildSearchIndex(context.Background()); err != nil {
+		t.Fatalf("build search index: %v", err)
+	}
+
+	controllers.Payments = payment.NewFakeGateway("test-secret")
+
+	router := mux.NewRouter()
+	router.Use(middlewares.RequestIDMiddleware)
+	routes.RegisterBook(router)
+
+	return router
+}
+
+func do(t *testing.T, router http.Handler, method string, path string, token string, body any, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader *bytes.Reader
+
+	switch body := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(body))
+	default:
+		encoded, err := json.Marshal(body)
+
+		if err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+
+		reader = bytes.NewReader(encoded)
+	}
+
+	request := httptest.NewRequest(method, path, reader)
+
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func decode[T any](t *testing.T, recorder *httptest.ResponseRecorder) T {
+	t.Helper()
+
+	var value T
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &value); err != nil {
+		t.Fatalf("decode %q: %v", recorder.Body.String(), err)
+	}
+
+	return value
+}
+
+func expectStatus(t *testing.T, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+
+	if recorder.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, recorder.Code, recorder.Body.String())
+	}
+}
+
+func login(t *testing.T, router http.Handler, name string, password string) models.TokenPair {
+	t.Helper()
+
+	recorder := do(t, router, "POST", "/token", "", models.Credentials{Name: name, Password: password})
+	expectStatus(t, recorder, http.StatusOK)
+
+	return decode[models.TokenPair](t, recorder)
+}
+
+func registerAndLogin(t *testing.T, router http.Handler, name string) models.TokenPair {
+	t.Helper()
+
+	credentials := models.Credentials{Name: name, Password: "user-password"}
+	expectStatus(t, do(t, router, "POST", "/register", "", credentials), http.StatusCreated)
+
+	return login(t, router, credentials.Name, credentials.Password)
+}
+
+func createBook(t *testing.T, router http.Handler, token string, book models.Book) models.Book {
+	t.Helper()
+
+	recorder := do(t, router, "POST", "/book", token, book)
+	expectStatus(t, recorder, http.StatusOK)
+
+	return decode[models.Book](t, recorder)
+}
+
+// Run the dry run of a bulk delete and confirm it
+func bulkDelete(t *testing.T, router http.Handler, token string, filter string) models.BulkDeleteResult {
+	t.Helper()
+
+	recorder := do(t, router, "DELETE", "/books?"+filter, token, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	preview := decode[models.BulkDeletePreview](t, recorder)
+
+	recorder = do(t, router, "DELETE", "/books?"+filter+"&confirm="+preview.ConfirmationToken, token, nil)
+	expectStatus(t, recorder, http.StatusAccepted)
+
+	return decode[models.BulkDeleteResult](t, recorder)
+}
+
+func sampleBook(title string, author string, price int, year int) models.Book {
+	return models.Book{Title: title, Author: author, Price: price, PublishedYear: year, Category: "Programming"}
+}
+
+func TestHealth(t *testing.T) {
+	router := newRouter(t)
+
+	recorder := do(t, router, "GET", "/health", "", nil)
+	expectStatus(t, recorder, http.StatusOK)
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	router := newRouter(t)
+
+	// a role cannot be asked for, every registration is a plain user
+	recorder := do(t, router, "POST", "/register", "", `{"name":"alice","password":"user-password","role":"admin"}`)
+	expectStatus(t, recorder, http.StatusBadRequest)
+
+	if report := decode[problem.Details](t, recorder); len(report.Errors) != 1 || report.Errors[0].Field != "role" || report.Errors[0].Rule != validation.RuleUnknown {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	body := `{"name":"alice","password":"user-password"}`
+	recorder = do(t, router, "POST", "/register", "", body)
+	expectStatus(t, recorder, http.StatusCreated)
+
+	if user := decode[models.User](t, recorder); user.Role != models.RoleUser {
+		t.Fatalf("expected role %q, got %q", models.RoleUser, user.Role)
+	}
+
+	if strings.Contains(recorder.Body.String(), "password") {
+		t.Fatalf("password hash leaked: %s", recorder.Body.String())
+	}
+
+	expectStatus(t, do(t, router, "POST", "/register", "", body), http.StatusConflict)
+	expectStatus(t, do(t, router, "POST", "/register", "", `{"name":"bob","password":"short"}`), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "POST", "/register", "", `{"name":"bob"}`), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "POST", "/register", "", `not json`), http.StatusBadRequest)
+
+	expectStatus(t, do(t, router, "POST", "/token", "", `{"name":"alice","password":"wrong-password"}`), http.StatusUnauthorized)
+	expectStatus(t, do(t, router, "POST", "/token", "", `{"name":"nobody","password":"user-password"}`), http.StatusUnauthorized)
+
+	tokens := login(t, router, "alice", "user-password")
+
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.TokenType != "Bearer" {
+		t.Fatalf("unexpected token pair: %+v", tokens)
+	}
+
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(tokens.AccessToken, claims)
+
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+
+	if claims["role"] != models.RoleUser || claims["username"] != "alice" {
+		t.Fatalf("unexpected claims: %v", claims)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	router := newRouter(t)
+	first := registerAndLogin(t, router, "alice")
+
+	recorder := do(t, router, "POST", "/token/refresh", "", models.RefreshRequest{RefreshToken: first.RefreshToken})
+	expectStatus(t, recorder, http.StatusOK)
+	second := decode[models.TokenPair](t, recorder)
+
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	// replaying the used token revokes the whole session
+	recorder = do(t, router, "POST", "/token/refresh", "", models.RefreshRequest{RefreshToken: first.RefreshToken})
+	expectStatus(t, recorder, http.StatusUnauthorized)
+
+	recorder = do(t, router, "POST", "/token/refresh", "", models.RefreshRequest{RefreshToken: second.RefreshToken})
+	expectStatus(t, recorder, http.StatusUnauthorized)
+
+	// malformed requests get a problem per field
+	cases := map[string]struct {
+		body  string
+		field string
+		rule  string
+	}{
+		"missing token":  {`{}`, "refresh_token", validation.RuleRequired},
+		"empty token":    {`{"refresh_token":""}`, "refresh_token", validation.RuleRequired},
+		"unknown field":  {`{"refresh_token":"x","scope":"all"}`, "scope", validation.RuleUnknown},
+		"wrong type":     {`{"refresh_token":42}`, "refresh_token", validation.RuleType},
+		"no body":        {``, "", validation.RuleRequired},
+		"oversized body": {`{"refresh_token":"` + strings.Repeat("x", 2048) + `"}`, "", validation.RuleMaxLength},
+	}
+
+	for name, c := range cases {
+		recorder := do(t, router, "POST", "/token/refresh", "", c.body)
+		expectStatus(t, recorder, http.StatusBadRequest)
+
+		if report := decode[problem.Details](t, recorder); len(report.Errors) != 1 || report.Errors[0].Field != c.field || report.Errors[0].Rule != c.rule {
+			t.Fatalf("%s: unexpected problem %+v", name, report)
+		}
+	}
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	router := newRouter(t)
+	tokens := registerAndLogin(t, router, "alice")
+
+	expectStatus(t, do(t, router, "GET", "/books", tokens.AccessToken, nil), http.StatusOK)
+
+	recorder := do(t, router, "POST", "/logout", tokens.AccessToken, models.RefreshRequest{RefreshToken: tokens.RefreshToken})
+	expectStatus(t, recorder, http.StatusOK)
+
+	expectStatus(t, do(t, router, "GET", "/books", tokens.AccessToken, nil), http.StatusUnauthorized)
+
+	recorder = do(t, router, "POST", "/token/refresh", "", models.RefreshRequest{RefreshToken: tokens.RefreshToken})
+	expectStatus(t, recorder, http.StatusUnauthorized)
+
+	// the refresh token is optional, but the body is checked like any other
+	tokens = login(t, router, "alice", "user-password")
+	recorder = do(t, router, "POST", "/logout", tokens.AccessToken, `{"refreshToken":"x"}`)
+	expectStatus(t, recorder, http.StatusBadRequest)
+
+	if report := decode[problem.Details](t, recorder); len(report.Errors) != 1 || report.Errors[0].Field != "refreshToken" || report.Errors[0].Rule != validation.RuleUnknown {
+		t.Fatalf("unexpected problem %+v", report)
+	}
+
+	expectStatus(t, do(t, router, "POST", "/logout", tokens.AccessToken, nil), http.StatusOK)
+}
+
+func TestJWKSPublishesSigningKey(t *testing.T) {
+	router := newRouter(t)
+	tokens := login(t, router, adminName, adminPassword)
+
+	recorder := do(t, router, "GET", "/.well-known/jwks.json", "", nil)
+	expectStatus(t, recorder, http.StatusOK)
+	set := decode[keys.JWKSet](t, recorder)
+
+	token, _, err := jwt.NewParser().ParseUnverified(tokens.AccessToken, jwt.MapClaims{})
+
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+
+	if len(set.Keys) != 1 || set.Keys[0].Kid != token.Header["kid"] {
+		t.Fatalf("kid %v not published in %+v", token.Header["kid"], set)
+	}
+}
+
+func TestKeyRotationKeepsOldTokensValid(t *testing.T) {
+	router := newRouter(t)
+	before := login(t, router, adminName, adminPassword)
+
+	if err := keys.Default.Rotate(); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	after := login(t, router, adminName, adminPassword)
+
+	expectStatus(t, do(t, router, "GET", "/books", before.AccessToken, nil), http.StatusOK)
+	expectStatus(t, do(t, router, "GET", "/books", after.AccessToken, nil), http.StatusOK)
+
+	set := decode[keys.JWKSet](t, do(t, router, "GET", "/.well-known/jwks.json", "", nil))
+
+	if len(set.Keys) != 2 {
+		t.Fatalf("expected both keys during the overlap window, got %d", len(set.Keys))
+	}
+}
+
+func TestAuthAndRoleEnforcement(t *testing.T) {
+	router := newRouter(t)
+	user := registerAndLogin(t, router, "alice")
+	book := sampleBook("Go", "Alan Donovan", 30, 2015)
+
+	expectStatus(t, do(t, router, "GET", "/books", "", nil), http.StatusUnauthorized)
+	expectStatus(t, do(t, router, "GET", "/books", "not-a-token", nil), http.StatusUnauthorized)
+
+	// a token signed with an unknown key is rejected
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.MapClaims{
+		"username": "mallory", "role": "admin", "jti": "forged", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	forged.Header["kid"] = keys.Thumbprint(&otherKey.PublicKey)
+	forgedToken, _ := forged.SignedString(otherKey)
+	expectStatus(t, do(t, router, "POST", "/book", forgedToken, book), http.StatusUnauthorized)
+
+	expectStatus(t, do(t, router, "POST", "/book", user.AccessToken, book), http.StatusForbidden)
+	expectStatus(t, do(t, router, "DELETE", "/books", user.AccessToken, nil), http.StatusForbidden)
+	expectStatus(t, do(t, router, "GET", "/books", user.AccessToken, nil), http.StatusOK)
+}
+
+func TestBookCRUD(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+
+	// create
+	expectStatus(t, do(t, router, "POST", "/book", admin, `{"title":"No author"}`), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "POST", "/book", admin, `{`), http.StatusBadRequest)
+	book := createBook(t, router, admin, sampleBook("The Go Programming Language", "Alan Donovan", 30, 2015))
+
+	if book.ID.IsZero() {
+		t.Fatal("created book has no id")
+	}
+
+	path := "/book/" + book.ID.Hex()
+
+	// read
+	recorder := do(t, router, "GET", path, admin, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	etag := recorder.Header().Get("ETag")
+
+	if etag == "" || recorder.Header().Get("Last-Modified") == "" {
+		t.Fatalf("missing cache validators: %v", recorder.Header())
+	}
+
+	expectStatus(t, do(t, router, "GET", path, admin, nil, "If-None-Match", etag), http.StatusNotModified)
+	lastModified := recorder.Header().Get("Last-Modified")
+	expectStatus(t, do(t, router, "GET", path, admin, nil, "If-Modified-Since", lastModified), http.StatusNotModified)
+	expectStatus(t, do(t, router, "GET", "/book/not-an-id", admin, nil), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "GET", "/book/000000000000000000000000", admin, nil), http.StatusNotFound)
+
+	// update
+	book.Price = 35
+	recorder = do(t, router, "PUT", path, admin, book, "If-Match", "*")
+	expectStatus(t, recorder, http.StatusOK)
+
+	if updated := decode[models.Book](t, recorder); updated.Price != 35 {
+		t.Fatalf("price not updated: %+v", updated)
+	}
+
+	expectStatus(t, do(t, router, "GET", path, admin, nil, "If-None-Match", etag), http.StatusOK)
+	expectStatus(t, do(t, router, "PUT", path, admin, `{"title":""}`, "If-Match", "*"), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "PUT", "/book/not-an-id", admin, book, "If-Match", "*"), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "PUT", "/book/000000000000000000000000", admin, book, "If-Match", "*"), http.StatusNotFound)
+
+	// delete
+	expectStatus(t, do(t, router, "DELETE", "/book/not-an-id", admin, nil, "If-Match", "*"), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "DELETE", path, admin, nil, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, do(t, router, "DELETE", path, admin, nil, "If-Match", "*"), http.StatusNotFound)
+	expectStatus(t, do(t, router, "GET", path, admin, nil), http.StatusNotFound)
+
+	// delete all
+	createBook(t, router, admin, sampleBook("A", "B", 1, 2000))
+	bulkDelete(t, router, admin, "")
+
+	if page := decode[models.BookPage](t, do(t, router, "GET", "/books", admin, nil)); page.Total != 0 {
+		t.Fatalf("expected empty catalog, got %d books", page.Total)
+	}
+}
+
+func TestPatchBook(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+
+	book := sampleBook("The Go Programming Language", "Alan Donovan", 30, 2015)
+	book.Isbn = "978-0134190440"
+	book = createBook(t, router, admin, book)
+	path := "/book/" + book.ID.Hex()
+
+	// merge patch only touches the given fields
+	recorder := do(t, router, "PATCH", path, admin, `{"price":35,"category":null}`, "Content-Type", "application/merge-patch+json", "If-Match", "*")
+	expectStatus(t, recorder, http.StatusOK)
+	patched := decode[models.Book](t, recorder)
+
+	if patched.Price != 35 || patched.Category != "" || patched.Isbn != book.Isbn || patched.PublishedYear != 2015 {
+		t.Fatalf("unexpected merge result %+v", patched)
+	}
+
+	// JSON patch with a failing test operation changes nothing
+	operations := `[{"op":"test","path":"/price","value":99},{"op":"replace","path":"/price","value":1}]`
+	recorder = do(t, router, "PATCH", path, admin, operations, "Content-Type", "application/json-patch+json", "If-Match", "*")
+	expectStatus(t, recorder, http.StatusConflict)
+
+	operations = `[{"op":"test","path":"/price","value":35},{"op":"replace","path":"/title","value":"Go"}]`
+	recorder = do(t, router, "PATCH", path, admin, operations, "Content-Type", "application/json-patch+json", "If-Match", "*")
+	expectStatus(t, recorder, http.StatusOK)
+
+	if patched = decode[models.Book](t, recorder); patched.Title != "Go" || patched.Price != 35 {
+		t.Fatalf("unexpected JSON patch result %+v", patched)
+	}
+
+	// the merged result is validated
+	expectStatus(t, do(t, router, "PATCH", path, admin, `{"title":null}`, "If-Match", "*"), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "PATCH", path, admin, `{"price":"free"}`, "If-Match", "*"), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "PATCH", path, admin, `{"price":1}`, "Content-Type", "text/plain", "If-Match", "*"), http.StatusUnsupportedMediaType)
+	expectStatus(t, do(t, router, "PATCH", "/book/000000000000000000000000", admin, `{"price":1}`, "If-Match", "*"), http.StatusNotFound)
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+
+	book := createBook(t, router, admin, sampleBook("Refactoring", "Martin Fowler", 40, 2018))
+	path := "/book/" + book.ID.Hex()
+
+	if book.Version != 1 {
+		t.Fatalf("new book should be version 1, got %d", book.Version)
+	}
+
+	recorder := do(t, router, "GET", path, admin, nil)
+	stale := recorder.Header().Get("ETag")
+
+	// a matching If-Match succeeds and bumps the version
+	book.Price = 45
+	recorder = do(t, router, "PUT", path, admin, book, "If-Match", stale)
+	expectStatus(t, recorder, http.StatusOK)
+	current := recorder.Header().Get("ETag")
+
+	if updated := decode[models.Book](t, recorder); updated.Version != 2 || current == stale {
+		t.Fatalf("expected version 2 with a new ETag, got %+v (%s)", updated, current)
+	}
+
+	// writes based on the old version are rejected
+	expectStatus(t, do(t, router, "PUT", path, admin, book, "If-Match", stale), http.StatusPreconditionFailed)
+	expectStatus(t, do(t, router, "PATCH", path, admin, `{"price":1}`, "If-Match", stale), http.StatusPreconditionFailed)
+	expectStatus(t, do(t, router, "DELETE", path, admin, nil, "If-Match", stale), http.StatusPreconditionFailed)
+	expectStatus(t, do(t, router, "PUT", path, admin, book, "If-Match", "garbage"), http.StatusPreconditionFailed)
+
+	recorder = do(t, router, "PATCH", path, admin, `{"price":50}`, "If-Match", current)
+	expectStatus(t, recorder, http.StatusOK)
+	current = recorder.Header().Get("ETag")
+
+	if patched := decode[models.Book](t, recorder); patched.Version != 3 || patched.Price != 50 {
+		t.Fatalf("unexpected patch result %+v", patched)
+	}
+
+	// a list matches when any of its ETags does
+	recorder = do(t, router, "PATCH", path, admin, `{"price":52}`, "If-Match", stale+`, W/"v3", `+current)
+	expectStatus(t, recorder, http.StatusOK)
+	current = recorder.Header().Get("ETag")
+	expectStatus(t, do(t, router, "PATCH", path, admin, `{"price":1}`, "If-Match", stale+`, "v99"`), http.StatusPreconditionFailed)
+
+	// writes that do not say which version they replace are refused
+	for _, method := range []string{"PUT", "PATCH", "DELETE"} {
+		recorder = do(t, router, method, path, admin, book)
+		expectStatus(t, recorder, http.StatusPreconditionRequired)
+
+		if details := decode[problem.Details](t, recorder); details.Type != problem.TypePreconditionRequired {
+			t.Fatalf("%s: expected a precondition required problem, got %+v", method, details)
+		}
+	}
+
+	expectStatus(t, do(t, router, "POST", path+"/rollback", admin, `{"version":1}`), http.StatusPreconditionRequired)
+
+	// stock writes leave the catalog version alone, so an edit based on the
+	// ETag from before a sale still applies, while cached copies are stale
+	expectStatus(t, do(t, router, "POST", path+"/stock/receive", admin, `{"quantity":3}`), http.StatusOK)
+	expectStatus(t, do(t, router, "GET", path, admin, nil, "If-None-Match", current), http.StatusOK)
+	recorder = do(t, router, "PATCH", path, admin, `{"price":53}`, "If-Match", current)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if patched := decode[models.Book](t, recorder); patched.Version != 5 || patched.Stock != 3 {
+		t.Fatalf("expected version 5 keeping the stock, got %+v", patched)
+	}
+
+	current = recorder.Header().Get("ETag")
+
+	// the wildcard skips the check
+	expectStatus(t, do(t, router, "PATCH", path, admin, `{"price":55}`, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, do(t, router, "DELETE", path, admin, nil, "If-Match", current), http.StatusPreconditionFailed)
+	expectStatus(t, do(t, router, "DELETE", path, admin, nil, "If-Match", "*"), http.StatusOK)
+}
+
+func TestInventory(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+	user := registerAndLogin(t, router, "clerk").AccessToken
+
+	opening := sampleBook("Inventory", "Someone", 10, 2020)
+	opening.Stock = 4
+	opening.Reserved = 3
+	opening.ReorderThreshold = 2
+	book := createBook(t, router, admin, opening)
+	path := "/book/" + book.ID.Hex()
+
+	// reserved stock only comes from orders
+	if book.Stock != 4 || book.Reserved != 0 {
+		t.Fatalf("expected an opening stock of 4 and nothing reserved, got %+v", book)
+	}
+
+	stock := func(recorder *httptest.ResponseRecorder) int {
+		t.Helper()
+		expectStatus(t, recorder, http.StatusOK)
+		return decode[models.Book](t, recorder).Stock
+	}
+
+	if got := stock(do(t, router, "POST", path+"/stock/receive", admin, `{"quantity":6}`)); got != 10 {
+		t.Fatalf("expected 10 after receiving 6, got %d", got)
+	}
+
+	if got := stock(do(t, router, "POST", path+"/stock/sell", admin, `{"quantity":3}`)); got != 7 {
+		t.Fatalf("expected 7 after selling 3, got %d", got)
+	}
+
+	// a PUT keeps the stock and the threshold whatever the body says
+	book.Stock = 100
+	book.ReorderThreshold = 0
+	recorder := do(t, router, "PUT", path, admin, book, "If-Match", "*")
+	expectStatus(t, recorder, http.StatusOK)
+
+	if updated := decode[models.Book](t, recorder); updated.Stock != 7 || updated.ReorderThreshold != 2 {
+		t.Fatalf("PUT overwrote the stock or threshold: %+v", updated)
+	}
+
+	recorder = do(t, router, "PATCH", path, admin, `{"price":12}`, "Content-Type", "application/merge-patch+json", "If-Match", "*")
+	expectStatus(t, recorder, http.StatusOK)
+
+	if patched := decode[models.Book](t, recorder); patched.ReorderThreshold != 2 {
+		t.Fatalf("PATCH reset the threshold to %d", patched.ReorderThreshold)
+	}
+
+	recorder = do(t, router, "PUT", path+"/stock/reorder-threshold", admin, `{"reorder_threshold":3}`)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if changed := decode[models.Book](t, recorder); changed.ReorderThreshold != 3 || changed.Stock != 7 {
+		t.Fatalf("unexpected book after setting the threshold %+v", changed)
+	}
+
+	for _, body := range []string{`{"reorder_threshold":-1}`, `{"reorder_threshold":"few"}`, `{"stock":3}`} {
+		expectStatus(t, do(t, router, "PUT", path+"/stock/reorder-threshold", admin, body), http.StatusBadRequest)
+	}
+
+	expectStatus(t, do(t, router, "PUT", path+"/stock/reorder-threshold", user, `{"reorder_threshold":1}`), http.StatusForbidden)
+
+	recorder = do(t, router, "POST", path+"/stock/sell", admin, `{"quantity":8}`)
+	expectStatus(t, recorder, http.StatusConflict)
+
+	if details := decode[problem.Details](t, recorder); details.Type != problem.TypeInsufficientStock {
+		t.Fatalf("expected an insufficient stock problem, got %+v", details)
+	}
+
+	// concurrent sales never take the stock below zero
+	var wg sync.WaitGroup
+	sold := make(chan int, 10)
+
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			sold <- do(t, router, "POST", path+"/stock/sell", admin, `{"quantity":1}`).Code
+		}()
+	}
+
+	wg.Wait()
+	close(sold)
+	succeeded := 0
+
+	for code := range sold {
+		if code == http.StatusOK {
+			succeeded++
+		}
+	}
+
+	if got := stock(do(t, router, "GET", path, admin, nil)); succeeded != 7 || got != 0 {
+		t.Fatalf("expected 7 sales down to 0, got %d sales down to %d", succeeded, got)
+	}
+
+	for _, body := range []string{`{"quantity":0}`, `{"quantity":-1}`, `{}`, `{"quantity":"two"}`} {
+		expectStatus(t, do(t, router, "POST", path+"/stock/receive", admin, body), http.StatusBadRequest)
+	}
+
+	expectStatus(t, do(t, router, "POST", path+"/stock/receive", user, `{"quantity":1}`), http.StatusForbidden)
+	expectStatus(t, do(t, router, "POST", "/book/"+primitive.NewObjectID().Hex()+"/stock/receive", admin, `{"quantity":1}`), http.StatusNotFound)
+
+	// the report lists books down to their reorder threshold, lowest first
+	stocked := sampleBook("Stocked", "Someone", 10, 2020)
+	stocked.Stock = 50
+	stocked.ReorderThreshold = 5
+	createBook(t, router, admin, stocked)
+
+	almost := sampleBook("Almost out", "Someone", 10, 2020)
+	almost.Stock = 5
+	almost.ReorderThreshold = 5
+	almost = createBook(t, router, admin, almost)
+
+	recorder = do(t, router, "GET", "/books/low-stock", admin, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	report := decode[[]models.StockLevel](t, recorder)
+
+	if len(report) != 2 || report[0].ID != book.ID.Hex() || report[1].ID != almost.ID.Hex() || report[1].Available != 5 {
+		t.Fatalf("unexpected low-stock report %+v", report)
+	}
+
+	expectStatus(t, do(t, router, "GET", "/books/low-stock", user, nil), http.StatusForbidden)
+
+	// stock changes are audited
+	recorder = do(t, router, "GET", "/audit?book_id="+book.ID.Hex(), admin, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	actions := map[string]int{}
+
+	for _, entry := range decode[models.AuditPage](t, recorder).Data {
+		actions[entry.Action]++
+	}
+
+	if actions[models.AuditReceive] != 1 || actions[models.AuditSell] != 8 || actions[models.AuditReorder] != 1 {
+		t.Fatalf("unexpected audit actions %v", actions)
+	}
+}
+
+func TestLocations(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+	user := registerAndLogin(t, router, "shopper").AccessToken
+
+	createLocation := func(body string) models.Location {
+		t.Helper()
+		recorder := do(t, router, "POST", "/locations", admin, body)
+		expectStatus(t, recorder, http.StatusCreated)
+		return decode[models.Location](t, recorder)
+	}
+
+	warehouse := createLocation(`{"code":"wh-1","name":"Warehouse","kind":"warehouse"}`)
+	shop := createLocation(`{"code":"SHOP-1","name":"High Street","kind":"store","address":"1 High Street"}`)
+
+	if warehouse.Code != "WH-1" {
+		t.Fatalf("expected an upper-cased code, got %q", warehouse.Code)
+	}
+
+	expectStatus(t, do(t, router, "POST", "/locations", admin, `{"code":"WH-1","name":"Again","kind":"store"}`), http.StatusConflict)
+	expectStatus(t, do(t, router, "POST", "/locations", admin, `{"code":"X","name":"Y","kind":"shed"}`), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "POST", "/locations", user, `{"code":"X","name":"Y","kind":"store"}`), http.StatusForbidden)
+
+	recorder := do(t, router, "GET", "/locations", user, nil)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if locations := decode[[]models.Location](t, recorder); len(locations) != 2 || locations[0].Code != "SHOP-1" {
+		t.Fatalf("expected locations ordered by code, got %+v", locations)
+	}
+
+	expectStatus(t, do(t, router, "GET", "/locations/"+shop.ID.Hex(), user, nil), http.StatusOK)
+	expectStatus(t, do(t, router, "GET", "/locations/"+primitive.NewObjectID().Hex(), user, nil), http.StatusNotFound)
+
+	opening := sampleBook("Stocked everywhere", "Someone", 10, 2020)
+	opening.Stock = 2
+	book := createBook(t, router, admin, opening)
+	path := "/book/" + book.ID.Hex()
+
+	receive := fmt.Sprintf(`{"quantity":10,"location_id":"%s"}`, warehouse.ID.Hex())
+	expectStatus(t, do(t, router, "POST", path+"/stock/receive", admin, receive), http.StatusOK)
+	expectStatus(t, do(t, router, "POST", path+"/stock/receive", admin, `{"quantity":1,"location_id":"nowhere"}`), http.StatusBadRequest)
+
+	transfer := func(from string, to string, quantity int) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"book_id":"%s","from":"%s","to":"%s","quantity":%d}`, book.ID.Hex(), from, to, quantity)
+		return do(t, router, "POST", "/stock/transfers", admin, body)
+	}
+
+	expectStatus(t, transfer(warehouse.ID.Hex(), shop.ID.Hex(), 4), http.StatusOK)
+	// the unassigned opening stock can be placed too
+	expectStatus(t, transfer("", shop.ID.Hex(), 2), http.StatusOK)
+	expectStatus(t, transfer("", shop.ID.Hex(), 1), http.StatusConflict)
+	expectStatus(t, transfer(shop.ID.Hex(), warehouse.ID.Hex(), 7), http.StatusConflict)
+	expectStatus(t, transfer(shop.ID.Hex(), shop.ID.Hex(), 1), http.StatusBadRequest)
+	expectStatus(t, transfer(shop.ID.Hex(), primitive.NewObjectID().Hex(), 1), http.StatusBadRequest)
+
+	// a shop cannot sell copies held elsewhere
+	sell := func(locationId string, quantity int) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"quantity":%d,"location_id":"%s"}`, quantity, locationId)
+		return do(t, router, "POST", path+"/stock/sell", admin, body)
+	}
+
+	expectStatus(t, sell(shop.ID.Hex(), 7), http.StatusConflict)
+	expectStatus(t, sell(shop.ID.Hex(), 5), http.StatusOK)
+
+	recorder = do(t, router, "GET", path+"/availability", user, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	availability := decode[models.Availability](t, recorder)
+	held := map[string]int{}
+
+	for _, location := range availability.Locations {
+		held[location.Code] = location.Quantity
+	}
+
+	if availability.Stock != 7 || availability.Unassigned != 0 || held["WH-1"] != 6 || held["SHOP-1"] != 1 {
+		t.Fatalf("unexpected availability %+v", availability)
+	}
+
+	// PUT leaves the stock per location alone
+	current := decode[models.Book](t, do(t, router, "GET", path, admin, nil))
+	current.StockByLocation = nil
+	recorder = do(t, router, "PUT", path, admin, current, "If-Match", "*")
+	expectStatus(t, recorder, http.StatusOK)
+
+	if updated := decode[models.Book](t, recorder); updated.StockByLocation[shop.ID.Hex()] != 1 {
+		t.Fatalf("PUT overwrote the stock per location: %+v", updated.StockByLocation)
+	}
+
+	recorder = do(t, router, "GET", path+"/movements", admin, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	movements := decode[[]models.StockMovement](t, recorder)
+
+	if len(movements) != 4 || movements[0].Kind != models.MovementSell || movements[0].From != shop.ID.Hex() {
+		t.Fatalf("unexpected movements %+v", movements)
+	}
+
+	if moved := movements[1]; moved.Kind != models.MovementTransfer || moved.From != "" || moved.To != shop.ID.Hex() || moved.Quantity != 2 {
+		t.Fatalf("unexpected transfer movement %+v", moved)
+	}
+
+	expectStatus(t, do(t, router, "GET", path+"/movements", user, nil), http.StatusForbidden)
+}
+
+func TestCart(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+	reader := registerAndLogin(t, router, "reader").AccessToken
+	other := registerAndLogin(t, router, "other").AccessToken
+
+	goBook := createBook(t, router, admin, sampleBook("Go", "Alan Donovan", 3000, 2015))
+	rustBook := createBook(t, router, admin, sampleBook("Rust", "Steve Klabnik", 2500, 2019))
+
+	cart := func(recorder *httptest.ResponseRecorder) models.PricedCart {
+		t.Helper()
+		expectStatus(t, recorder, http.StatusOK)
+		return decode[models.PricedCart](t, recorder)
+	}
+
+	add := func(token string, book models.Book, quantity int) *httptest.ResponseRecorder {
+		return do(t, router, "POST", "/cart/items", token, fmt.Sprintf(`{"book_id":"%s","quantity":%d}`, book.ID.Hex(), quantity))
+	}
+
+	if empty := cart(do(t, router, "GET", "/cart", reader, nil)); len(empty.Lines) != 0 || empty.Subtotal != 0 {
+		t.Fatalf("expected an empty cart, got %+v", empty)
+	}
+
+	cart(add(reader, goBook, 1))
+	cart(add(reader, goBook, 1))
+	got := cart(add(reader, rustBook, 1))
+
+	if got.Owner != "reader" || len(got.Lines) != 2 || got.Lines[0].Quantity != 2 || got.ItemCount != 3 || got.Subtotal != 8500 || got.ExpiresAt == nil {
+		t.Fatalf("unexpected cart %+v", got)
+	}
+
+	// carts are per user
+	if theirs := cart(do(t, router, "GET", "/cart", other, nil)); len(theirs.Lines) != 0 {
+		t.Fatalf("another user sees %+v", theirs)
+	}
+
+	// prices come from the catalog, not from the client
+	expectStatus(t, do(t, router, "POST", "/cart/items", reader, fmt.Sprintf(`{"book_id":"%s","quantity":1,"price":1}`, goBook.ID.Hex())), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "PATCH", "/book/"+goBook.ID.Hex(), admin, `{"price":3500}`, "If-Match", "*"), http.StatusOK)
+
+	if got := cart(do(t, router, "GET", "/cart", reader, nil)); got.Lines[0].UnitPrice != 3500 || got.Subtotal != 9500 {
+		t.Fatalf("expected the new price, got %+v", got)
+	}
+
+	got = cart(do(t, router, "PUT", "/cart/items/"+rustBook.ID.Hex(), reader, `{"quantity":4}`))
+
+	if got.Lines[1].Quantity != 4 || got.Subtotal != 17000 {
+		t.Fatalf("unexpected cart after update %+v", got)
+	}
+
+	// deleted books stay listed but are no longer counted
+	expectStatus(t, do(t, router, "DELETE", "/book/"+rustBook.ID.Hex(), admin, nil, "If-Match", "*"), http.StatusOK)
+
+	if got := cart(do(t, router, "GET", "/cart", reader, nil)); got.Lines[1].Available || got.Subtotal != 7000 {
+		t.Fatalf("expected the deleted book to be unavailable, got %+v", got)
+	}
+
+	got = cart(do(t, router, "DELETE", "/cart/items/"+rustBook.ID.Hex(), reader, nil))
+
+	if len(got.Lines) != 1 {
+		t.Fatalf("expected one line left, got %+v", got)
+	}
+
+	expectStatus(t, do(t, router, "DELETE", "/cart/items/"+rustBook.ID.Hex(), reader, nil), http.StatusNotFound)
+	expectStatus(t, do(t, router, "PUT", "/cart/items/"+rustBook.ID.Hex(), reader, `{"quantity":1}`), http.StatusNotFound)
+	expectStatus(t, add(reader, goBook, models.MaxCartQuantity), http.StatusBadRequest)
+	expectStatus(t, add(reader, rustBook, 1), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "POST", "/cart/items", reader, `{"book_id":"nope","quantity":1}`), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "GET", "/cart", "", nil), http.StatusUnauthorized)
+
+	expectStatus(t, do(t, router, "DELETE", "/cart", reader, nil), http.StatusNoContent)
+
+	if got := cart(do(t, router, "GET", "/cart", reader, nil)); len(got.Lines) != 0 {
+		t.Fatalf("expected an empty cart after clearing, got %+v", got)
+	}
+
+	// untouched carts expire
+	controllers.CartTTL = 50 * time.Millisecond
+	t.Cleanup(func() { controllers.CartTTL = controllers.DefaultCartTTL })
+
+	cart(add(reader, goBook, 1))
+	time.Sleep(100 * time.Millisecond)
+
+	if got := cart(do(t, router, "GET", "/cart", reader, nil)); len(got.Lines) != 0 {
+		t.Fatalf("expected the cart to expire, got %+v", got)
+	}
+}
+
+func TestOrders(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+	reader := registerAndLogin(t, router, "reader").AccessToken
+	other := registerAndLogin(t, router, "other").AccessToken
+	approve := fmt.Sprintf(`{"payment_method":"%s"}`, payment.MethodApprove)
+
+	stocked := func(title string, price int, stock int) models.Book {
+		book := sampleBook(title, "Someone", price, 2020)
+		book.Stock = stock
+		return createBook(t, router, admin, book)
+	}
+
+	goBook := stocked("Go", 3000, 5)
+	rustBook := stocked("Rust", 2500, 1)
+
+	add := func(token string, book models.Book, quantity int) {
+		t.Helper()
+		body := fmt.Sprintf(`{"book_id":"%s","quantity":%d}`, book.ID.Hex(), quantity)
+		expectStatus(t, do(t, router, "POST", "/cart/items", token, body), http.StatusOK)
+	}
+
+	reserved := func(book models.Book) (int, int) {
+		t.Helper()
+		stored := decode[models.Book](t, do(t, router, "GET", "/book/"+book.ID.Hex(), admin, nil))
+		return stored.Stock, stored.Reserved
+	}
+
+	expectStatus(t, do(t, router, "POST", "/checkout", reader, approve), http.StatusConflict)
+
+	// one short line fails the whole checkout and reserves nothing
+	add(reader, goBook, 2)
+	add(reader, rustBook, 2)
+	recorder := do(t, router, "POST", "/checkout", reader, approve)
+	expectStatus(t, recorder, http.StatusConflict)
+
+	if details := decode[problem.Details](t, recorder); details.Type != problem.TypeInsufficientStock {
+		t.Fatalf("expected an insufficient stock problem, got %+v", details)
+	}
+
+	if _, held := reserved(goBook); held != 0 {
+		t.Fatalf("a failed checkout reserved %d copies", held)
+	}
+
+	expectStatus(t, do(t, router, "PUT", "/cart/items/"+rustBook.ID.Hex(), reader, `{"quantity":1}`), http.StatusOK)
+	recorder = do(t, router, "POST", "/checkout", reader, approve)
+	expectStatus(t, recorder, http.StatusCreated)
+	order := decode[models.Order](t, recorder)
+	path := "/orders/" + order.ID.Hex()
+
+	if order.Status != models.OrderPending || order.Owner != "reader" || order.Total != 8500 || len(order.Lines) != 2 || order.Lines[0].Title != "Go" {
+		t.Fatalf("unexpected order %+v", order)
+	}
+
+	if stock, held := reserved(goBook); stock != 5 || held != 2 {
+		t.Fatalf("expected 2 of 5 copies reserved, got %d of %d", held, stock)
+	}
+
+	if cart := decode[models.PricedCart](t, do(t, router, "GET", "/cart", reader, nil)); len(cart.Lines) != 0 {
+		t.Fatalf("expected checkout to empty the cart, got %+v", cart)
+	}
+
+	// the order keeps the price it was placed at
+	expectStatus(t, do(t, router, "PATCH", "/book/"+goBook.ID.Hex(), admin, `{"price":9999}`, "If-Match", "*"), http.StatusOK)
+
+	if placed := decode[models.Order](t, do(t, router, "GET", path, reader, nil)); placed.Total != 8500 || placed.Lines[0].UnitPrice != 3000 {
+		t.Fatalf("order price changed with the catalog: %+v", placed)
+	}
+
+	expectStatus(t, do(t, router, "GET", path, other, nil), http.StatusNotFound)
+
+	move := func(token string, status string, extra ...string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"status":"%s"%s}`, status, strings.Join(extra, ""))
+		return do(t, router, "POST", path+"/transitions", token, body)
+	}
+
+	expectStatus(t, move(reader, models.OrderPaid), http.StatusForbidden)
+	expectStatus(t, move(other, models.OrderCancelled), http.StatusNotFound)
+	expectStatus(t, move(admin, models.OrderShipped), http.StatusConflict)
+	expectStatus(t, move(admin, "lost"), http.StatusBadRequest)
+	recorder = move(admin, models.OrderPaid)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if paid := decode[models.Order](t, recorder); paid.Payment == nil || paid.Payment.Status != payment.StatusCaptured || paid.Payment.Amount != 8500 {
+		t.Fatalf("expected paying to capture the payment, got %+v", paid.Payment)
+	}
+
+	recorder = move(reader, models.OrderCancelled)
+	expectStatus(t, recorder, http.StatusConflict)
+
+	if details := decode[problem.Details](t, recorder); details.Type != problem.TypeInvalidTransition {
+		t.Fatalf("expected an invalid transition problem, got %+v", details)
+	}
+
+	// shipping takes the copies out of the stock, from a location when given
+	shop := decode[models.Location](t, do(t, router, "POST", "/locations", admin, `{"code":"SHOP","name":"Shop","kind":"store"}`))
+	expectStatus(t, move(admin, models.OrderShipped, `,"location_id":"`+shop.ID.Hex()+`"`), http.StatusConflict)
+	expectStatus(t, move(admin, models.OrderShipped), http.StatusOK)
+
+	if stock, held := reserved(goBook); stock != 3 || held != 0 {
+		t.Fatalf("expected 3 copies left and none reserved after shipping, got %d and %d", stock, held)
+	}
+
+	expectStatus(t, move(admin, models.OrderDelivered), http.StatusOK)
+	recorder = move(admin, models.OrderRefunded)
+	expectStatus(t, recorder, http.StatusOK)
+	refunded := decode[models.Order](t, recorder)
+
+	if refunded.Payment.Status != payment.StatusRefunded {
+		t.Fatalf("expected the payment refunded, got %+v", refunded.Payment)
+	}
+
+	statuses := []string{}
+
+	for _, event := range refunded.History {
+		statuses = append(statuses, event.Status)
+	}
+
+	if want := []string{"pending", "paid", "shipped", "delivered", "refunded"}; !reflect.DeepEqual(statuses, want) {
+		t.Fatalf("expected history %v, got %v", want, statuses)
+	}
+
+	expectStatus(t, move(admin, models.OrderPending), http.StatusConflict)
+
+	// cancelling a pending order frees its copies
+	add(reader, goBook, 3)
+	second := decode[models.Order](t, do(t, router, "POST", "/checkout", reader, approve))
+	expectStatus(t, do(t, router, "POST", "/orders/"+second.ID.Hex()+"/transitions", reader, `{"status":"cancelled"}`), http.StatusOK)
+
+	if _, held := reserved(goBook); held != 0 {
+		t.Fatalf("cancelling kept %d copies reserved", held)
+	}
+
+	recorder = do(t, router, "GET", "/orders", reader, nil)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if history := decode[[]models.Order](t, recorder); len(history) != 2 || history[0].ID != second.ID {
+		t.Fatalf("expected two orders newest first, got %+v", history)
+	}
+
+	if history := decode[[]models.Order](t, do(t, router, "GET", "/orders", other, nil)); len(history) != 0 {
+		t.Fatalf("another user sees %+v", history)
+	}
+
+	expectStatus(t, do(t, router, "GET", "/orders?user=reader", other, nil), http.StatusForbidden)
+
+	if history := decode[[]models.Order](t, do(t, router, "GET", "/orders?user=reader", admin, nil)); len(history) != 2 {
+		t.Fatalf("expected admins to see the orders of reader, got %+v", history)
+	}
+
+	// two customers racing for the last copy: one order wins
+	last := stocked("Last copy", 1000, 1)
+	add(reader, last, 1)
+	add(other, last, 1)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 2)
+
+	for _, token := range []string{reader, other} {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			codes <- do(t, router, "POST", "/checkout", token, approve).Code
+		}()
+	}
+
+	wg.Wait()
+	close(codes)
+	created := 0
+
+	for code := range codes {
+		if code == http.StatusCreated {
+			created++
+		}
+	}
+
+	if _, held := reserved(last); created != 1 || held != 1 {
+		t.Fatalf("expected exactly one order for the last copy, got %d with %d reserved", created, held)
+	}
+}
+
+// flakyProvider counts the calls to the provider it wraps, and fails them
+// with the error set for them
+type flakyProvider struct {
+	payment.PaymentProvider
+	captures   int
+	refunds    int
+	captureErr error
+	refundErr  error
+}
+
+func (provider *flakyProvider) Capture(paymentId string, amount int, ctx context.Context) (payment.Result, error) {
+	provider.captures++
+
+	if provider.captureErr != nil {
+		return payment.Result{}, provider.captureErr
+	}
+
+	return provider.PaymentProvider.Capture(paymentId, amount, ctx)
+}
+
+func (provider *flakyProvider) Refund(paymentId string, amount int, ctx context.Context) (payment.Result, error) {
+	provider.refunds++
+
+	if provider.refundErr != nil {
+		return payment.Result{}, provider.refundErr
+	}
+
+	return provider.PaymentProvider.Refund(paymentId, amount, ctx)
+}
+
+// conflictingOrders loses every transition to a concurrent one
+type conflictingOrders struct {
+	repository.OrderRepository
+}
+
+func (orders conflictingOrders) Transition(orderId primitive.ObjectID, move models.OrderMove, ctx context.Context) (models.Order, error) {
+	return models.Order{}, repository.ErrVersionConflict
+}
+
+func TestPayments(t *testing.T) {
+	router := newRouter(t)
+	gateway := payment.NewFakeGateway("test-secret")
+	previous := controllers.Payments
+	controllers.Payments = gateway
+	t.Cleanup(func() { controllers.Payments = previous })
+
+	admin := login(t, router, adminName, adminPassword).AccessToken
+	reader := registerAndLogin(t, router, "reader").AccessToken
+	book := sampleBook("Go", "Someone", 3000, 2020)
+	book.Stock = 5
+	book = createBook(t, router, admin, book)
+
+	checkout := func(method string) *httptest.ResponseRecorder {
+		t.Helper()
+		expectStatus(t, do(t, router, "POST", "/cart/items", reader, `{"book_id":"`+book.ID.Hex()+`","quantity":1}`), http.StatusOK)
+		return do(t, router, "POST", "/checkout", reader, `{"payment_method":"`+method+`"}`)
+	}
+
+	reserved := func() int {
+		t.Helper()
+		return decode[models.Book](t, do(t, router, "GET", "/book/"+book.ID.Hex(), admin, nil)).Reserved
+	}
+
+	getOrder := func(order models.Order) models.Order {
+		t.Helper()
+		return decode[models.Order](t, do(t, router, "GET", "/orders/"+order.ID.Hex(), admin, nil))
+	}
+
+	pay := func(order models.Order) *httptest.ResponseRecorder {
+		return do(t, router, "POST", "/orders/"+order.ID.Hex()+"/transitions", admin, `{"status":"paid"}`)
+	}
+
+	webhook := func(payload []byte, signature string) *httptest.ResponseRecorder {
+		return do(t, router, "POST", "/payments/webhook", "", string(payload), payment.SignatureHeader, signature)
+	}
+
+	expectStatus(t, do(t, router, "POST", "/checkout", reader, `{}`), http.StatusBadRequest)
+
+	// a declined card places nothing and keeps the cart
+	recorder := checkout(payment.MethodDecline)
+	expectStatus(t, recorder, http.StatusPaymentRequired)
+
+	if details := decode[problem.Details](t, recorder); details.Type != problem.TypePaymentDeclined {
+		t.Fatalf("expected a payment declined problem, got %+v", details)
+	}
+
+	if orders := decode[[]models.Order](t, do(t, router, "GET", "/orders", reader, nil)); len(orders) != 0 || reserved() != 0 {
+		t.Fatalf("a declined checkout placed %+v", orders)
+	}
+
+	if cart := decode[models.PricedCart](t, do(t, router, "GET", "/cart", reader, nil)); cart.ItemCount != 1 {
+		t.Fatalf("a declined checkout emptied the cart: %+v", cart)
+	}
+
+	expectStatus(t, do(t, router, "DELETE", "/cart", reader, nil), http.StatusNoContent)
+
+	// a delayed confirmation keeps the order unpaid until the webhook
+	recorder = checkout(payment.MethodDelayed)
+	expectStatus(t, recorder, http.StatusCreated)
+	delayed := decode[models.Order](t, recorder)
+
+	if delayed.Payment == nil || delayed.Payment.Status != payment.StatusPending || delayed.Payment.Amount != 3000 || reserved() != 1 {
+		t.Fatalf("expected a pending payment holding the copy, got %+v", delayed.Payment)
+	}
+
+	recorder = pay(delayed)
+	expectStatus(t, recorder, http.StatusConflict)
+
+	if details := decode[problem.Details](t, recorder); details.Type != problem.TypeInvalidTransition {
+		t.Fatalf("expected an invalid transition problem, got %+v", details)
+	}
+
+	payload, signature, err := gateway.Confirm(delayed.Payment.ID)
+
+	if err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+
+	expectStatus(t, webhook(payload, "sha256=00"), http.StatusUnauthorized)
+	expectStatus(t, webhook([]byte(`{"status":"authorized"}`), signature), http.StatusUnauthorized)
+	expectStatus(t, webhook(payload, signature), http.StatusNoContent)
+
+	if confirmed := getOrder(delayed); confirmed.Status != models.OrderPending || confirmed.Payment.Status != payment.StatusAuthorized {
+		t.Fatalf("expected an authorized pending order, got %+v", confirmed)
+	}
+
+	// deliveries are retried, the same event twice changes nothing
+	expectStatus(t, webhook(payload, signature), http.StatusNoContent)
+
+	recorder = pay(delayed)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if paid := decode[models.Order](t, recorder); paid.Payment.Status != payment.StatusCaptured {
+		t.Fatalf("expected the payment captured, got %+v", paid.Payment)
+	}
+
+	// a delayed decline cancels the order and frees its copy
+	recorder = checkout(payment.MethodDelayedDecline)
+	expectStatus(t, recorder, http.StatusCreated)
+	declined := decode[models.Order](t, recorder)
+
+	if reserved() != 2 {
+		t.Fatalf("expected two copies reserved, got %d", reserved())
+	}
+
+	payload, signature, _ = gateway.Confirm(declined.Payment.ID)
+	expectStatus(t, webhook(payload, signature), http.StatusNoContent)
+	cancelled := getOrder(declined)
+
+	if cancelled.Status != models.OrderCancelled || cancelled.Payment.Status != payment.StatusDeclined || cancelled.History[1].Actor != "payments" {
+		t.Fatalf("expected the provider to cancel the order, got %+v", cancelled)
+	}
+
+	if reserved() != 1 {
+		t.Fatalf("expected the declined order to free its copy, %d reserved", reserved())
+	}
+
+	// cancelling voids the authorization, and the provider never confirms it
+	recorder = checkout(payment.MethodDelayed)
+	expectStatus(t, recorder, http.StatusCreated)
+	voided := decode[models.Order](t, recorder)
+	recorder = do(t, router, "POST", "/orders/"+voided.ID.Hex()+"/transitions", reader, `{"status":"cancelled"}`)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if order := decode[models.Order](t, recorder); order.Payment.Status != payment.StatusVoided {
+		t.Fatalf("expected the payment voided, got %+v", order.Payment)
+	}
+
+	if _, _, err := gateway.Confirm(voided.Payment.ID); err == nil {
+		t.Fatal("the gateway confirmed a voided payment")
+	}
+
+	// an event for a payment of no order
+	other, _ := gateway.Authorize(payment.Request{Reference: primitive.NewObjectID().Hex(), Amount: 100, Method: payment.MethodDelayed}, context.Background())
+	payload, signature, _ = gateway.Confirm(other.ID)
+	expectStatus(t, webhook(payload, signature), http.StatusNotFound)
+
+	// without a provider nothing can be bought, and paid orders wait
+	recorder = checkout(payment.MethodApprove)
+	expectStatus(t, recorder, http.StatusCreated)
+	waiting := decode[models.Order](t, recorder)
+	controllers.Payments = nil
+
+	expectStatus(t, do(t, router, "POST", "/checkout", reader, `{"payment_method":"fake_approve"}`), http.StatusServiceUnavailable)
+	expectStatus(t, webhook(payload, signature), http.StatusServiceUnavailable)
+	expectStatus(t, do(t, router, "POST", "/orders/"+waiting.ID.Hex()+"/transitions", admin, `{"status":"paid"}`), http.StatusServiceUnavailable)
+
+	if order := getOrder(waiting); order.Status != models.OrderPending {
+		t.Fatalf("expected the order left pending, got %s", order.Status)
+	}
+}
+
+func TestPaymentTransitionsClaimFirst(t *testing.T) {
+	router := newRouter(t)
+	provider := &flakyProvider{PaymentProvider: payment.NewFakeGateway("test-secret")}
+	controllers.Payments = provider
+
+	admin := login(t, router, adminName, adminPassword).AccessToken
+	reader := registerAndLogin(t, router, "reader").AccessToken
+	book := sampleBook("Go", "Someone", 3000, 2020)
+	book.Stock = 5
+	book = createBook(t, router, admin, book)
+
+	checkout := func() models.Order {
+		t.Helper()
+		expectStatus(t, do(t, router, "POST", "/cart/items", reader, `{"book_id":"`+book.ID.Hex()+`","quantity":1}`), http.StatusOK)
+		recorder := do(t, router, "POST", "/checkout", reader, `{"payment_method":"`+payment.MethodApprove+`"}`)
+		expectStatus(t, recorder, http.StatusCreated)
+		return decode[models.Order](t, recorder)
+	}
+
+	move := func(order models.Order, status string) *httptest.ResponseRecorder {
+		return do(t, router, "POST", "/orders/"+order.ID.Hex()+"/transitions", admin, `{"status":"`+status+`"}`)
+	}
+
+	getOrder := func(order models.Order) models.Order {
+		t.Helper()
+		return decode[models.Order](t, do(t, router, "GET", "/orders/"+order.ID.Hex(), admin, nil))
+	}
+
+	// a transition lost to a concurrent one never reaches the provider,
+	// which would have captured the payment
+	order := checkout()
+	orders := repository.Orders
+	repository.Orders = conflictingOrders{orders}
+	recorder := move(order, models.OrderPaid)
+	repository.Orders = orders
+	expectStatus(t, recorder, http.StatusConflict)
+
+	if details := decode[problem.Details](t, recorder); details.Type != problem.TypeConcurrentWrite {
+		t.Fatalf("expected a concurrent write problem, got %+v", details)
+	}
+
+	if provider.captures != 0 {
+		t.Fatalf("the provider was called %d times for a transition that did not happen", provider.captures)
+	}
+
+	if stored := getOrder(order); stored.Status != models.OrderPending || stored.Payment.Status != payment.StatusAuthorized || stored.Payment.Settling != "" {
+		t.Fatalf("the lost transition changed the order: %+v", stored)
+	}
+
+	// a refused capture moves the order back to pending
+	provider.captureErr = payment.ErrDeclined
+	expectStatus(t, move(order, models.OrderPaid), http.StatusPaymentRequired)
+
+	if stored := getOrder(order); stored.Status != models.OrderPending || stored.Payment.Status != payment.StatusAuthorized || stored.Payment.Settling != "" {
+		t.Fatalf("expected the order pending with its payment authorized, got %+v", stored)
+	}
+
+	// a capture that timed out may have charged the customer, so the order
+	// stays paid and the capture is retried
+	provider.captureErr = errors.New("gateway timeout")
+	recorder = move(order, models.OrderPaid)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if moved := decode[models.Order](t, recorder); moved.Status != models.OrderPaid || moved.Payment.Settling != models.SettleCapture {
+		t.Fatalf("expected the order paid with its capture pending, got %+v", moved)
+	}
+
+	provider.captureErr = nil
+
+	if settled, err := controllers.RetryPayments(0, context.Background()); err != nil || settled != 1 {
+		t.Fatalf("expected the capture settled, got %d, %v", settled, err)
+	}
+
+	if paid := getOrder(order); paid.Payment.Status != payment.StatusCaptured || paid.Payment.Settling != "" {
+		t.Fatalf("expected the payment captured, got %+v", paid.Payment)
+	}
+
+	// a failed refund keeps the cancellation and is retried later
+	cancelled := checkout()
+	provider.refundErr = errors.New("gateway timeout")
+	recorder = move(cancelled, models.OrderCancelled)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if moved := decode[models.Order](t, recorder); moved.Status != models.OrderCancelled || moved.Payment.Settling != models.SettleRefund {
+		t.Fatalf("expected the order cancelled with its refund pending, got %+v", moved)
+	}
+
+	if settled, err := controllers.RetryPayments(0, context.Background()); err != nil || settled != 0 {
+		t.Fatalf("expected nothing settled while the provider fails, got %d, %v", settled, err)
+	}
+
+	provider.refundErr = nil
+
+	if settled, err := controllers.RetryPayments(0, context.Background()); err != nil || settled != 1 {
+		t.Fatalf("expected the refund settled, got %d, %v", settled, err)
+	}
+
+	if stored := getOrder(cancelled); stored.Payment.Status != payment.StatusVoided || stored.Payment.Settling != "" {
+		t.Fatalf("expected the payment voided, got %+v", stored.Payment)
+	}
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+	user := registerAndLogin(t, router, "reader").AccessToken
+
+	book := createBook(t, router, admin, sampleBook("Clean Code", "Robert Martin", 25, 2008))
+	createBook(t, router, admin, sampleBook("Clean Architecture", "Robert Martin", 30, 2017))
+	path := "/book/" + book.ID.Hex()
+
+	expectStatus(t, do(t, router, "DELETE", path, admin, nil, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, do(t, router, "GET", path, admin, nil), http.StatusNotFound)
+	expectStatus(t, do(t, router, "PUT", path, admin, book, "If-Match", "*"), http.StatusNotFound)
+
+	if page := decode[models.BookPage](t, do(t, router, "GET", "/books", admin, nil)); page.Total != 1 {
+		t.Fatalf("deleted book still listed: %+v", page)
+	}
+
+	if results := decode[[]models.SearchResult](t, do(t, router, "GET", "/books/search?q=code", admin, nil)); len(results) != 0 {
+		t.Fatalf("deleted book still searchable: %+v", results)
+	}
+
+	// the trash is admin only and records who deleted what
+	expectStatus(t, do(t, router, "GET", "/books/trash", user, nil), http.StatusForbidden)
+	trash := decode[models.BookPage](t, do(t, router, "GET", "/books/trash", admin, nil))
+
+	if trash.Total != 1 || trash.Data[0].ID != book.ID || trash.Data[0].DeletedBy != adminName || trash.Data[0].DeletedAt == nil {
+		t.Fatalf("unexpected trash %+v", trash)
+	}
+
+	// restore puts it back in the catalog and the index
+	recorder := do(t, router, "POST", path+"/restore", admin, nil)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if restored := decode[models.Book](t, recorder); restored.DeletedAt != nil || restored.Title != book.Title {
+		t.Fatalf("unexpected restored book %+v", restored)
+	}
+
+	expectStatus(t, do(t, router, "POST", path+"/restore", admin, nil), http.StatusNotFound)
+	expectStatus(t, do(t, router, "GET", path, admin, nil), http.StatusOK)
+
+	if results := decode[[]models.SearchResult](t, do(t, router, "GET", "/books/search?q=code", admin, nil)); len(results) != 1 {
+		t.Fatalf("restored book not searchable: %+v", results)
+	}
+
+	// purge only removes books older than the retention
+	bulkDelete(t, router, admin, "")
+
+	if count, err := controllers.PurgeTrash(time.Hour, context.Background()); err != nil || count != 0 {
+		t.Fatalf("purged recent deletes: %d %v", count, err)
+	}
+
+	if count, err := controllers.PurgeTrash(0, context.Background()); err != nil || count != 2 {
+		t.Fatalf("expected 2 purged books, got %d %v", count, err)
+	}
+
+	expectStatus(t, do(t, router, "POST", path+"/restore", admin, nil), http.StatusNotFound)
+}
+
+func TestBulkDeleteSafeguards(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+
+	createBook(t, router, admin, sampleBook("Clean Code", "Robert Martin", 25, 2008))
+	architecture := createBook(t, router, admin, sampleBook("Clean Architecture", "Robert Martin", 30, 2017))
+	createBook(t, router, admin, sampleBook("Refactoring", "Martin Fowler", 40, 2018))
+
+	// the dry run deletes nothing
+	recorder := do(t, router, "DELETE", "/books?author=robert+martin", admin, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	preview := decode[models.BulkDeletePreview](t, recorder)
+
+	if preview.Count != 2 || preview.ConfirmationToken == "" || !preview.ExpiresAt.After(time.Now()) {
+		t.Fatalf("unexpected preview %+v", preview)
+	}
+
+	if page := decode[models.BookPage](t, do(t, router, "GET", "/books", admin, nil)); page.Total != 3 {
+		t.Fatalf("dry run deleted books: %+v", page)
+	}
+
+	// the token is bound to the filter and single use
+	confirm := "&confirm=" + preview.ConfirmationToken
+	expectStatus(t, do(t, router, "DELETE", "/books?author=Martin+Fowler"+confirm, admin, nil), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "DELETE", "/books?author=robert+martin"+confirm, admin, nil), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "DELETE", "/books?confirm=made-up", admin, nil), http.StatusBadRequest)
+
+	// it is bound to the admin who ran the dry run
+	other := "other-admin"
+	hash, _ := bcrypt.GenerateFromPassword([]byte(adminPassword), bcrypt.MinCost)
+	repository.Users.Insert(models.User{Name: other, Role: models.RoleAdmin, PasswordHash: string(hash)}, context.Background())
+	otherToken := login(t, router, other, adminPassword).AccessToken
+	preview = decode[models.BulkDeletePreview](t, do(t, router, "DELETE", "/books?author=Robert+Martin", admin, nil))
+	expectStatus(t, do(t, router, "DELETE", "/books?author=Robert+Martin&confirm="+preview.ConfirmationToken, otherToken, nil), http.StatusBadRequest)
+
+	// a catalog change between dry run and confirmation is refused
+	preview = decode[models.BulkDeletePreview](t, do(t, router, "DELETE", "/books?author=Robert+Martin", admin, nil))
+	coder := createBook(t, router, admin, sampleBook("The Clean Coder", "Robert Martin", 20, 2011))
+	expectStatus(t, do(t, router, "DELETE", "/books?author=Robert+Martin&confirm="+preview.ConfirmationToken, admin, nil), http.StatusConflict)
+
+	// so is a swap of matching books that keeps the count
+	preview = decode[models.BulkDeletePreview](t, do(t, router, "DELETE", "/books?author=Robert+Martin&max_year=2015", admin, nil))
+	expectStatus(t, do(t, router, "PATCH", "/book/"+coder.ID.Hex(), admin, `{"published_year":2019}`, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, do(t, router, "PATCH", "/book/"+architecture.ID.Hex(), admin, `{"published_year":2010}`, "If-Match", "*"), http.StatusOK)
+
+	if swapped := decode[models.BulkDeletePreview](t, do(t, router, "DELETE", "/books?author=Robert+Martin&max_year=2015", admin, nil)); swapped.Count != preview.Count {
+		t.Fatalf("expected the same count after the swap, got %+v and %+v", preview, swapped)
+	}
+
+	expectStatus(t, do(t, router, "DELETE", "/books?author=Robert+Martin&max_year=2015&confirm="+preview.ConfirmationToken, admin, nil), http.StatusConflict)
+
+	result := bulkDelete(t, router, admin, "author=Robert+Martin&max_year=2015")
+
+	if result.Deleted != 2 || result.SnapshotID == "" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	if page := decode[models.BookPage](t, do(t, router, "GET", "/books", admin, nil)); page.Total != 2 {
+		t.Fatalf("expected 2 books left, got %+v", page)
+	}
+
+	// the deleted books were snapshotted first
+	recorder = do(t, router, "GET", "/books/snapshots/"+result.SnapshotID, admin, nil)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if snapshot := decode[models.BookSnapshot](t, recorder); len(snapshot.Books) != 2 || snapshot.TakenBy != adminName || snapshot.Books[0].InTrash() {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	expectStatus(t, do(t, router, "GET", "/books/snapshots/missing", admin, nil), http.StatusNotFound)
+}
+
+func TestAuditLog(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+
+	start := time.Now().UTC().Add(-time.Second)
+	book := createBook(t, router, admin, sampleBook("Clean Code", "Robert Martin", 25, 2008))
+	other := createBook(t, router, admin, sampleBook("Refactoring", "Martin Fowler", 40, 2018))
+	path := "/book/" + book.ID.Hex()
+
+	book.Price = 30
+	recorder := do(t, router, "PUT", path, admin, book, "X-Request-ID", "req-update-1", "If-Match", "*")
+	expectStatus(t, recorder, http.StatusOK)
+
+	if recorder.Header().Get("X-Request-ID") != "req-update-1" {
+		t.Fatalf("request id not echoed: %v", recorder.Header())
+	}
+
+	expectStatus(t, do(t, router, "PATCH", path, admin, `{"category":"Craft"}`, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, do(t, router, "DELETE", path, admin, nil, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, do(t, router, "POST", path+"/restore", admin, nil), http.StatusOK)
+	bulkDelete(t, router, admin, "author=Martin+Fowler")
+
+	// the log is admin only
+	user := registerAndLogin(t, router, "reader").AccessToken
+	expectStatus(t, do(t, router, "GET", "/audit", user, nil), http.StatusForbidden)
+	expectStatus(t, do(t, router, "GET", "/audit?from=yesterday", admin, nil), http.StatusBadRequest)
+
+	page := decode[models.AuditPage](t, do(t, router, "GET", "/audit?book_id="+book.ID.Hex(), admin, nil))
+	actions := []string{}
+
+	for _, entry := range page.Data {
+		actions = append(actions, entry.Action)
+
+		if entry.Actor != adminName || entry.Role != models.RoleAdmin || entry.RequestID == "" || entry.BookID != book.ID {
+			t.Fatalf("incomplete entry %+v", entry)
+		}
+	}
+
+	if strings.Join(actions, ",") != "restore,delete,patch,update,create" {
+		t.Fatalf("unexpected actions, newest first: %v", actions)
+	}
+
+	update := page.Data[3]
+
+	if update.RequestID != "req-update-1" || len(update.Changes) != 1 || update.Changes[0].Field != "price" || update.Changes[0].Before != float64(25) || update.Changes[0].After != float64(30) {
+		t.Fatalf("unexpected update entry %+v", update)
+	}
+
+	// filters by actor, time range and paging
+	if page = decode[models.AuditPage](t, do(t, router, "GET", "/audit?book_id="+other.ID.Hex(), admin, nil)); len(page.Data) != 2 || page.Data[0].Action != models.AuditBulkDelete {
+		t.Fatalf("unexpected entries for the bulk deleted book %+v", page)
+	}
+
+	if page = decode[models.AuditPage](t, do(t, router, "GET", "/audit?actor=nobody", admin, nil)); len(page.Data) != 0 {
+		t.Fatalf("unexpected entries for unknown actor %+v", page)
+	}
+
+	if page = decode[models.AuditPage](t, do(t, router, "GET", "/audit?to="+start.Format(time.RFC3339), admin, nil)); len(page.Data) != 0 {
+		t.Fatalf("unexpected entries before the test started %+v", page)
+	}
+
+	page = decode[models.AuditPage](t, do(t, router, "GET", "/audit?actor=admin&limit=4", admin, nil))
+
+	if len(page.Data) != 4 || page.NextCursor == "" {
+		t.Fatalf("expected a full first page %+v", page)
+	}
+
+	if page = decode[models.AuditPage](t, do(t, router, "GET", "/audit?actor=admin&limit=4&cursor="+page.NextCursor, admin, nil)); len(page.Data) != 3 {
+		t.Fatalf("expected the 3 remaining entries %+v", page)
+	}
+}
+
+func TestHistoryAndRollback(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+
+	book := createBook(t, router, admin, sampleBook("Clean Code", "Robert Martin", 25, 2008))
+	path := "/book/" + book.ID.Hex()
+
+	book.Price = 30
+	expectStatus(t, do(t, router, "PUT", path, admin, book, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, do(t, router, "PATCH", path, admin, `{"title":"Clean Code, 2nd edition"}`, "If-Match", "*"), http.StatusOK)
+
+	user := registerAndLogin(t, router, "reader").AccessToken
+	expectStatus(t, do(t, router, "GET", path+"/history", user, nil), http.StatusForbidden)
+	expectStatus(t, do(t, router, "GET", "/book/000000000000000000000000/history", admin, nil), http.StatusNotFound)
+
+	history := decode[[]models.BookRevision](t, do(t, router, "GET", path+"/history", admin, nil))
+
+	if len(history) != 3 || history[0].Version != 1 || history[2].Version != 3 || history[2].Action != models.AuditPatch {
+		t.Fatalf("unexpected history %+v", history)
+	}
+
+	if changes := history[1].Changes; len(changes) != 1 || changes[0].Field != "price" || changes[0].Before != float64(25) {
+		t.Fatalf("unexpected diff between v1 and v2 %+v", changes)
+	}
+
+	// rolling back writes the old content as a new version
+	expectStatus(t, do(t, router, "POST", path+"/rollback", admin, `{"version":9}`, "If-Match", "*"), http.StatusNotFound)
+	expectStatus(t, do(t, router, "POST", path+"/rollback", admin, `{}`, "If-Match", "*"), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "POST", path+"/rollback", admin, `{"version":1}`, "If-Match", `"v1"`), http.StatusPreconditionFailed)
+
+	recorder := do(t, router, "POST", path+"/rollback", admin, `{"version":1}`, "If-Match", `"v3"`)
+	expectStatus(t, recorder, http.StatusOK)
+	rolledBack := decode[models.Book](t, recorder)
+
+	if rolledBack.Version != 4 || rolledBack.Title != "Clean Code" || rolledBack.Price != 25 {
+		t.Fatalf("unexpected rollback result %+v", rolledBack)
+	}
+
+	history = decode[[]models.BookRevision](t, do(t, router, "GET", path+"/history", admin, nil))
+
+	if last := history[len(history)-1]; last.Action != models.AuditRollback || len(last.Changes) != 2 {
+		t.Fatalf("unexpected rollback revision %+v", last)
+	}
+
+	// deletes are revisions too, and deleted books keep their history
+	expectStatus(t, do(t, router, "DELETE", path, admin, nil, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, do(t, router, "POST", path+"/rollback", admin, `{"version":1}`, "If-Match", "*"), http.StatusNotFound)
+
+	if history = decode[[]models.BookRevision](t, do(t, router, "GET", path+"/history", admin, nil)); len(history) != 5 || !history[4].Book.InTrash() {
+		t.Fatalf("unexpected history after delete %+v", history)
+	}
+}
+
+func TestImportBooks(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+
+	csvFile := "Title,Author,Price,published_year\n" +
+		"Clean Code,Robert Martin,25,2008\n" +
+		"No Author,,10,2000\n" +
+		"Refactoring,Martin Fowler,cheap,2018\n" +
+		"Too,Many,Fields,1,2\n" +
+		"\"Domain-Driven Design\",Eric Evans,45,2003\n"
+
+	recorder := do(t, router, "POST", "/books/import", admin, csvFile, "Content-Type", "text/csv")
+	expectStatus(t, recorder, http.StatusOK)
+	report := decode[models.ImportReport](t, recorder)
+
+	if report.Total != 5 || report.Accepted != 2 || report.Rejected != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	for i, want := range []string{models.ImportAccepted, models.ImportRejected, models.ImportRejected, models.ImportRejected, models.ImportAccepted} {
+		if row := report.Rows[i]; row.Status != want || row.Line != i+2 {
+			t.Fatalf("row %d: expected %s on line %d, got %+v", i, want, i+2, row)
+		}
+	}
+
+	if report.Rows[2].Error != "price must be an integer" {
+		t.Fatalf("unexpected rejection reason %+v", report.Rows[2])
+	}
+
+	// imported books are stored, searchable and audited like single creates
+	expectStatus(t, do(t, router, "GET", "/book/"+report.Rows[0].ID, admin, nil), http.StatusOK)
+
+	if results := decode[[]models.SearchResult](t, do(t, router, "GET", "/books/search?q=evans", admin, nil)); len(results) != 1 {
+		t.Fatalf("imported book not searchable: %+v", results)
+	}
+
+	if page := decode[models.AuditPage](t, do(t, router, "GET", "/audit?book_id="+report.Rows[4].ID, admin, nil)); len(page.Data) != 1 || page.Data[0].Action != models.AuditCreate {
+		t.Fatalf("imported book not audited: %+v", page)
+	}
+
+	// JSON Lines as a background job
+	ndjson := `{"title":"Refactoring","author":"Martin Fowler","price":40}` + "\n\n" + `{"title":` + "\n"
+	recorder = do(t, router, "POST", "/books/import?async=true", admin, ndjson, "Content-Type", "application/x-ndjson")
+	expectStatus(t, recorder, http.StatusAccepted)
+	location := recorder.Header().Get("Location")
+	job := decode[models.ImportJob](t, recorder)
+
+	for deadline := time.Now().Add(5 * time.Second); job.Status != models.JobDone; {
+		if time.Now().After(deadline) || job.Status == models.JobFailed {
+			t.Fatalf("import job did not finish: %+v", job)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		job = decode[models.ImportJob](t, do(t, router, "GET", location, admin, nil))
+	}
+
+	if job.Report.Accepted != 1 || job.Report.Rejected != 1 || job.Report.Rows[1].Line != 3 || job.Actor != adminName {
+		t.Fatalf("unexpected job %+v", job.Report)
+	}
+
+	// whole-upload errors
+	expectStatus(t, do(t, router, "POST", "/books/import", admin, csvFile, "Content-Type", "application/xml"), http.StatusUnsupportedMediaType)
+	expectStatus(t, do(t, router, "POST", "/books/import?format=csv", admin, "title,color\nA,red\n"), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "POST", "/books/import?format=csv", admin, ""), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "GET", "/books/import/jobs/missing", admin, nil), http.StatusNotFound)
+}
+
+func TestValidationErrors(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+
+	rules := func(recorder *httptest.ResponseRecorder) map[string]string {
+		t.Helper()
+		expectStatus(t, recorder, http.StatusBadRequest)
+		found := map[string]string{}
+
+		for _, err := range decode[problem.Details](t, recorder).Errors {
+			found[err.Field] = err.Rule
+		}
+
+		return found
+	}
+
+	// every broken rule is reported at once
+	nextYear := time.Now().Year() + 1
+	got := rules(do(t, router, "POST", "/book", admin, fmt.Sprintf(`{"author":"A","price":-5,"published_year":%d,"isbn":"123"}`, nextYear)))
+	want := map[string]string{"title": "required", "price": "min", "published_year": "max", "isbn": "format"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	for body, want := range map[string]map[string]string{
+		`{"title":"A","author":"B","price":1,"colour":"red"}`: {"colour": "unknown_field"},
+		`{"title":"A","author":"B","price":"free"}`:           {"price": "type"},
+		`{"title":"A"`: {"": "syntax"},
+		`{"title":"A","author":"B","price":1} {}`: {"": "syntax"},
+		``: {"": "required"},
+	} {
+		if got := rules(do(t, router, "POST", "/book", admin, body)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %v, want %v", body, got, want)
+		}
+	}
+
+	// a patch is checked once merged
+	book := createBook(t, router, admin, sampleBook("Clean Code", "Robert Martin", 25, 2008))
+	path := "/book/" + book.ID.Hex()
+
+	if got := rules(do(t, router, "PATCH", path, admin, `{"price":200000000,"colour":"red"}`, "If-Match", "*")); got["colour"] != "unknown_field" {
+		t.Fatalf("unexpected rules %v", got)
+	}
+
+	if got := rules(do(t, router, "PATCH", path, admin, `{"price":200000000}`, "If-Match", "*")); got["price"] != "max" {
+		t.Fatalf("unexpected rules %v", got)
+	}
+
+	// users are checked the same way, lengths only for new accounts
+	if got := rules(do(t, router, "POST", "/register", "", `{"name":"bob","password":"short"}`)); got["password"] != "min_length" {
+		t.Fatalf("unexpected rules %v", got)
+	}
+
+	if got := rules(do(t, router, "POST", "/token", "", `{"name":"bob"}`)); got["password"] != "required" {
+		t.Fatalf("unexpected rules %v", got)
+	}
+
+	expectStatus(t, do(t, router, "POST", "/token", "", `{"name":"bob","password":"short"}`), http.StatusUnauthorized)
+}
+
+func TestProblemDetails(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+	user := registerAndLogin(t, router, "reader").AccessToken
+
+	book := sampleBook("Problems", "Someone", 10, 2020)
+	book.Isbn = "9780134190440"
+	book = createBook(t, router, admin, book)
+	path := "/book/" + book.ID.Hex()
+
+	duplicate := sampleBook("Copy", "Someone", 10, 2020)
+	duplicate.Isbn = book.Isbn
+
+	missing := "/book/" + primitive.NewObjectID().Hex()
+
+	for _, c := range []struct {
+		recorder     *httptest.ResponseRecorder
+		status       int
+		problemType  string
+		instance     string
+		fieldsListed bool
+	}{
+		{do(t, router, "GET", missing, admin, nil), http.StatusNotFound, "about:blank", missing, false},
+		{do(t, router, "GET", "/no/such/route", admin, nil), http.StatusNotFound, "about:blank", "/no/such/route", false},
+		{do(t, router, "PUT", "/health", "", nil), http.StatusMethodNotAllowed, "about:blank", "/health", false},
+		{do(t, router, "GET", path, "", nil), http.StatusUnauthorized, "about:blank", path, false},
+		{do(t, router, "DELETE", path, user, nil), http.StatusForbidden, "about:blank", path, false},
+		{do(t, router, "PUT", path, admin, book, "If-Match", `"v0"`), http.StatusPreconditionFailed, "/problems/precondition-failed", path, false},
+		{do(t, router, "POST", "/book", admin, duplicate), http.StatusConflict, "/problems/duplicate", "/book", false},
+		{do(t, router, "POST", "/book", admin, `{"title":""}`), http.StatusBadRequest, "/problems/validation", "/book", true},
+	} {
+		expectStatus(t, c.recorder, c.status)
+
+		if contentType := c.recorder.Header().Get("Content-Type"); contentType != problem.MediaType {
+			t.Fatalf("%s: expected %s, got %q", c.instance, problem.MediaType, contentType)
+		}
+
+		details := decode[problem.Details](t, c.recorder)
+
+		if details.Type != c.problemType || details.Status != c.status || details.Title == "" || details.Detail == "" {
+			t.Fatalf("%s: unexpected problem %+v", c.instance, details)
+		}
+
+		if details.Instance != c.instance {
+			t.Fatalf("expected instance %q, got %q", c.instance, details.Instance)
+		}
+
+		if details.RequestID == "" || details.RequestID != c.recorder.Header().Get("X-Request-ID") {
+			t.Fatalf("%s: request id %q does not match the response header", c.instance, details.RequestID)
+		}
+
+		if (len(details.Errors) > 0) != c.fieldsListed {
+			t.Fatalf("%s: unexpected field errors %v", c.instance, details.Errors)
+		}
+	}
+}
+
+func TestIsbn(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+
+	book := sampleBook("The Go Programming Language", "Alan Donovan", 30, 2015)
+	book.Isbn = "0-13-419044-0"
+	book = createBook(t, router, admin, book)
+
+	if book.Isbn != "978-0-13-419044-0" {
+		t.Fatalf("expected a hyphenated ISBN-13, got %q", book.Isbn)
+	}
+
+	invalid := sampleBook("Typo", "Someone", 10, 2020)
+	invalid.Isbn = "978-0-13-419044-1"
+	expectStatus(t, do(t, router, "POST", "/book", admin, invalid), http.StatusBadRequest)
+
+	// the same ISBN written another way is a duplicate
+	duplicate := sampleBook("Copy", "Someone", 10, 2020)
+	duplicate.Isbn = "9780134190440"
+	expectStatus(t, do(t, router, "POST", "/book", admin, duplicate), http.StatusConflict)
+
+	for _, value := range []string{"9780134190440", "978-0-13-419044-0", "0134190440"} {
+		recorder := do(t, router, "GET", "/books/isbn/"+value, admin, nil)
+		expectStatus(t, recorder, http.StatusOK)
+
+		if found := decode[models.Book](t, recorder); found.ID != book.ID {
+			t.Fatalf("%s found %+v", value, found)
+		}
+	}
+
+	expectStatus(t, do(t, router, "GET", "/books/isbn/9781593275846", admin, nil), http.StatusNotFound)
+	expectStatus(t, do(t, router, "GET", "/books/isbn/12345", admin, nil), http.StatusBadRequest)
+
+	// another book cannot take the ISBN through an update or a patch
+	other := createBook(t, router, admin, sampleBook("Other", "Someone", 10, 2020))
+	other.Isbn = "0134190440"
+	expectStatus(t, do(t, router, "PUT", "/book/"+other.ID.Hex(), admin, other, "If-Match", "*"), http.StatusConflict)
+	expectStatus(t, do(t, router, "PATCH", "/book/"+other.ID.Hex(), admin, `{"isbn":"9780134190440"}`, "If-Match", "*"), http.StatusConflict)
+	expectStatus(t, do(t, router, "PATCH", "/book/"+other.ID.Hex(), admin, `{"isbn":"not an isbn"}`, "If-Match", "*"), http.StatusBadRequest)
+
+	// a book in the trash keeps its ISBN
+	expectStatus(t, do(t, router, "DELETE", "/book/"+book.ID.Hex(), admin, nil, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, do(t, router, "POST", "/book", admin, duplicate), http.StatusConflict)
+	expectStatus(t, do(t, router, "GET", "/books/isbn/9780134190440", admin, nil), http.StatusNotFound)
+
+	// imports reject invalid and duplicate ISBNs row by row
+	csvFile := "title,author,price,isbn\nA,B,1,9780134190440\nC,D,1,123\nE,F,1,9781593275846\nG,H,1,1593275846\n"
+	report := decode[models.ImportReport](t, do(t, router, "POST", "/books/import", admin, csvFile, "Content-Type", "text/csv"))
+
+	if report.Accepted != 1 || report.Rows[2].Status != models.ImportAccepted || report.Rows[0].Error == "" || report.Rows[1].Error == "" || report.Rows[3].Status != models.ImportRejected {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestExportBooks(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+	user := registerAndLogin(t, router, "finance").AccessToken
+
+	createBook(t, router, admin, sampleBook("Clean Code", "Robert Martin", 25, 2008))
+	createBook(t, router, admin, sampleBook("=HYPERLINK(\"x\")", "Robert Martin", 30, 2017))
+	createBook(t, router, admin, sampleBook("Refactoring", "Martin Fowler", 40, 2018))
+
+	// CSV with the default columns, filtered and sorted like the listing
+	recorder := do(t, router, "GET", "/books/export?author=Robert+Martin&sort=-price", user, nil)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/csv") || !strings.Contains(recorder.Header().Get("Content-Disposition"), "books.csv") {
+		t.Fatalf("unexpected headers %v", recorder.Header())
+	}
+
+	want := "title,author,isbn,published_year,price,category\n" +
+		"\"=HYPERLINK(\"\"x\"\")\",Robert Martin,,2017,30,Programming\n" +
+		"Clean Code,Robert Martin,,2008,25,Programming\n"
+
+	if recorder.Body.String() != want {
+		t.Fatalf("unexpected CSV:\n%s", recorder.Body.String())
+	}
+
+	// the default CSV can be imported back
+	csvFile := recorder.Body.String()
+	report := decode[models.ImportReport](t, do(t, router, "POST", "/books/import", admin, csvFile, "Content-Type", "text/csv"))
+
+	if report.Accepted != 2 {
+		t.Fatalf("export could not be imported back: %+v", report)
+	}
+
+	// Excel flavor
+	recorder = do(t, router, "GET", "/books/export?format=excel&columns=title,price&max_price=30&sort=price", user, nil)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if body := recorder.Body.String(); !strings.HasPrefix(body, "\uFEFFtitle,price\r\n") || !strings.Contains(body, "'=HYPERLINK") {
+		t.Fatalf("unexpected Excel CSV %q", body)
+	}
+
+	// JSON Lines with chosen columns
+	recorder = do(t, router, "GET", "/books/export?format=ndjson&columns=id,title&author=Martin+Fowler", user, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+
+	var row map[string]any
+
+	if len(lines) != 1 || json.Unmarshal([]byte(lines[0]), &row) != nil || row["title"] != "Refactoring" || len(row) != 2 {
+		t.Fatalf("unexpected NDJSON %q", recorder.Body.String())
+	}
+
+	expectStatus(t, do(t, router, "GET", "/books/export?format=pdf", user, nil), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "GET", "/books/export?columns=title,secret", user, nil), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "GET", "/books/export?limit=1", user, nil), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "GET", "/books/export", "", nil), http.StatusUnauthorized)
+}
+
+func TestOnixFeed(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+	user := registerAndLogin(t, router, "distributor").AccessToken
+
+	existing := createBook(t, router, admin, models.Book{Title: "Old Title", Author: "Robert Martin", Isbn: "9780132350884", Price: 2500, Category: "Programming"})
+
+	product := func(isbn string, title string, price string) string {
+		return `<Product>
+  <RecordReference>` + isbn + `</RecordReference>
+  <NotificationType>03</NotificationType>
+  <ProductIdentifier><ProductIDType>15</ProductIDType><IDValue>` + isbn + `</IDValue></ProductIdentifier>
+  <DescriptiveDetail>
+    <TitleDetail><TitleType>01</TitleType><TitleElement><TitleElementLevel>01</TitleElementLevel><TitleText>` + title + `</TitleText></TitleElement></TitleDetail>
+    <Contributor><SequenceNumber>1</SequenceNumber><ContributorRole>A01</ContributorRole><PersonName>Robert Martin</PersonName></Contributor>
+  </DescriptiveDetail>
+  <PublishingDetail><PublishingDate><PublishingDateRole>01</PublishingDateRole><Date>20080801</Date></PublishingDate></PublishingDetail>
+  <ProductSupply><SupplyDetail><Price><PriceAmount>` + price + `</PriceAmount><CurrencyCode>USD</CurrencyCode></Price></SupplyDetail></ProductSupply>
+</Product>
+`
+	}
+
+	feed := `<?xml version="1.0"?>
+<ONIXMessage release="3.0" xmlns="http://ns.editeur.org/onix/3.0/reference">
+<Header><Sender><SenderName>Distributor</SenderName></Sender><SentDateTime>20250101</SentDateTime></Header>
+` + product("9780132350884", "Clean Code", "37.50") + product("9780137081073", "The Clean Coder", "29.99") + product("", "No ISBN", "10") + `</ONIXMessage>`
+
+	expectStatus(t, do(t, router, "POST", "/books/onix", user, feed), http.StatusForbidden)
+
+	recorder := do(t, router, "POST", "/books/onix", admin, feed, "Content-Type", "application/xml")
+	expectStatus(t, recorder, http.StatusOK)
+	report := decode[models.ImportReport](t, recorder)
+
+	if report.Total != 3 || report.Accepted != 2 || report.Rejected != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	if report.Rows[0].Action != models.ImportUpdated || report.Rows[0].ID != existing.ID.Hex() || report.Rows[1].Action != models.ImportCreated || report.Rows[2].Line != 26 {
+		t.Fatalf("unexpected rows %+v", report.Rows)
+	}
+
+	// the existing book keeps its id and category and takes the feed values
+	updated := decode[models.Book](t, do(t, router, "GET", "/book/"+existing.ID.Hex(), user, nil))
+
+	if updated.Title != "Clean Code" || updated.Price != 3750 || updated.PublishedYear != 2008 || updated.Category != "Programming" || updated.Version != 2 {
+		t.Fatalf("unexpected update %+v", updated)
+	}
+
+	// resending the same feed changes nothing
+	report = decode[models.ImportReport](t, do(t, router, "POST", "/books/onix", admin, feed))
+
+	if report.Rows[0].Action != models.ImportUnchanged || report.Rows[1].Action != models.ImportUnchanged {
+		t.Fatalf("expected unchanged rows, got %+v", report.Rows)
+	}
+
+	expectStatus(t, do(t, router, "POST", "/books/onix", admin, "<ONIXMessage release=\"2.1\"></ONIXMessage>"), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "POST", "/books/onix?currency=dollars", admin, feed), http.StatusBadRequest)
+
+	// export the selected books and read them back
+	recorder = do(t, router, "GET", "/books/onix?ids="+existing.ID.Hex(), user, nil)
+	expectStatus(t, recorder, http.StatusOK)
+
+	products, err := onix.Decode(recorder.Body)
+
+	if err != nil || len(products) != 1 {
+		t.Fatalf("got %d products, %v", len(products), err)
+	}
+
+	if book, err := products[0].Book("USD"); err != nil || book.Title != "Clean Code" || book.Price != 3750 || book.Isbn != "9780132350884" {
+		t.Fatalf("unexpected exported book %+v, %v", book, err)
+	}
+
+	// or every book matching the filters
+	recorder = do(t, router, "GET", "/books/onix?author=robert+martin", user, nil)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if products, err := onix.Decode(recorder.Body); err != nil || len(products) != 2 {
+		t.Fatalf("got %d products, %v", len(products), err)
+	}
+
+	expectStatus(t, do(t, router, "GET", "/books/onix?ids="+primitive.NewObjectID().Hex(), user, nil), http.StatusNotFound)
+	expectStatus(t, do(t, router, "GET", "/books/onix?ids=nope", user, nil), http.StatusBadRequest)
+}
+
+func TestListBooksPaginationAndFilters(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+
+	createBook(t, router, admin, sampleBook("Go", "Alan Donovan", 30, 2015))
+	createBook(t, router, admin, sampleBook("Pearls", "Jon Bentley", 20, 1986))
+	createBook(t, router, admin, sampleBook("Python", "Mark Lutz", 40, 2013))
+	createBook(t, router, admin, sampleBook("Rust", "Steve Klabnik", 35, 2018))
+	createBook(t, router, admin, sampleBook("C", "Brian Kernighan", 25, 1978))
+
+	var titles []string
+	next := "/books?limit=2&sort=-price"
+
+	for next != "" {
+		recorder := do(t, router, "GET", next, admin, nil)
+		expectStatus(t, recorder, http.StatusOK)
+		page := decode[models.BookPage](t, recorder)
+
+		if page.Total != 5 {
+			t.Fatalf("expected total 5, got %d", page.Total)
+		}
+
+		for _, book := range page.Data {
+			titles = append(titles, book.Title)
+		}
+
+		next = page.Next
+	}
+
+	if got := strings.Join(titles, ","); got != "Python,Rust,Go,C,Pearls" {
+		t.Fatalf("unexpected order %s", got)
+	}
+
+	page := decode[models.BookPage](t, do(t, router, "GET", "/books?min_year=2000&max_price=35&sort=title", admin, nil))
+
+	if page.Total != 2 || page.Data[0].Title != "Go" || page.Data[1].Title != "Rust" {
+		t.Fatalf("unexpected filtered page %+v", page)
+	}
+
+	page = decode[models.BookPage](t, do(t, router, "GET", "/books?author=mark%20lutz", admin, nil))
+
+	if page.Total != 1 || page.Data[0].Title != "Python" {
+		t.Fatalf("unexpected author page %+v", page)
+	}
+
+	expectStatus(t, do(t, router, "GET", "/books?limit=0", admin, nil), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "GET", "/books?sort=isbn", admin, nil), http.StatusBadRequest)
+	expectStatus(t, do(t, router, "GET", "/books?cursor=garbage", admin, nil), http.StatusBadRequest)
+}
+
+func TestSearchBooks(t *testing.T) {
+	router := newRouter(t)
+	admin := login(t, router, adminName, adminPassword).AccessToken
+
+	createBook(t, router, admin, sampleBook("The Go Programming Language", "Alan Donovan", 30, 2015))
+	createBook(t, router, admin, sampleBook("Learning Python", "Mark Lutz", 40, 2013))
+
+	for _, q := range []string{"python", "pyth", "pyhton", "lutz"} {
+		results := decode[[]models.SearchResult](t, do(t, router, "GET", "/books/search?q="+q, admin, nil))
+
+		if len(results) != 1 || results[0].Book.Title != "Learning Python" {
+			t.Fatalf("q=%s: unexpected results %+v", q, results)
+		}
+	}
+
+	results := decode[[]models.SearchResult](t, do(t, router, "GET", "/books/search?q=go", admin, nil))
+
+	if len(results) != 1 || results[0].Highlights["title"] != "The <mark>Go</mark> Programming Language" {
+		t.Fatalf("unexpected highlight %+v", results)
+	}
+
+	expectStatus(t, do(t, router, "GET", "/books/search", admin, nil), http.StatusBadRequest)
+
+	// books deleted through another instance are still indexed here, they
+	// must not take places of the page
+	rust := []models.Book{}
+
+	for _, edition := range []string{"First", "Second", "Third", "Fourth"} {
+		rust = append(rust, createBook(t, router, admin, sampleBook("Rust "+edition, "Steve Klabnik", 35, 2018)))
+	}
+
+	for _, book := range rust[:2] {
+		if err := repository.Books.Delete(book.ID, repository.AnyVersion, adminName, time.Now().UTC(), context.Background()); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+	}
+
+	for range 2 {
+		results := decode[[]models.SearchResult](t, do(t, router, "GET", "/books/search?q=rust&limit=2", admin, nil))
+
+		if len(results) != 2 {
+			t.Fatalf("expected a full page, got %+v", results)
+		}
+
+		for _, result := range results {
+			if result.Book.ID == rust[0].ID || result.Book.ID == rust[1].ID {
+				t.Fatalf("a deleted book was found: %+v", result)
+			}
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	router := newRouter(t)
+	limited := middlewares.RateLimiterMiddleware(router)
+
+	// the limiter allows a burst of 10 per Authorization header
+	for i := 0; i < 10; i++ {
+		expectStatus(t, do(t, limited, "GET", "/health", "rate-limit-test", nil), http.StatusOK)
+	}
+
+	expectStatus(t, do(t, limited, "GET", "/health", "rate-limit-test", nil), http.StatusTooManyRequests)
+	expectStatus(t, do(t, limited, "GET", "/health", "another-client", nil), http.StatusOK)
+}
@@ -0,0 +1,386 @@
+package search
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// How much a query term contributes depending on how it matched
+const (
+	exactFactor  = 1.0
+	prefixFactor = 0.75
+	fuzzyFactor  = 0.5
+)
+
+const minPrefixLength = 2
+
+// Result is a matching document with its relevance score. Highlight marks
+// the words it matched with.
+type Result struct {
+	ID    string
+	Score float64
+	terms map[string]bool
+}
+
+// Index is an in-memory inverted index over the text fields of documents.
+// It supports exact, prefix and typo tolerant (edit distance) term matching.
+type Index struct {
+	mutex    sync.RWMutex
+	weights  map[string]float64
+	docs     map[string]map[string]string
+	postings map[string]map[string]map[string]int // term -> doc -> field -> frequency
+	terms    []string                             // sorted vocabulary for prefix lookups
+	dirty    bool
+}
+
+// NewIndex creates an index; fields not in weights get weight 1
+func NewIndex(weights map[string]float64) *Index {
+	return &Index{
+		weights:  weights,
+		docs:     map[string]map[string]string{},
+		postings: map[string]map[string]map[string]int{},
+	}
+}
+
+type token struct {
+	term  string
+	start int
+	end   int
+}
+
+// Split text into lower-cased words, keeping byte offsets for highlighting
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+
+		if isWord && start < 0 {
+			start = i
+		}
+
+		if !isWord && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+
+	return tokens
+}
+
+// Add indexes a document, replacing any previous version with the same id
+func (idx *Index) Add(id string, fields map[string]string) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.removeLocked(id)
+	idx.docs[id] = fields
+
+	for field, text := range fields {
+		for _, tok := range tokenize(text) {
+			docs, ok := idx.postings[tok.term]
+
+			if !ok {
+				docs = map[string]map[string]int{}
+				idx.postings[tok.term] = docs
+				idx.dirty = true
+			}
+
+			if docs[id] == nil {
+				docs[id] = map[string]int{}
+			}
+
+			docs[id][field]++
+		}
+	}
+}
+
+// Remove drops a document from the index
+func (idx *Index) Remove(id string) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.removeLocked(id)
+}
+
+func (idx *Index) removeLocked(id string) {
+	fields, ok := idx.docs[id]
+
+	if !ok {
+		return
+	}
+
+	for _, text := range fields {
+		for _, tok := range tokenize(text) {
+			delete(idx.postings[tok.term], id)
+
+			if len(idx.postings[tok.term]) == 0 {
+				delete(idx.postings, tok.term)
+				idx.dirty = true
+			}
+		}
+	}
+
+	delete(idx.docs, id)
+}
+
+// Reset empties the index
+func (idx *Index) Reset() {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.docs = map[string]map[string]string{}
+	idx.postings = map[string]map[string]map[string]int{}
+	idx.terms = nil
+	idx.dirty = false
+}
+
+// Len returns the number of indexed documents
+func (idx *Index) Len() int {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return len(idx.docs)
+}
+
+// Take the read lock with the sorted vocabulary up to date. The vocabulary
+// is rebuilt by the first reader after a write added or removed a term,
+// under the write lock, and checked again once the read lock is back in
+// case another write landed in between.
+func (idx *Index) readLock() {
+	for {
+		idx.mutex.RLock()
+
+		if !idx.dirty {
+			return
+		}
+
+		idx.mutex.RUnlock()
+		idx.mutex.Lock()
+
+		if idx.dirty {
+			idx.terms = make([]string, 0, len(idx.postings))
+
+			for term := range idx.postings {
+				idx.terms = append(idx.terms, term)
+			}
+
+			sort.Strings(idx.terms)
+			idx.dirty = false
+		}
+
+		idx.mutex.Unlock()
+	}
+}
+
+// Allowed typos grow with the length of the word
+func maxEdits(term string) int {
+	switch n := len([]rune(term)); {
+	case n <= 3:
+		return 0
+	case n <= 7:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Edit distance counting insertions, deletions, substitutions and swaps of
+// adjacent letters (optimal string alignment), giving up once above limit
+func editDistance(a string, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+
+	if diff := len(ra) - len(rb); diff > limit || -diff > limit {
+		return limit + 1
+	}
+
+	beforePrevious := make([]int, len(rb)+1)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		rowMin := current[0]
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				current[j] = min(current[j], beforePrevious[j-2]+1)
+			}
+
+			rowMin = min(rowMin, current[j])
+		}
+
+		if rowMin > limit {
+			return limit + 1
+		}
+
+		beforePrevious, previous, current = previous, current, beforePrevious
+	}
+
+	return previous[len(rb)]
+}
+
+// Vocabulary terms matching a query term and how well they match
+func (idx *Index) expand(query string, terms []string) map[string]float64 {
+	matches := map[string]float64{}
+
+	if _, ok := idx.postings[query]; ok {
+		matches[query] = exactFactor
+	}
+
+	if len([]rune(query)) >= minPrefixLength {
+		for i := sort.SearchStrings(terms, query); i < len(terms) && strings.HasPrefix(terms[i], query); i++ {
+			if _, ok := matches[terms[i]]; !ok {
+				matches[terms[i]] = prefixFactor
+			}
+		}
+	}
+
+	if limit := maxEdits(query); limit > 0 {
+		for _, term := range terms {
+			if _, ok := matches[term]; ok {
+				continue
+			}
+
+			if distance := editDistance(query, term, limit); distance <= limit {
+				matches[term] = fuzzyFactor / float64(distance)
+			}
+		}
+	}
+
+	return matches
+}
+
+// Rank returns every matching document ranked by relevance, without
+// highlights. Every query word may match exactly, as a prefix (for
+// autocomplete) or with a few typos. Documents matching more of the query
+// words rank higher.
+func (idx *Index) Rank(query string) []Result {
+	idx.readLock()
+	defer idx.mutex.RUnlock()
+
+	queryTokens := tokenize(query)
+
+	if len(queryTokens) == 0 {
+		return []Result{}
+	}
+
+	scores := map[string]float64{}
+	covered := map[string]int{}
+	matchedTerms := map[string]map[string]bool{}
+	total := float64(len(idx.docs))
+
+	for _, queryToken := range queryTokens {
+		best := map[string]float64{}
+
+		for term, factor := range idx.expand(queryToken.term, idx.terms) {
+			docs := idx.postings[term]
+			idf := math.Log(1 + total/float64(len(docs)))
+
+			for id, fields := range docs {
+				score := 0.0
+
+				for field, frequency := range fields {
+					weight, ok := idx.weights[field]
+
+					if !ok {
+						weight = 1
+					}
+
+					// saturate repeated words
+					score += weight * float64(frequency) / float64(frequency+1)
+				}
+
+				score *= factor * idf
+
+				if score > best[id] {
+					best[id] = score
+				}
+
+				if matchedTerms[id] == nil {
+					matchedTerms[id] = map[string]bool{}
+				}
+
+				matchedTerms[id][term] = true
+			}
+		}
+
+		for id, score := range best {
+			scores[id] += score
+			covered[id]++
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+
+	for id, score := range scores {
+		coverage := float64(covered[id]) / float64(len(queryTokens))
+		results = append(results, Result{ID: id, Score: score * coverage * coverage, terms: matchedTerms[id]})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+
+		return results[i].ID < results[j].ID
+	})
+
+	return results
+}
+
+// Highlight wraps the words of a ranked document that matched the query in
+// <mark> tags; the rest of the text is HTML escaped
+func (idx *Index) Highlight(result Result) map[string]string {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	highlights := map[string]string{}
+
+	for field, text := range idx.docs[result.ID] {
+		var builder strings.Builder
+		last := 0
+		found := false
+
+		for _, tok := range tokenize(text) {
+			if !result.terms[tok.term] {
+				continue
+			}
+
+			builder.WriteString(html.EscapeString(text[last:tok.start]))
+			builder.WriteString("<mark>")
+			builder.WriteString(html.EscapeString(text[tok.start:tok.end]))
+			builder.WriteString("</mark>")
+			last = tok.end
+			found = true
+		}
+
+		if found {
+			builder.WriteString(html.EscapeString(text[last:]))
+			highlights[field] = builder.String()
+		}
+	}
+
+	return highlights
+}
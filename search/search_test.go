@@ -0,0 +1,187 @@
+package search
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMaxEdits(t *testing.T) {
+	cases := map[string]int{
+		"go":       0,
+		"sql":      0,
+		"rust":     1,
+		"python":   1,
+		"fowlers":  1,
+		"refactor": 2,
+		"élan":     1,
+	}
+
+	for term, want := range cases {
+		if got := maxEdits(term); got != want {
+			t.Fatalf("maxEdits(%q) = %d, want %d", term, got, want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"python", "python", 2, 0},
+		{"python", "pyton", 2, 1},   // deletion
+		{"python", "pythons", 2, 1}, // insertion
+		{"python", "pithon", 2, 1},  // substitution
+		{"python", "pyhton", 2, 1},  // swap of adjacent letters
+		{"kitten", "sitting", 3, 3},
+		{"café", "cafe", 1, 1}, // runes, not bytes
+		// past the limit the distance is only known to be above it
+		{"kitten", "sitting", 1, 2},
+		{"go", "programming", 2, 3},
+	}
+
+	for _, c := range cases {
+		if got := editDistance(c.a, c.b, c.limit); got != c.want {
+			t.Fatalf("editDistance(%q, %q, %d) = %d, want %d", c.a, c.b, c.limit, got, c.want)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	idx := NewIndex(nil)
+	idx.Add("1", map[string]string{"title": "Programming Python programs"})
+	idx.Add("2", map[string]string{"title": "Go in practice"})
+	idx.readLock()
+	defer idx.mutex.RUnlock()
+
+	cases := []struct {
+		query string
+		want  map[string]float64
+	}{
+		{"python", map[string]float64{"python": exactFactor}},
+		{"prog", map[string]float64{"programming": prefixFactor, "programs": prefixFactor}},
+		{"pyhton", map[string]float64{"python": fuzzyFactor}},
+		{"programz", map[string]float64{"programs": fuzzyFactor}},
+		{"progarmming", map[string]float64{"programming": fuzzyFactor}},
+		{"porgarmming", map[string]float64{"programming": fuzzyFactor / 2}},
+		// too short for prefixes or typos
+		{"g", map[string]float64{}},
+		{"go", map[string]float64{"go": exactFactor}},
+		{"java", map[string]float64{}},
+	}
+
+	for _, c := range cases {
+		got := idx.expand(c.query, idx.terms)
+
+		if len(got) != len(c.want) {
+			t.Fatalf("expand(%q) = %v, want %v", c.query, got, c.want)
+		}
+
+		for term, factor := range c.want {
+			if got[term] != factor {
+				t.Fatalf("expand(%q) = %v, want %v", c.query, got, c.want)
+			}
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	idx := NewIndex(map[string]float64{"title": 3, "author": 1})
+	idx.Add("title", map[string]string{"title": "Effective Go", "author": "Someone"})
+	idx.Add("author", map[string]string{"title": "Systems", "author": "Rob Go"})
+	idx.Add("both", map[string]string{"title": "Go Patterns", "author": "Effective Press"})
+	idx.Add("none", map[string]string{"title": "Rust", "author": "Someone"})
+
+	ids := func(results []Result) []string {
+		ids := []string{}
+
+		for _, result := range results {
+			ids = append(ids, result.ID)
+		}
+
+		return ids
+	}
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		// title matches weigh more than author ones, ties go by id
+		{"go", []string{"both", "title", "author"}},
+		// matching every query word beats matching one of them well
+		{"effective go", []string{"title", "both", "author"}},
+		// a typo finds the same document
+		{"rust", []string{"none"}},
+		{"rsut", []string{"none"}},
+		{"", []string{}},
+	}
+
+	for _, c := range cases {
+		if got := ids(idx.Rank(c.query)); !slices.Equal(got, c.want) {
+			t.Fatalf("Rank(%q) = %v, want %v", c.query, got, c.want)
+		}
+	}
+
+	// a removed document is no longer found, a replaced one only by its new text
+	idx.Remove("none")
+	idx.Add("title", map[string]string{"title": "Effective Rust"})
+
+	if got := ids(idx.Rank("rust")); !slices.Equal(got, []string{"title"}) {
+		t.Fatalf("Rank after writes = %v", got)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	idx := NewIndex(nil)
+	idx.Add("1", map[string]string{"title": "The Go <Programming> Language", "author": "Alan Donovan"})
+	results := idx.Rank("go progr")
+
+	if len(results) != 1 {
+		t.Fatalf("expected one result, got %v", results)
+	}
+
+	highlights := idx.Highlight(results[0])
+
+	if highlights["title"] != "The <mark>Go</mark> &lt;<mark>Programming</mark>&gt; Language" {
+		t.Fatalf("unexpected title highlight %q", highlights["title"])
+	}
+
+	if _, ok := highlights["author"]; ok {
+		t.Fatalf("a field without matches was highlighted: %v", highlights)
+	}
+}
+
+// Searches run alongside writes, run with -race
+func TestConcurrentRank(t *testing.T) {
+	idx := NewIndex(nil)
+	var wg sync.WaitGroup
+
+	for i := range 4 {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range 50 {
+				idx.Add(strconv.Itoa(i*100+j), map[string]string{"title": "book " + strconv.Itoa(j)})
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for range 50 {
+				idx.Rank("book")
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if got := len(idx.Rank("book")); got != 200 {
+		t.Fatalf("expected every book found, got %d", got)
+	}
+}
@@ -0,0 +1,172 @@
+// Package validation reports every problem of a request payload at once,
+// each tied to the field it concerns.
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Rules a field can break
+const (
+	RuleRequired  = "required"
+	RuleSyntax    = "syntax"
+	RuleType      = "type"
+	RuleUnknown   = "unknown_field"
+	RuleMin       = "min"
+	RuleMax       = "max"
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleFormat    = "format"
+	RuleExists    = "exists"
+)
+
+// FieldError is a rule broken by one field. An empty field is the payload
+// as a whole.
+// @Description Field path, broken rule and a readable message
+type FieldError struct {
+	Field   string `json:"field" example:"published_year"`
+	Rule    string `json:"rule" example:"max"`
+	Message string `json:"message" example:"published_year must be at most 2025"`
+}
+
+// Errors lists the problems of a payload
+type Errors []FieldError
+
+func (errs Errors) Error() string {
+	messages := make([]string, len(errs))
+
+	for i, err := range errs {
+		messages[i] = err.Message
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (errs *Errors) Add(field string, rule string, message string) {
+	*errs = append(*errs, FieldError{Field: field, Rule: rule, Message: message})
+}
+
+// Err returns the errors as an error, nil when there are none
+func (errs Errors) Err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// Required reports an empty string
+func (errs *Errors) Required(field string, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		errs.Add(field, RuleRequired, field+" is required")
+		return false
+	}
+
+	return true
+}
+
+// Length reports a string shorter than min or longer than max characters
+func (errs *Errors) Length(field string, value string, min int, max int) {
+	length := len([]rune(value))
+
+	if length < min {
+		errs.Add(field, RuleMinLength, fmt.Sprintf("%s must be at least %d characters long", field, min))
+	}
+
+	if length > max {
+		errs.Add(field, RuleMaxLength, fmt.Sprintf("%s must be at most %d characters long", field, max))
+	}
+}
+
+// Range reports a number below min or above max
+func (errs *Errors) Range(field string, value int, min int, max int) {
+	if value < min {
+		errs.Add(field, RuleMin, fmt.Sprintf("%s must be at least %d", field, min))
+	}
+
+	if value > max {
+		errs.Add(field, RuleMax, fmt.Sprintf("%s must be at most %d", field, max))
+	}
+}
+
+// From returns the field errors of err, or err as a single error about the
+// payload as a whole
+func From(err error) Errors {
+	var errs Errors
+
+	if !errors.As(err, &errs) {
+		errs = Errors{{Rule: RuleFormat, Message: err.Error()}}
+	}
+
+	return errs
+}
+
+func typeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+
+	return "an object"
+}
+
+// DecodeJSON reads one JSON value into target, rejecting fields target does
+// not have. Decoding problems are returned as Errors.
+func DecodeJSON(body io.Reader, target any) error {
+	errs := Errors{}
+
+	if body == nil {
+		errs.Add("", RuleRequired, "request body is required")
+		return errs
+	}
+
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(target)
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var tooLarge *http.MaxBytesError
+
+	switch {
+	case err == nil:
+		if decoder.More() {
+			errs.Add("", RuleSyntax, "request body must hold a single JSON value")
+		}
+	case errors.Is(err, io.EOF):
+		errs.Add("", RuleRequired, "request body is required")
+	case errors.As(err, &tooLarge):
+		errs.Add("", RuleMaxLength, fmt.Sprintf("request body must be at most %d bytes", tooLarge.Limit))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		errs.Add("", RuleSyntax, "request body is not valid JSON: unexpected end")
+	case errors.As(err, &syntaxErr):
+		errs.Add("", RuleSyntax, fmt.Sprintf("request body is not valid JSON at offset %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		errs.Add(typeErr.Field, RuleType, fmt.Sprintf("%s must be %s", typeErr.Field, typeName(typeErr.Type.Kind())))
+	case errors.As(err, &typeErr):
+		errs.Add("", RuleType, "request body must be "+typeName(typeErr.Type.Kind()))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// the decoder has no error type for unknown fields
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		errs.Add(field, RuleUnknown, "unknown field "+field)
+	default:
+		errs.Add("", RuleFormat, err.Error())
+	}
+
+	return errs.Err()
+}